@@ -0,0 +1,300 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Command gorate runs rate limited HTTP load tests.
+//
+// Usage:
+//
+//	gorate [flags]                  runs the scenario set by the flags (and the -config file)
+//	gorate validate [flags] <file>  validates the scenario file and prints the resolved scenario
+//	gorate timeline <file>          converts the timeline file (see -timeline) to CSV
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+	"github.com/devfacet/gorate/limiter/transport"
+)
+
+// cliOptions represents the command line options which aren't part of a scenario
+type cliOptions struct {
+	config           string
+	progress         bool
+	progressInterval time.Duration
+	output           string
+	dryRun           bool
+	timeline         string
+	startAt          string
+	ntp              string
+}
+
+// headerFlags represents the repeatable header flag
+type headerFlags []string
+
+// String implements the flag.Value interface
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+// Set implements the flag.Value interface
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("invalid header %q (expected name: value)", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+func main() {
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		err = validateCmd(os.Args[2:], os.Stdout, os.Stderr)
+	} else if len(os.Args) > 1 && os.Args[1] == "timeline" {
+		err = timelineCmd(os.Args[2:], os.Stdout, os.Stderr)
+	} else {
+		err = run(os.Args[1:], os.Stdout, os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gorate:", err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns the flag set which sets the given scenario and options.
+// The current values are the defaults so the flags override the values of a scenario file.
+func newFlagSet(name string, s *scenario, o *cliOptions, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.config, "config", o.config, "scenario file (JSON)")
+	fs.StringVar(&s.URL, "url", s.URL, "target url")
+	fs.StringVar(&s.Method, "method", s.Method, "request method")
+	fs.StringVar(&s.Body, "body", s.Body, "request body")
+	fs.Var((*headerFlags)(&s.Headers), "header", "request header (name: value, repeatable)")
+	fs.StringVar(&s.UnixSocket, "unix-socket", s.UnixSocket, "unix domain socket path to connect to")
+	fs.StringVar(&s.Protocol, "protocol", s.Protocol, "HTTP protocol (http1, http2 or h2c; default negotiated)")
+	fs.StringVar(&s.TLS.CertFile, "tls-cert", s.TLS.CertFile, "client certificate file (PEM)")
+	fs.StringVar(&s.TLS.KeyFile, "tls-key", s.TLS.KeyFile, "client key file (PEM)")
+	fs.StringVar(&s.TLS.CAFile, "tls-ca", s.TLS.CAFile, "CA certificates file (PEM)")
+	fs.BoolVar(&s.TLS.InsecureSkipVerify, "tls-insecure", s.TLS.InsecureSkipVerify, "skip the server certificate verification")
+	fs.StringVar(&s.TLS.ServerName, "tls-server-name", s.TLS.ServerName, "server name for SNI and verification")
+	fs.StringVar(&s.TLS.MinVersion, "tls-min-version", s.TLS.MinVersion, "min TLS version (1.0, 1.1, 1.2 or 1.3)")
+	fs.StringVar(&s.Proxy, "proxy", s.Proxy, "proxy url (default from the environment)")
+	fs.IntVar(&s.Pool.MaxIdleConns, "max-idle-conns", s.Pool.MaxIdleConns, "max idle connections (default 100)")
+	fs.IntVar(&s.Pool.MaxIdleConnsPerHost, "max-idle-conns-per-host", s.Pool.MaxIdleConnsPerHost, "max idle connections per host (default the concurrency)")
+	fs.IntVar(&s.Pool.MaxConnsPerHost, "max-conns-per-host", s.Pool.MaxConnsPerHost, "max connections per host (default no limit)")
+	fs.DurationVar((*time.Duration)(&s.Pool.IdleConnTimeout), "idle-conn-timeout", time.Duration(s.Pool.IdleConnTimeout), "idle connection timeout (default 90s)")
+	fs.BoolVar(&s.Pool.DisableKeepAlives, "disable-keep-alives", s.Pool.DisableKeepAlives, "disable the connection reuse")
+	fs.BoolVar(&s.FanOut, "fan-out", s.FanOut, "distribute the requests across all the resolved addresses of the target")
+	fs.StringVar(&s.PinIP, "pin-ip", s.PinIP, "send all the requests to the given address of the target")
+	fs.BoolVar(&s.Pool.DisableCompression, "disable-compression", s.Pool.DisableCompression, "disable the transparent gzip compression")
+	fs.UintVar(&s.QPS, "qps", s.QPS, "queries per second (0 for no limit)")
+	fs.UintVar(&s.Concurrency, "concurrency", s.Concurrency, "number of workers")
+	fs.UintVar(&s.Limit, "limit", s.Limit, "total number of queries")
+	fs.DurationVar((*time.Duration)(&s.Duration), "duration", time.Duration(s.Duration), "duration of the run")
+	fs.DurationVar((*time.Duration)(&s.Timeout), "timeout", time.Duration(s.Timeout), "request timeout")
+	fs.BoolVar(&o.progress, "progress", o.progress, "render the progress")
+	fs.DurationVar(&o.progressInterval, "progress-interval", o.progressInterval, "interval of the progress updates")
+	fs.StringVar(&o.output, "output", o.output, "output format (table, json, yaml or quiet)")
+	fs.BoolVar(&o.dryRun, "dry-run", o.dryRun, "print the dispatch plan without running it")
+	fs.StringVar(&o.timeline, "timeline", o.timeline, "write the timeline of the queries to the given file (see gorate timeline)")
+	fs.StringVar(&o.startAt, "start-at", o.startAt, "start the run at the given time (RFC 3339), e.g. to line up multiple hosts")
+	fs.StringVar(&o.ntp, "ntp", o.ntp, "correct the start time by the clock offset measured against the given NTP server")
+	return fs
+}
+
+// parseArgs parses the given arguments into a scenario.
+// When a scenario file is set, it's loaded first and the arguments are applied on top of it.
+func parseArgs(name string, args []string, stderr io.Writer) (scenario, cliOptions, error) {
+	s := defaultScenario()
+	o := cliOptions{progress: true, progressInterval: time.Second, output: outputTable}
+	if err := newFlagSet(name, &s, &o, stderr).Parse(args); err != nil {
+		return s, o, err
+	}
+	if o.config == "" {
+		return s, o, nil
+	}
+	s, err := loadScenario(o.config)
+	if err != nil {
+		return s, o, err
+	}
+	return s, o, newFlagSet(name, &s, &o, io.Discard).Parse(args)
+}
+
+// validateCmd validates a scenario file and prints the resolved scenario
+func validateCmd(args []string, stdout, stderr io.Writer) error {
+	// The scenario file is the positional argument after the flags
+	var s scenario
+	var o cliOptions
+	fs := newFlagSet("gorate validate", &s, &o, stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		return errors.New("usage: gorate validate [flags] <file>")
+	}
+	args = append([]string{"-config", fs.Arg(0)}, args[:len(args)-fs.NArg()]...)
+
+	s, _, err := parseArgs("gorate validate", args, stderr)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	return s.validate()
+}
+
+// run runs the scenario by the given arguments
+func run(args []string, stdout, stderr io.Writer) error {
+	s, o, err := parseArgs("gorate", args, stderr)
+	if err != nil {
+		return err
+	}
+	if err := s.validate(); err != nil {
+		return err
+	} else if !validOutput(o.output) {
+		return fmt.Errorf("invalid output format %q", o.output)
+	}
+	if o.dryRun {
+		return writePlan(stdout, o.output, newPlan(s))
+	}
+	var startAt time.Time
+	if o.startAt != "" {
+		if startAt, err = time.Parse(time.RFC3339Nano, o.startAt); err != nil {
+			return fmt.Errorf("invalid start time: %s", err)
+		}
+	}
+	var timeSource limiter.TimeSource
+	if o.ntp != "" {
+		if timeSource, err = limiter.NewNTPTimeSource(context.Background(), limiter.NTPOptions{Server: o.ntp}); err != nil {
+			return err
+		}
+	}
+
+	// Run the stages
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	var sink *limiter.TimelineSink
+	if o.timeline != "" {
+		if sink, err = limiter.NewTimelineFileSink(o.timeline); err != nil {
+			return err
+		}
+		defer sink.Close()
+	}
+	st := newStats(int(s.Concurrency))
+	callback := httpCallback(s, client, st)
+	var lims []*limiter.Limiter
+	var runErr error
+	for i, stg := range s.stages() {
+		lo := s.options(stg, callback)
+		if sink != nil {
+			lo.Sink = sink
+		}
+		if i == 0 {
+			lo.StartAt, lo.TimeSource = startAt, timeSource
+		}
+		lim, err := limiter.New(lo)
+		if err != nil {
+			return err
+		}
+		lims = append(lims, lim)
+		var pr *progress
+		if o.progress && o.output != outputQuiet {
+			pr = newProgress(stderr, lim, st, o.progressInterval)
+			pr.start()
+		}
+		runErr = lim.Run()
+		if pr != nil {
+			pr.stop()
+		}
+		if runErr != nil {
+			break
+		}
+	}
+
+	sum := newSummary(lims, st, runErr)
+	if f, ok := client.Transport.(*transport.FanOut); ok {
+		sum.IPs = newIPSummaries(f.Stats())
+	}
+	if err := writeSummary(stdout, o.output, sum); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if s.SLO != (slo{}) {
+		return s.SLO.check(sum)
+	} else if sum.Errors > 0 {
+		return fmt.Errorf("%d of %d requests failed", sum.Errors, sum.Queries)
+	}
+	return nil
+}
+
+// stats represents the request statistics the limiter doesn't track.
+// Failed requests don't stop the workers so they're counted here (index 0 is the total).
+type stats struct {
+	errors []atomic.Int64
+}
+
+// newStats creates a new stats by the given number of groups
+func newStats(groups int) *stats {
+	return &stats{errors: make([]atomic.Int64, groups+1)}
+}
+
+// addError counts an error for the given group
+func (s *stats) addError(groupID int) {
+	s.errors[groupID].Add(1)
+	s.errors[0].Add(1)
+}
+
+// numOfErrors returns the number of errors by the given group id (0 for the total)
+func (s *stats) numOfErrors(groupID int) int {
+	return int(s.errors[groupID].Load())
+}
+
+// httpCallback returns the callback which sends the request of the given scenario
+func httpCallback(s scenario, client *http.Client, st *stats) limiter.Callback {
+	return func(cbp limiter.CallbackParams) error {
+		var body io.Reader
+		if s.Body != "" {
+			body = strings.NewReader(s.Body)
+		}
+		req, err := http.NewRequestWithContext(cbp.Context, s.Method, s.URL, body)
+		if err != nil {
+			return err
+		}
+		for _, h := range s.Headers {
+			name, value, _ := strings.Cut(h, ":")
+			req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			st.addError(cbp.GroupID)
+			return nil
+		}
+		n, _ := io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		cbp.AddBytes(int(n))
+		cbp.SetStatusCode(res.StatusCode)
+		if res.StatusCode >= http.StatusBadRequest {
+			st.addError(cbp.GroupID)
+		}
+		return nil
+	}
+}
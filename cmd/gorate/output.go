@@ -0,0 +1,154 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+	"github.com/devfacet/gorate/limiter/transport"
+)
+
+// Output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputQuiet = "quiet"
+)
+
+// summary represents the summary of a run
+type summary struct {
+	RunID     string  `json:"run_id"`
+	Queries   int     `json:"queries"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Duration  string  `json:"duration"`
+	QPS       float64 `json:"qps"`
+	Bytes     int64   `json:"bytes"`
+	Error     string  `json:"error,omitempty"`
+	// IPs is the per address statistics of the fan-out mode
+	IPs []ipSummary `json:"ips,omitempty"`
+}
+
+// ipSummary represents the summary of an address
+type ipSummary struct {
+	IP       string      `json:"ip"`
+	Requests int         `json:"requests"`
+	Errors   int         `json:"errors"`
+	Statuses map[int]int `json:"statuses,omitempty"`
+}
+
+// newIPSummaries returns the address summaries by the given statistics, ordered by address
+func newIPSummaries(stats map[string]transport.IPStats) []ipSummary {
+	ips := make([]ipSummary, 0, len(stats))
+	for ip, s := range stats {
+		ips = append(ips, ipSummary{IP: ip, Requests: s.Requests, Errors: s.Errors, Statuses: s.Statuses})
+	}
+	sort.Slice(ips, func(i, j int) bool { return ips[i].IP < ips[j].IP })
+	return ips
+}
+
+// newSummary creates a new summary by the given stage limiters, stats and run error.
+// The run id is the one of the first stage.
+func newSummary(lims []*limiter.Limiter, st *stats, runErr error) summary {
+	var s summary
+	var since time.Duration
+	for i, lim := range lims {
+		r := lim.Report()
+		if i == 0 {
+			s.RunID = r.RunID
+		}
+		s.Queries += r.NumOfQueries
+		s.Bytes += r.NumOfBytes
+		since += r.Since
+	}
+	s.Errors = st.numOfErrors(0)
+	s.ErrorRate = errorRate(s.Errors, s.Queries)
+	s.Duration = since.Round(time.Millisecond).String()
+	if since > 0 {
+		s.QPS = float64(s.Queries) / since.Seconds()
+	}
+	if runErr != nil {
+		s.Error = runErr.Error()
+	}
+	return s
+}
+
+// validOutput returns whether the given output format is valid
+func validOutput(format string) bool {
+	switch format {
+	case outputTable, outputJSON, outputYAML, outputQuiet:
+		return true
+	}
+	return false
+}
+
+// writeSummary writes the given summary by the given output format
+func writeSummary(w io.Writer, format string, s summary) error {
+	switch format {
+	case outputQuiet:
+		return nil
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	// The summary is flat (but the address list) so the YAML and table outputs are written field by field
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	v, t := reflect.ValueOf(s), reflect.TypeOf(s)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		f := v.Field(i)
+		if (f.IsZero() && name == "error") || name == "ips" {
+			continue
+		}
+		switch {
+		case format == outputYAML && f.Kind() == reflect.String:
+			fmt.Fprintf(tw, "%s: %q\n", name, f.Interface())
+		case format == outputYAML:
+			fmt.Fprintf(tw, "%s: %v\n", name, f.Interface())
+		case f.Kind() == reflect.Float64:
+			fmt.Fprintf(tw, "%s\t%.2f\n", name, f.Interface())
+		default:
+			fmt.Fprintf(tw, "%s\t%v\n", name, f.Interface())
+		}
+	}
+	if len(s.IPs) > 0 && format == outputYAML {
+		fmt.Fprintln(tw, "ips:")
+		for _, ip := range s.IPs {
+			fmt.Fprintf(tw, "  - ip: %q\n    requests: %d\n    errors: %d\n    statuses: {%s}\n", ip.IP, ip.Requests, ip.Errors, formatStatuses(ip.Statuses, ": ", ", "))
+		}
+	} else if len(s.IPs) > 0 {
+		fmt.Fprintln(tw, "\nip\trequests\terrors\tstatuses")
+		for _, ip := range s.IPs {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", ip.IP, ip.Requests, ip.Errors, formatStatuses(ip.Statuses, "=", " "))
+		}
+	}
+	return tw.Flush()
+}
+
+// formatStatuses returns the given status counts ordered by status code
+func formatStatuses(statuses map[int]int, kv, sep string) string {
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%d%s%d", code, kv, statuses[code])
+	}
+	return strings.Join(parts, sep)
+}
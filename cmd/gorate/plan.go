@@ -0,0 +1,97 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+)
+
+// plan represents the dispatch plan of a scenario
+type plan struct {
+	Stages []planStage `json:"stages"`
+	// Duration is the expected duration (0 if unknown)
+	Duration string `json:"duration"`
+	// Queries is the expected number of queries (-1 if unbounded)
+	Queries int `json:"queries"`
+}
+
+// planStage represents a stage of a dispatch plan
+type planStage struct {
+	Stage    int    `json:"stage"`
+	Duration string `json:"duration"`
+	QPS      uint   `json:"qps"`
+	Workers  uint   `json:"workers"`
+	Queries  int    `json:"queries"`
+}
+
+// newPlan returns the dispatch plan of the given scenario
+func newPlan(s scenario) plan {
+	var p plan
+	var total time.Duration
+	for i, st := range s.stages() {
+		ps := planStage{Stage: i + 1, Duration: time.Duration(st.Duration).String(), QPS: st.QPS, Workers: s.Concurrency, Queries: -1}
+		if st.QPS > 0 && st.Duration > 0 {
+			ps.Queries = int(math.Ceil(float64(st.QPS) * time.Duration(st.Duration).Seconds()))
+		}
+		if s.Limit > 0 && (ps.Queries < 0 || ps.Queries > int(s.Limit)) {
+			ps.Queries = int(s.Limit)
+		}
+		if st.Duration == 0 && s.Limit > 0 && st.QPS > 0 {
+			ps.Duration = time.Duration(float64(s.Limit) / float64(st.QPS) * float64(time.Second)).String()
+		}
+		p.Stages = append(p.Stages, ps)
+		d, _ := time.ParseDuration(ps.Duration)
+		total += d
+		if ps.Queries < 0 || p.Queries < 0 {
+			p.Queries = -1
+		} else {
+			p.Queries += ps.Queries
+		}
+	}
+	p.Duration = total.String()
+	return p
+}
+
+// writePlan writes the given plan by the given output format
+func writePlan(w io.Writer, format string, p plan) error {
+	switch format {
+	case outputQuiet:
+		return nil
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	case outputYAML:
+		fmt.Fprintln(w, "stages:")
+		for _, st := range p.Stages {
+			fmt.Fprintf(w, "  - stage: %d\n    duration: %q\n    qps: %d\n    workers: %d\n    queries: %d\n",
+				st.Stage, st.Duration, st.QPS, st.Workers, st.Queries)
+		}
+		_, err := fmt.Fprintf(w, "duration: %q\nqueries: %d\n", p.Duration, p.Queries)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "stage\tduration\tqps\tworkers\tqueries")
+	for _, st := range p.Stages {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\n", st.Stage, st.Duration, planValue(int(st.QPS), 0, "unlimited"), st.Workers, planValue(st.Queries, -1, "unbounded"))
+	}
+	fmt.Fprintf(tw, "total\t%s\t\t\t%s\n", p.Duration, planValue(p.Queries, -1, "unbounded"))
+	return tw.Flush()
+}
+
+// planValue returns the given value or the given label if it's the special value
+func planValue(v, special int, label string) string {
+	if v == special {
+		return label
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,152 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+// progressBarWidth is the width of the progress bars
+const progressBarWidth = 30
+
+// progress represents the live progress renderer.
+// On a terminal the per-group and total lines are redrawn in place; otherwise (e.g. in CI)
+// a plain log line is written every interval.
+type progress struct {
+	w        io.Writer
+	lim      *limiter.Limiter
+	st       *stats
+	interval time.Duration
+	tty      bool
+	lines    int
+	last     []int
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// newProgress creates a new progress renderer
+func newProgress(w io.Writer, lim *limiter.Limiter, st *stats, interval time.Duration) *progress {
+	return &progress{
+		w:        w,
+		lim:      lim,
+		st:       st,
+		interval: interval,
+		tty:      isTerminal(w),
+		last:     make([]int, len(st.errors)),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// isTerminal returns whether the given writer is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// start starts rendering
+func (p *progress) start() {
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				p.render()
+				return
+			case <-ticker.C:
+				p.render()
+			}
+		}
+	}()
+}
+
+// stop renders the final progress and stops rendering
+func (p *progress) stop() {
+	close(p.done)
+	<-p.stopped
+}
+
+// render renders the current progress
+func (p *progress) render() {
+	since := p.lim.Since().Seconds()
+	if since == 0 {
+		return
+	}
+	if !p.tty {
+		n := p.lim.NumOfQueries()
+		fmt.Fprintf(p.w, "progress: %5.1f%% queries=%d qps=%.1f errors=%.1f%%\n",
+			p.lim.Progress(), n, float64(n)/since, errorRate(p.st.numOfErrors(0), n))
+		return
+	}
+
+	// Move the cursor back to the first line of the previous render
+	var b strings.Builder
+	if p.lines > 0 {
+		fmt.Fprintf(&b, "\033[%dA", p.lines)
+	}
+	lines := 0
+	for id := 1; id < len(p.last); id++ {
+		n := p.lim.NumOfQueriesByGroupID(id)
+		fmt.Fprintf(&b, "\033[2Kgroup %-4d %s queries=%-8d qps=%-8.1f errors=%.1f%%\n",
+			id, bar(float64(n-p.last[id])/p.interval.Seconds(), p.maxGroupRate()), n, float64(n)/since, errorRate(p.st.numOfErrors(id), n))
+		p.last[id] = n
+		lines++
+	}
+	n := p.lim.NumOfQueries()
+	fmt.Fprintf(&b, "\033[2Ktotal      %s queries=%-8d qps=%-8.1f errors=%.1f%% eta=%s\n",
+		bar(p.lim.Progress(), 100), n, float64(n)/since, errorRate(p.st.numOfErrors(0), n), p.lim.ETA().Round(time.Second))
+	lines++
+	p.lines = lines
+	io.WriteString(p.w, b.String())
+}
+
+// maxGroupRate returns the scale of the per-group rate bars
+func (p *progress) maxGroupRate() float64 {
+	if r := p.lim.RateLimit(); r > 0 {
+		return r / float64(len(p.last)-1)
+	}
+	max := 1.0
+	for id := 1; id < len(p.last); id++ {
+		if r := float64(p.lim.NumOfQueriesByGroupID(id)-p.last[id]) / p.interval.Seconds(); r > max {
+			max = r
+		}
+	}
+	return max
+}
+
+// bar returns a progress bar by the given value and max
+func bar(v, max float64) string {
+	n := 0
+	if max > 0 {
+		n = int(v / max * progressBarWidth)
+	}
+	if n > progressBarWidth {
+		n = progressBarWidth
+	} else if n < 0 {
+		n = 0
+	}
+	return "[" + strings.Repeat("#", n) + strings.Repeat(".", progressBarWidth-n) + "]"
+}
+
+// errorRate returns the percentage of the given errors
+func errorRate(errors, queries int) float64 {
+	if queries == 0 {
+		return 0
+	}
+	return float64(errors) / float64(queries) * 100
+}
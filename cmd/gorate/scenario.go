@@ -0,0 +1,275 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+	"github.com/devfacet/gorate/limiter/transport"
+)
+
+// Scenario defaults
+const (
+	defaultConcurrency = 10
+	defaultTimeout     = 30 * time.Second
+)
+
+// scenario represents a load test scenario. It's set by the flags or loaded from a JSON file.
+type scenario struct {
+	URL         string   `json:"url"`
+	Method      string   `json:"method"`
+	Headers     []string `json:"headers,omitempty"`
+	Body        string   `json:"body,omitempty"`
+	UnixSocket  string   `json:"unix_socket,omitempty"`
+	Protocol    string   `json:"protocol,omitempty"`
+	TLS         tlsConf  `json:"tls"`
+	Proxy       string   `json:"proxy,omitempty"`
+	Pool        poolConf `json:"pool"`
+	FanOut      bool     `json:"fan_out,omitempty"`
+	PinIP       string   `json:"pin_ip,omitempty"`
+	Concurrency uint     `json:"concurrency"`
+	QPS         uint     `json:"qps,omitempty"`
+	Limit       uint     `json:"limit,omitempty"`
+	Duration    duration `json:"duration,omitempty"`
+	Timeout     duration `json:"timeout"`
+	Stages      []stage  `json:"stages,omitempty"`
+	SLO         slo      `json:"slo"`
+}
+
+// tlsConf represents the TLS configuration of a scenario
+type tlsConf struct {
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	CAFile             string `json:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	MinVersion         string `json:"min_version,omitempty"`
+}
+
+// poolConf represents the connection pool configuration of a scenario.
+// The max idle connections per host default to the concurrency level so the workers reuse their connections.
+type poolConf struct {
+	MaxIdleConns        int      `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int      `json:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost     int      `json:"max_conns_per_host,omitempty"`
+	IdleConnTimeout     duration `json:"idle_conn_timeout,omitempty"`
+	DisableKeepAlives   bool     `json:"disable_keep_alives,omitempty"`
+	DisableCompression  bool     `json:"disable_compression,omitempty"`
+}
+
+// stage represents a stage of a load profile
+type stage struct {
+	Duration duration `json:"duration"`
+	QPS      uint     `json:"qps"`
+}
+
+// slo represents the service level objectives a run must meet
+type slo struct {
+	// MaxErrorRate is the max percentage of the failed requests (optional)
+	MaxErrorRate float64 `json:"max_error_rate,omitempty"`
+	// MinQPS is the min achieved QPS (optional)
+	MinQPS float64 `json:"min_qps,omitempty"`
+}
+
+// duration represents a duration which is encoded as a string (e.g. "1m30s")
+type duration time.Duration
+
+// MarshalJSON implements the json.Marshaler interface
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// defaultScenario returns the scenario with the default values
+func defaultScenario() scenario {
+	return scenario{Method: http.MethodGet, Concurrency: defaultConcurrency, Timeout: duration(defaultTimeout)}
+}
+
+// loadScenario loads the scenario from the given JSON file
+func loadScenario(path string) (scenario, error) {
+	s := defaultScenario()
+	f, err := os.Open(path)
+	if err != nil {
+		return s, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return s, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// client returns the HTTP client of the scenario
+func (s scenario) client() (*http.Client, error) {
+	minVersion, err := transport.ParseTLSVersion(s.TLS.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	maxIdlePerHost := s.Pool.MaxIdleConnsPerHost
+	if maxIdlePerHost == 0 {
+		maxIdlePerHost = int(s.Concurrency)
+	}
+	o := transport.BaseOptions{
+		UnixSocket: s.UnixSocket,
+		Protocol:   transport.Protocol(s.Protocol),
+		TLS: transport.TLSOptions{
+			CertFile:           s.TLS.CertFile,
+			KeyFile:            s.TLS.KeyFile,
+			CAFile:             s.TLS.CAFile,
+			InsecureSkipVerify: s.TLS.InsecureSkipVerify,
+			ServerName:         s.TLS.ServerName,
+			MinVersion:         minVersion,
+		},
+		Proxy:               s.Proxy,
+		MaxIdleConns:        s.Pool.MaxIdleConns,
+		MaxIdleConnsPerHost: maxIdlePerHost,
+		MaxConnsPerHost:     s.Pool.MaxConnsPerHost,
+		IdleConnTimeout:     time.Duration(s.Pool.IdleConnTimeout),
+		DisableKeepAlives:   s.Pool.DisableKeepAlives,
+		DisableCompression:  s.Pool.DisableCompression,
+	}
+	if s.FanOut || s.PinIP != "" {
+		f, err := transport.NewFanOut(transport.FanOutOptions{Base: o, Pin: s.PinIP})
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: f}, nil
+	}
+	t, err := transport.NewBase(o)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+// stages returns the stages of the scenario. A scenario without stages has a single one.
+func (s scenario) stages() []stage {
+	if len(s.Stages) > 0 {
+		return s.Stages
+	}
+	return []stage{{Duration: s.Duration, QPS: s.QPS}}
+}
+
+// options returns the limiter options of the given stage
+func (s scenario) options(st stage, callback limiter.Callback) limiter.Options {
+	return limiter.Options{
+		Name:         "gorate",
+		Concurrency:  uint32(s.Concurrency),
+		QPS:          uint32(st.QPS),
+		Limit:        uint32(s.Limit),
+		Duration:     time.Duration(st.Duration),
+		QueryTimeout: time.Duration(s.Timeout),
+		Callback:     callback,
+	}
+}
+
+// validate checks the scenario semantically and returns all the violations as a joined error
+func (s scenario) validate() error {
+	var errs []error
+
+	// Target
+	if s.URL == "" {
+		errs = append(errs, errors.New("url is required"))
+	} else if u, err := url.Parse(s.URL); err != nil {
+		errs = append(errs, fmt.Errorf("invalid url: %s", err))
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		errs = append(errs, fmt.Errorf("invalid url scheme %q", u.Scheme))
+	}
+	if _, err := s.client(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, h := range s.Headers {
+		if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("invalid header %q (expected name: value)", h))
+		}
+	}
+
+	// Profile
+	// The limiter values are 32-bit so the larger ones would wrap around silently
+	for _, v := range []struct {
+		name  string
+		value uint
+	}{
+		{"concurrency", s.Concurrency},
+		{"qps", s.QPS},
+		{"limit", s.Limit},
+	} {
+		if v.value > math.MaxUint32 {
+			errs = append(errs, fmt.Errorf("%s value must not exceed %d", v.name, uint32(math.MaxUint32)))
+		}
+	}
+	for i, st := range s.Stages {
+		if st.QPS > math.MaxUint32 {
+			errs = append(errs, fmt.Errorf("stage %d: qps value must not exceed %d", i+1, uint32(math.MaxUint32)))
+		}
+	}
+	if len(s.Stages) > 0 {
+		if s.Duration > 0 || s.QPS > 0 || s.Limit > 0 {
+			errs = append(errs, errors.New("stages can't be used with the qps, limit or duration values"))
+		}
+		for i, st := range s.Stages {
+			if st.Duration <= 0 {
+				errs = append(errs, fmt.Errorf("stage %d: duration must be greater than zero", i+1))
+			}
+		}
+	}
+	// The callback is only required by the validation
+	noop := func(cbp limiter.CallbackParams) error { return nil }
+	for i, st := range s.stages() {
+		if _, err := limiter.New(s.options(st, noop)); err != nil {
+			if len(s.Stages) > 0 {
+				err = fmt.Errorf("stage %d: %w", i+1, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	// SLOs
+	if s.SLO.MaxErrorRate < 0 || s.SLO.MaxErrorRate > 100 {
+		errs = append(errs, errors.New("slo max error rate must be between 0 and 100"))
+	}
+	if s.SLO.MinQPS < 0 {
+		errs = append(errs, errors.New("slo min qps must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// check returns an error if the given summary violates the SLOs
+func (o slo) check(s summary) error {
+	var errs []error
+	if o.MaxErrorRate > 0 && s.ErrorRate > o.MaxErrorRate {
+		errs = append(errs, fmt.Errorf("slo violated: error rate %.2f%% exceeds %.2f%%", s.ErrorRate, o.MaxErrorRate))
+	}
+	if o.MinQPS > 0 && s.QPS < o.MinQPS {
+		errs = append(errs, fmt.Errorf("slo violated: qps %.2f is below %.2f", s.QPS, o.MinQPS))
+	}
+	return errors.Join(errs...)
+}
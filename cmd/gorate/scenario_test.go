@@ -0,0 +1,94 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func FuzzLoadScenario(f *testing.F) {
+	// Seeds of the odd durations and the overflow values
+	for _, seed := range []string{
+		`{"url":"http://localhost","limit":100}`,
+		`{"url":"http://localhost","duration":"1m30s","qps":10}`,
+		`{"url":"http://localhost","duration":"-1s"}`,
+		`{"url":"http://localhost","duration":"0s","timeout":"0s","limit":1}`,
+		`{"url":"http://localhost","duration":"1.5µs","timeout":"1ns","limit":1}`,
+		`{"url":"http://localhost","duration":"2562047h47m16.854775807s"}`,
+		`{"url":"http://localhost","duration":"2562048h"}`,
+		`{"url":"http://localhost","duration":"9223372036854775808ns"}`,
+		`{"url":"http://localhost","duration":10}`,
+		`{"url":"http://localhost","duration":""}`,
+		`{"url":"http://localhost","concurrency":4294967296,"limit":1}`,
+		`{"url":"http://localhost","qps":18446744073709551615,"limit":1}`,
+		`{"url":"http://localhost","limit":-1}`,
+		`{"url":"http://localhost","stages":[{"duration":"1s","qps":4294967297}]}`,
+		`{"url":"http://localhost","stages":[{"duration":"-1h","qps":1},{}]}`,
+		`{"url":"http://localhost","slo":{"max_error_rate":1e309}}`,
+		`{"url":"http://localhost","pool":{"idle_conn_timeout":"-5m"},"limit":1}`,
+		`{"unknown":true}`,
+		`null`,
+		`[]`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		path := filepath.Join(t.TempDir(), "scenario.json")
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		s, err := loadScenario(path)
+		if err != nil {
+			return
+		}
+		if err := s.validate(); err != nil {
+			return
+		}
+
+		// A valid scenario can't be silently invalid
+		for _, v := range []uint{s.Concurrency, s.QPS, s.Limit} {
+			if v > math.MaxUint32 {
+				t.Fatalf("got %d, want a 32-bit value", v)
+			}
+		}
+		for _, st := range s.stages() {
+			if st.Duration < 0 || st.QPS > math.MaxUint32 {
+				t.Fatalf("got stage %+v", st)
+			}
+			if o := s.options(st, nil); o.Concurrency == 0 || o.Duration < 0 || o.QueryTimeout < 0 {
+				t.Fatalf("got options %+v", o)
+			}
+		}
+	})
+}
+
+func TestScenarioDuration(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{`"1m30s"`, 90 * time.Second, false},
+		{`"1.5µs"`, 1500 * time.Nanosecond, false},
+		{`"-1s"`, -time.Second, false},
+		{`"2562047h47m16.854775807s"`, math.MaxInt64, false},
+		{`"2562048h"`, 0, true},
+		{`10`, 0, true},
+		{`""`, 0, true},
+	} {
+		var d duration
+		err := d.UnmarshalJSON([]byte(tc.in))
+		if (err != nil) != tc.err {
+			t.Errorf("got %v for %s, want error %v", err, tc.in, tc.err)
+		} else if time.Duration(d) != tc.want {
+			t.Errorf("got %s for %s, want %s", time.Duration(d), tc.in, tc.want)
+		}
+	}
+}
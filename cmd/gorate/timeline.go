@@ -0,0 +1,61 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+// timelineCmd converts a timeline file to CSV
+func timelineCmd(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("gorate timeline", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		return errors.New("usage: gorate timeline <file>")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(stdout)
+	w.Write([]string{"sequence", "group_id", "scheduled", "start", "finish", "status_code", "failed"})
+	err = limiter.ReadTimeline(f, func(e limiter.TimelineEntry) error {
+		return w.Write([]string{
+			strconv.Itoa(e.Sequence),
+			strconv.Itoa(e.GroupID),
+			formatTime(e.Scheduled),
+			formatTime(e.Start),
+			formatTime(e.Finish),
+			strconv.Itoa(e.StatusCode),
+			strconv.FormatBool(e.Failed),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// formatTime returns the given time in the RFC 3339 format (empty for the zero time)
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
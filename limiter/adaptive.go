@@ -0,0 +1,119 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrThrottled is the error that callbacks can return (or wrap) to report that the target throttled the query
+var ErrThrottled = errors.New("throttled")
+
+const (
+	// adaptiveBeta is the multiplicative decrease factor applied on throttling
+	adaptiveBeta = 0.7
+	// adaptiveScale is the scale constant of the cubic increase function
+	adaptiveScale = 0.4
+	// adaptiveMinRate is the min rate of the adaptive mode
+	adaptiveMinRate = 0.5
+)
+
+// adaptive represents the state of the adaptive client-side rate limiting.
+// It follows the AWS SDK adaptive retry mode: the rate is decreased multiplicatively when
+// the target throttles and increased along a cubic curve (as in CUBIC congestion control) on success.
+type adaptive struct {
+	mu           sync.Mutex
+	lim          *rate.Limiter
+	max          float64
+	rate         float64
+	lastMaxRate  float64
+	lastThrottle time.Time
+	timeWindow   float64
+	isThrottle   func(err error) bool
+	throttles    atomic.Int64
+}
+
+// newAdaptive creates a new adaptive state by the given limiter and max rate
+func newAdaptive(lim *rate.Limiter, max float64, isThrottle func(err error) bool) *adaptive {
+	if isThrottle == nil {
+		isThrottle = func(err error) bool { return errors.Is(err, ErrThrottled) }
+	}
+	return &adaptive{
+		lim:          lim,
+		max:          max,
+		rate:         max,
+		lastMaxRate:  max,
+		lastThrottle: time.Now(),
+		isThrottle:   isThrottle,
+	}
+}
+
+// update updates the rate by the given callback error and returns whether it was a throttling error
+func (a *adaptive) update(err error) bool {
+	throttled := err != nil && a.isThrottle(err)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if throttled {
+		a.throttles.Add(1)
+		a.lastMaxRate = a.rate
+		a.lastThrottle = now
+		a.timeWindow = math.Cbrt(a.lastMaxRate * (1 - adaptiveBeta) / adaptiveScale)
+		a.rate = math.Max(a.rate*adaptiveBeta, adaptiveMinRate)
+	} else {
+		t := now.Sub(a.lastThrottle).Seconds()
+		a.rate = math.Min(adaptiveScale*math.Pow(t-a.timeWindow, 3)+a.lastMaxRate, a.max)
+		a.rate = math.Max(a.rate, adaptiveMinRate)
+	}
+	a.lim.SetLimitAt(now, rate.Limit(a.rate))
+
+	return throttled
+}
+
+// NumOfThrottles returns the number of queries throttled by the target (adaptive mode)
+func (limiter *Limiter) NumOfThrottles() int {
+	a := runState(limiter, &limiter.adaptive)
+	if a == nil {
+		return 0
+	}
+	return int(a.throttles.Load())
+}
+
+// SyncQuota adjusts the internal rate of a running limiter so the given number of remaining
+// queries is spread until the given reset time (e.g. from the rate limit headers of the target).
+// The rate never exceeds the QPS. It's a no-op if the limiter isn't running.
+func (limiter *Limiter) SyncQuota(remaining int, reset time.Time) {
+	lim := runState(limiter, &limiter.lim)
+	if lim == nil || remaining < 0 {
+		return
+	}
+	d := time.Until(reset)
+	if d <= 0 {
+		return
+	}
+	r := float64(remaining) / d.Seconds()
+	if limiter.qps > 0 && r > float64(limiter.qps) {
+		r = float64(limiter.qps)
+	}
+	lim.SetLimit(rate.Limit(r))
+}
+
+// RateLimit returns the current internal rate limit (queries per second).
+// It may differ from the QPS when the feedback controller or the adaptive mode is enabled.
+func (limiter *Limiter) RateLimit() float64 {
+	lim := runState(limiter, &limiter.lim)
+	if lim == nil {
+		return float64(limiter.qps)
+	}
+	return float64(lim.Limit())
+}
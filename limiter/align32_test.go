@@ -0,0 +1,84 @@
+//go:build 386 || arm || mips || mipsle
+
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// atomic64Types are the types whose atomic operations require 64-bit alignment
+var atomic64Types = map[reflect.Type]bool{
+	reflect.TypeOf(atomic.Int64{}):  true,
+	reflect.TypeOf(atomic.Uint64{}): true,
+}
+
+// checkAlignment checks the 64-bit alignment of the atomic fields of the given value, including
+// the ones of its nested structs, arrays and slices. The pointers aren't followed.
+func checkAlignment(t *testing.T, path string, v reflect.Value) {
+	t.Helper()
+	switch {
+	case atomic64Types[v.Type()]:
+		if addr := v.UnsafeAddr(); addr%8 != 0 {
+			t.Errorf("%s is at %#x, want 64-bit alignment", path, addr)
+		}
+	case v.Kind() == reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			checkAlignment(t, path+"."+v.Type().Field(i).Name, v.Field(i))
+		}
+	case v.Kind() == reflect.Array, v.Kind() == reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			checkAlignment(t, path+"[]", v.Index(i))
+		}
+	}
+}
+
+func TestAtomicAlignment(t *testing.T) {
+	limiter, err := New(Options{Concurrency: 3, QPS: 1000, Limit: 10, LatencyStats: true, Breakdown: true, WatchdogInterval: time.Millisecond,
+		Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+	kl, err := NewKeyed(KeyedOptions{QPS: 1, Shards: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kl.Close()
+	pl, err := NewPriority(PriorityOptions{QPS: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, v := range map[string]interface{}{
+		"Limiter":         limiter,
+		"groupCounters":   &limiter.counters,
+		"resource":        limiter.resources[0],
+		"latencyStats":    limiter.latencyStats,
+		"KeyedLimiter":    kl,
+		"keyedEntry":      kl.entry("key"),
+		"PriorityLimiter": pl,
+	} {
+		checkAlignment(t, name, reflect.ValueOf(v).Elem())
+	}
+}
+
+func TestGroupCountersLayout(t *testing.T) {
+	// The padding assumes the 64-bit counters come first so it's the same on the 32-bit platforms
+	if size := unsafe.Sizeof(groupCounters{}); size != cacheLineSize {
+		t.Errorf("got %d bytes, want %d", size, cacheLineSize)
+	}
+	if offset := unsafe.Offsetof(groupCounters{}.queries); offset != 24 {
+		t.Errorf("got queries at %d, want 24", offset)
+	}
+}
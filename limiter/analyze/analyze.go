@@ -0,0 +1,191 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package analyze provides an analyzer which consumes a stream of timestamps (from logs or live
+// traffic) and reports the observed QPS distribution, the burstiness and the limiter
+// configuration needed to admit a given percentage of it
+package analyze
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultWindow is the default window of the QPS distribution
+const defaultWindow = time.Second
+
+// Options represents the options that can be set when creating a new analyzer
+type Options struct {
+	// Window is the window the timestamps are counted by for the QPS distribution (default 1s)
+	Window time.Duration
+}
+
+// Result represents the result of an analysis
+type Result struct {
+	// Count is the number of timestamps
+	Count int
+	// Duration is the time between the first and the last timestamps
+	Duration time.Duration
+	// Window is the window of the QPS distribution
+	Window time.Duration
+	// MeanQPS is the mean QPS
+	MeanQPS float64
+	// P50QPS, P90QPS and P99QPS are the percentiles of the QPS per window
+	P50QPS, P90QPS, P99QPS float64
+	// MaxQPS is the max QPS per window
+	MaxQPS float64
+	// PeakToMean is the ratio of the max QPS to the mean QPS
+	PeakToMean float64
+	// Burstiness is the index of dispersion (variance / mean) of the counts per window.
+	// It's about 1 for Poisson traffic, lower for smooth and higher for bursty traffic.
+	Burstiness float64
+}
+
+// Recommendation represents a token bucket configuration
+type Recommendation struct {
+	// Rate is the rate (queries per second)
+	Rate float64
+	// Burst is the burst size
+	Burst int
+	// Window is the window the rate was chosen by
+	Window time.Duration
+	// Admitted is the percentage of the timestamps admitted by the configuration
+	Admitted float64
+}
+
+// Analyzer represents an analyzer
+type Analyzer struct {
+	window     time.Duration
+	mu         sync.Mutex
+	timestamps []time.Time
+	sorted     bool
+}
+
+// New creates a new analyzer by the given options
+func New(o Options) (*Analyzer, error) {
+	if o.Window < 0 {
+		return nil, errors.New("window value must be positive")
+	}
+	if o.Window == 0 {
+		o.Window = defaultWindow
+	}
+	return &Analyzer{window: o.Window, sorted: true}, nil
+}
+
+// Add adds the given timestamp. It's safe for concurrent use (e.g. from live traffic).
+func (a *Analyzer) Add(t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n := len(a.timestamps); n > 0 && t.Before(a.timestamps[n-1]) {
+		a.sorted = false
+	}
+	a.timestamps = append(a.timestamps, t)
+}
+
+// Len returns the number of timestamps
+func (a *Analyzer) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.timestamps)
+}
+
+// snapshot returns a copy of the sorted timestamps
+func (a *Analyzer) snapshot() []time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.sorted {
+		sort.Slice(a.timestamps, func(i, j int) bool { return a.timestamps[i].Before(a.timestamps[j]) })
+		a.sorted = true
+	}
+	return append([]time.Time(nil), a.timestamps...)
+}
+
+// counts returns the number of the given sorted timestamps per window
+func (a *Analyzer) counts(ts []time.Time) []int {
+	counts := make([]int, ts[len(ts)-1].Sub(ts[0])/a.window+1)
+	for _, t := range ts {
+		counts[t.Sub(ts[0])/a.window]++
+	}
+	return counts
+}
+
+// Result returns the result of the analysis
+func (a *Analyzer) Result() Result {
+	r := Result{Window: a.window}
+	ts := a.snapshot()
+	if len(ts) == 0 {
+		return r
+	}
+	r.Count, r.Duration = len(ts), ts[len(ts)-1].Sub(ts[0])
+
+	// Distribution
+	counts := a.counts(ts)
+	sort.Ints(counts)
+	perSec := func(c int) float64 { return float64(c) / a.window.Seconds() }
+	mean := float64(len(ts)) / float64(len(counts))
+	var variance float64
+	for _, c := range counts {
+		variance += (float64(c) - mean) * (float64(c) - mean)
+	}
+	variance /= float64(len(counts))
+	r.MeanQPS = perSec(1) * mean
+	r.P50QPS = perSec(percentile(counts, 50))
+	r.P90QPS = perSec(percentile(counts, 90))
+	r.P99QPS = perSec(percentile(counts, 99))
+	r.MaxQPS = perSec(counts[len(counts)-1])
+	r.PeakToMean = r.MaxQPS / r.MeanQPS
+	r.Burstiness = variance / mean
+	return r
+}
+
+// Recommend returns the token bucket configuration needed to admit the given percentage (0-100)
+// of the timestamps. The rate is the same percentile of the QPS per window and the burst is the
+// smallest one that admits the percentage at that rate.
+func (a *Analyzer) Recommend(percent float64) (Recommendation, error) {
+	if percent <= 0 || percent > 100 {
+		return Recommendation{}, errors.New("percent value must be between 0 and 100")
+	}
+	ts := a.snapshot()
+	if len(ts) == 0 {
+		return Recommendation{}, errors.New("no timestamps")
+	}
+	counts := a.counts(ts)
+	sort.Ints(counts)
+	rec := Recommendation{Window: a.window}
+	rec.Rate = math.Max(float64(percentile(counts, percent))/a.window.Seconds(), 1/a.window.Seconds())
+
+	// The number of the admitted timestamps never decreases with the burst so search for the smallest one
+	need := int(math.Ceil(float64(len(ts)) * percent / 100))
+	rec.Burst = sort.Search(len(ts), func(b int) bool { return admitted(ts, rec.Rate, b+1) >= need }) + 1
+	rec.Admitted = float64(admitted(ts, rec.Rate, rec.Burst)) / float64(len(ts)) * 100
+	return rec, nil
+}
+
+// admitted returns the number of the given sorted timestamps admitted by a token bucket
+// with the given rate and burst, which is full at the first timestamp
+func admitted(ts []time.Time, rate float64, burst int) int {
+	n, tokens, last := 0, float64(burst), ts[0]
+	for _, t := range ts {
+		tokens = math.Min(tokens+t.Sub(last).Seconds()*rate, float64(burst))
+		last = t
+		if tokens >= 1 {
+			tokens--
+			n++
+		}
+	}
+	return n
+}
+
+// percentile returns the given percentile of the given sorted counts (nearest rank)
+func percentile(counts []int, p float64) int {
+	i := int(math.Ceil(p/100*float64(len(counts)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return counts[i]
+}
@@ -0,0 +1,85 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	// autoTuneInterval is the interval of the concurrency auto-tuner
+	autoTuneInterval = time.Second
+	// autoTuneHeadroom is the multiplier applied to the estimated concurrency to absorb latency variance
+	autoTuneHeadroom = 1.2
+	// defaultMaxConcurrency is the default max concurrency level for the auto-tuner
+	defaultMaxConcurrency = 1024
+)
+
+// runAutoTune runs the concurrency auto-tuner until the given context is done.
+// Every interval it estimates the concurrency needed to sustain the target QPS by Little's law
+// (concurrency = QPS x latency) using the callback latency observed in the last interval.
+func (limiter *Limiter) runAutoTune(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastLatency, lastCount int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			latency, count := limiter.callbackLatency()
+			dl, dc := latency-lastLatency, count-lastCount
+			lastLatency, lastCount = latency, count
+			if dc == 0 {
+				continue
+			}
+			avg := time.Duration(dl / dc)
+			if !limiter.setConcurrency(EstimateConcurrency(float64(limiter.qps), avg, limiter.maxConcurrency)) {
+				return
+			}
+		}
+	}
+}
+
+// setConcurrency sets the target concurrency and starts the missing workers.
+// Only the unused slots and the workers parked by the auto-tuner are started, so the workers
+// stopped by an error or a limit stay done. It returns false if the run is over (no workers left).
+func (limiter *Limiter) setConcurrency(n uint32) bool {
+	limiter.workersMu.Lock()
+	defer limiter.workersMu.Unlock()
+	if limiter.numOfWorkers == 0 {
+		return false
+	}
+	limiter.targetConcurrency.Store(n)
+	for i := 1; i <= int(n); i++ {
+		if w := limiter.workers[i]; w == workerUnused || w == workerParked {
+			limiter.startWorker(i)
+		}
+	}
+	return true
+}
+
+// EstimateConcurrency returns the concurrency level needed to sustain the given QPS with
+// the given callback latency (Little's law with headroom), bounded by 1 and the given max.
+func EstimateConcurrency(qps float64, latency time.Duration, max uint32) uint32 {
+	n := math.Ceil(qps * latency.Seconds() * autoTuneHeadroom)
+	if n < 1 {
+		return 1
+	} else if n > float64(max) {
+		return max
+	}
+	return uint32(n)
+}
+
+// Concurrency returns the current concurrency level (number of running workers)
+func (limiter *Limiter) Concurrency() int {
+	limiter.workersMu.Lock()
+	defer limiter.workersMu.Unlock()
+	return limiter.numOfWorkers
+}
@@ -0,0 +1,85 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSetConcurrencyKeepsDoneWorkers(t *testing.T) {
+	limiter, err := New(Options{
+		AutoTune:       true,
+		Concurrency:    2,
+		MaxConcurrency: 4,
+		QPS:            100,
+		Duration:       300 * time.Millisecond,
+		GroupLimits:    map[int]GroupLimit{1: {Limit: 2}},
+		Callback:       func(cbp CallbackParams) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- limiter.Run() }()
+
+	// The group 1 is done by its limit before the scale up
+	time.Sleep(100 * time.Millisecond)
+	limiter.setConcurrency(3)
+	limiter.workersMu.Lock()
+	states := append([]workerState(nil), limiter.workers[1:4]...)
+	limiter.workersMu.Unlock()
+	if want := []workerState{workerDone, workerRunning, workerRunning}; !slices.Equal(states, want) {
+		t.Errorf("got worker states %v, want %v", states, want)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := limiter.counters[1].queries.Load(); got != 2 {
+		t.Errorf("got %d queries of the group 1, want 2", got)
+	}
+	if got := limiter.counters[3].queries.Load(); got == 0 {
+		t.Error("got no queries of the added group 3")
+	}
+}
+
+func TestSetConcurrencyRestartsParkedWorkers(t *testing.T) {
+	limiter, err := New(Options{
+		AutoTune:       true,
+		Concurrency:    3,
+		MaxConcurrency: 4,
+		QPS:            100,
+		Duration:       300 * time.Millisecond,
+		Callback:       func(cbp CallbackParams) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- limiter.Run() }()
+
+	// The worker 3 is parked by the scale down and started again by the scale up
+	time.Sleep(50 * time.Millisecond)
+	limiter.setConcurrency(2)
+	time.Sleep(100 * time.Millisecond)
+	limiter.workersMu.Lock()
+	parked := limiter.workers[3]
+	limiter.workersMu.Unlock()
+	if parked != workerParked {
+		t.Errorf("got worker state %v after the scale down, want parked", parked)
+	}
+	limiter.setConcurrency(3)
+	limiter.workersMu.Lock()
+	restarted := limiter.workers[3]
+	limiter.workersMu.Unlock()
+	if restarted != workerRunning {
+		t.Errorf("got worker state %v after the scale up, want running", restarted)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ResourceBandwidth is the name of the bandwidth (bytes per second) resource
+const ResourceBandwidth = "bandwidth"
+
+// usage represents the usage reported by a callback for a query
+type usage struct {
+	bytes  atomic.Int64
+	cost   atomicFloat64
+	status atomic.Int32
+}
+
+// AddBytes reports the given number of bytes transferred by the query.
+// When a bandwidth limit is set the bytes are charged to the bandwidth budget so the
+// following queries are delayed until the budget is paid back.
+func (cbp CallbackParams) AddBytes(n int) {
+	if n <= 0 || cbp.usage == nil {
+		return
+	}
+	cbp.usage.bytes.Add(int64(n))
+	cbp.Limiter.numOfBytes.Add(int64(n))
+	if lim := cbp.Limiter.bandwidth; lim != nil {
+		// Charge in chunks of the burst size since a reservation can't exceed it
+		now := time.Now()
+		burst := lim.Burst()
+		for n > 0 {
+			c := n
+			if c > burst {
+				c = burst
+			}
+			lim.ReserveN(now, c)
+			n -= c
+		}
+	}
+}
+
+// NumOfBytes returns the number of bytes reported by the callbacks
+func (limiter *Limiter) NumOfBytes() int64 {
+	return limiter.numOfBytes.Load()
+}
@@ -0,0 +1,92 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultBatchMaxWait is the default max wait of a batch
+const defaultBatchMaxWait = 100 * time.Millisecond
+
+// Batch returns a callback which accumulates up to the given number of admitted queries (or
+// until the given max wait passes since the first one) and invokes the given function once with
+// the batch. It's meant for targets with bulk endpoints where one call carries many items.
+// Every query of a batch blocks until the batch is done and gets its error, so the concurrency
+// level must be at least the batch size for the batches to fill up.
+func Batch(size int, maxWait time.Duration, fn func(batch []CallbackParams) error) Callback {
+	if maxWait <= 0 {
+		maxWait = defaultBatchMaxWait
+	}
+	b := &batcher{size: size, maxWait: maxWait, fn: fn}
+	return b.call
+}
+
+// batcher represents the state of a batch callback
+type batcher struct {
+	size    int
+	maxWait time.Duration
+	fn      func(batch []CallbackParams) error
+	mu      sync.Mutex
+	cur     *batch
+}
+
+// batch represents a batch of queries
+type batch struct {
+	items []CallbackParams
+	timer *time.Timer
+	done  chan struct{}
+	err   error
+}
+
+// call adds the given query to the current batch and waits for the batch
+func (b *batcher) call(cbp CallbackParams) error {
+	b.mu.Lock()
+	if b.cur == nil {
+		bt := &batch{done: make(chan struct{})}
+		bt.timer = time.AfterFunc(b.maxWait, func() { b.flush(bt) })
+		b.cur = bt
+	}
+	bt := b.cur
+	bt.items = append(bt.items, cbp)
+	if len(bt.items) >= b.size {
+		b.cur = nil
+		bt.timer.Stop()
+		b.mu.Unlock()
+		b.run(bt)
+	} else {
+		b.mu.Unlock()
+	}
+	<-bt.done
+	return bt.err
+}
+
+// flush runs the given batch unless it was already run
+func (b *batcher) flush(bt *batch) {
+	b.mu.Lock()
+	if b.cur != bt {
+		b.mu.Unlock()
+		return
+	}
+	b.cur = nil
+	b.mu.Unlock()
+	b.run(bt)
+}
+
+// run invokes the batch function and releases the queries of the batch.
+// A panic is recovered as the error of the batch since the timer triggered batches run outside
+// of the workers.
+func (b *batcher) run(bt *batch) {
+	defer func() {
+		if r := recover(); r != nil {
+			bt.err = fmt.Errorf("batch callback panic: %v", r)
+		}
+		close(bt.done)
+	}()
+	bt.err = b.fn(bt.items)
+}
@@ -0,0 +1,45 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchPanic(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		size    int
+		queries int
+	}{
+		{"size trigger", 3, 3},
+		{"timer trigger", 10, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			callback := Batch(tc.size, 20*time.Millisecond, func(batch []CallbackParams) error { panic("boom") })
+			errs := make([]error, tc.queries)
+			var wg sync.WaitGroup
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = callback(CallbackParams{Sequence: i + 1})
+				}(i)
+			}
+			wg.Wait()
+
+			// Every query of the batch gets the panic
+			for i, err := range errs {
+				if err == nil || !strings.Contains(err.Error(), "boom") {
+					t.Errorf("got error %v for query %d, want the panic", err, i+1)
+				}
+			}
+		})
+	}
+}
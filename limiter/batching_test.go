@@ -0,0 +1,53 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency uint32
+		qps         uint32
+		batchSize   uint32
+		limit       uint32
+		min, max    time.Duration
+	}{
+		{name: "default", concurrency: 1, qps: 200, limit: 100, min: 400 * time.Millisecond, max: 800 * time.Millisecond},
+		{name: "batched", concurrency: 1, qps: 200, batchSize: 10, limit: 100, min: 400 * time.Millisecond, max: 800 * time.Millisecond},
+		{name: "batched workers", concurrency: 4, qps: 200, batchSize: 10, limit: 100, min: 300 * time.Millisecond, max: 800 * time.Millisecond},
+		{name: "high rate", concurrency: 4, qps: 200000, batchSize: 200, limit: 40000, min: 150 * time.Millisecond, max: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := New(Options{
+				Concurrency: tt.concurrency,
+				QPS:         tt.qps,
+				BatchSize:   tt.batchSize,
+				Limit:       tt.limit,
+				Callback:    func(cbp CallbackParams) error { return nil },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			if err := limiter.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The batches keep the average rate at the QPS
+			if elapsed := time.Since(start); elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("got %d queries in %s, want between %s and %s", limiter.NumOfQueries(), elapsed, tt.min, tt.max)
+			}
+			if got := limiter.NumOfQueries(); got != int(tt.limit) {
+				t.Errorf("got %d queries, want %d", got, tt.limit)
+			}
+		})
+	}
+}
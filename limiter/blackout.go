@@ -0,0 +1,126 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Blackout represents a window during which the limiter doesn't dispatch queries
+type Blackout struct {
+	// Start is the start of the (first) window
+	Start time.Time
+	// End is the end of the (first) window
+	End time.Time
+	// Every repeats the window by the given interval (optional, e.g. 24h for a daily window).
+	// Recurring windows follow the absolute time so they shift by an hour across DST changes.
+	Every time.Duration
+}
+
+// validate validates the blackout window
+func (b Blackout) validate() error {
+	if !b.End.After(b.Start) {
+		return errors.New("blackout end must be after start")
+	} else if b.Every < 0 || (b.Every > 0 && b.Every <= b.End.Sub(b.Start)) {
+		return errors.New("blackout interval must be positive and longer than the window")
+	}
+	return nil
+}
+
+// occurrence returns the start of the last occurrence of the window at or before the given time
+func (b Blackout) occurrence(t time.Time) (time.Time, bool) {
+	if t.Before(b.Start) {
+		return time.Time{}, false
+	} else if b.Every == 0 {
+		return b.Start, true
+	}
+	k := t.Sub(b.Start) / b.Every
+	return b.Start.Add(k * b.Every), true
+}
+
+// maxBlackoutChain is the max number of chained windows followed by blackoutUntil
+const maxBlackoutChain = 64
+
+// blackoutUntil returns the end of the blackout at the given time, or zero if there's none
+func (limiter *Limiter) blackoutUntil(now time.Time) time.Time {
+	var until time.Time
+	for i, changed := 0, true; changed && i < maxBlackoutChain; i++ {
+		changed = false
+		t := now
+		if !until.IsZero() {
+			t = until
+		}
+		// Windows that adjoin or overlap are chained
+		for _, b := range limiter.blackouts {
+			start, ok := b.occurrence(t)
+			if !ok {
+				continue
+			}
+			if end := start.Add(b.End.Sub(b.Start)); end.After(t) && end.After(until) {
+				until, changed = end, true
+			}
+		}
+	}
+	return until
+}
+
+// waitBlackout blocks while the limiter is in a blackout window or until the given context is done.
+// It returns true if it waited for the window to end.
+func (limiter *Limiter) waitBlackout(ctx context.Context) bool {
+	until := limiter.blackoutUntil(time.Now())
+	if until.IsZero() {
+		return false
+	}
+	t := time.NewTimer(time.Until(until))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// BlackoutTime returns the time of the current (or last) run spent in blackout windows
+func (limiter *Limiter) BlackoutTime() time.Duration {
+	if len(limiter.blackouts) == 0 || limiter.start.IsZero() {
+		return 0
+	}
+	from := limiter.start
+	to := from.Add(limiter.Since())
+	var total time.Duration
+	for t := from; t.Before(to); {
+		until := limiter.blackoutUntil(t)
+		if until.IsZero() {
+			// Jump to the next window start
+			next := to
+			for _, b := range limiter.blackouts {
+				s, ok := b.occurrence(t)
+				switch {
+				case !ok:
+					s = b.Start
+				case b.Every > 0:
+					s = s.Add(b.Every)
+				default:
+					continue
+				}
+				if s.After(t) && s.Before(next) {
+					next = s
+				}
+			}
+			t = next
+			continue
+		}
+		if until.After(to) {
+			until = to
+		}
+		total += until.Sub(t)
+		t = until
+	}
+	return total
+}
@@ -0,0 +1,217 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/bits"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Error classes of the default classifier
+const (
+	ClassTimeout           = "timeout"
+	ClassCanceled          = "canceled"
+	ClassConnectionRefused = "connection_refused"
+	ClassConnectionReset   = "connection_reset"
+	ClassDNS               = "dns"
+	ClassTLS               = "tls"
+	Class4xx               = "4xx"
+	Class5xx               = "5xx"
+	ClassOther             = "other"
+)
+
+const (
+	// histSubBits is the number of the sub-bucket bits of the latency histograms (~6% relative error)
+	histSubBits = 4
+	// histSize is the number of the buckets of the latency histograms
+	histSize = (1 << histSubBits) * (64 - histSubBits)
+)
+
+// ClassStats represents the statistics of a status code or an error class
+type ClassStats struct {
+	// Count is the number of queries
+	Count int
+	// P50, P90 and P99 are the latency percentiles
+	P50, P90, P99 time.Duration
+}
+
+// Breakdown represents the breakdown of the queries by status code and error class
+type Breakdown struct {
+	// StatusCodes is the breakdown by the status code reported by the callbacks (e.g. HTTP status or gRPC code)
+	StatusCodes map[int]ClassStats
+	// ErrorClasses is the breakdown of the failed queries (and the 4xx and 5xx status codes) by error class
+	ErrorClasses map[string]ClassStats
+}
+
+// SetStatusCode reports the status code of the query (e.g. HTTP status or gRPC code) for the breakdown.
+// When the callback succeeds, the 4xx and 5xx HTTP status codes are counted as error classes as well.
+func (cbp CallbackParams) SetStatusCode(code int) {
+	if cbp.usage != nil {
+		cbp.usage.status.Store(int32(code))
+	}
+}
+
+// ClassifyError returns the error class of the given callback error and status code, or an empty
+// string if it isn't a failure. It's the default classifier of the breakdown.
+func ClassifyError(err error, statusCode int) string {
+	if err == nil {
+		switch {
+		case statusCode >= 500 && statusCode < 600:
+			return Class5xx
+		case statusCode >= 400 && statusCode < 500:
+			return Class4xx
+		}
+		return ""
+	}
+
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrQueryTimeout):
+		return ClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ClassConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET):
+		return ClassConnectionReset
+	case errors.As(err, &dnsErr):
+		return ClassDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr),
+		errors.As(err, &unknownAuthErr), errors.As(err, &hostnameErr):
+		return ClassTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ClassTimeout
+	}
+	return ClassOther
+}
+
+// classifyError returns the error class of the given callback error and status code by the
+// error classifier, falling back to the default one
+func (limiter *Limiter) classifyError(err error, statusCode int) string {
+	if err != nil && limiter.errorClassifier != nil {
+		if class := limiter.errorClassifier(err); class != "" {
+			return class
+		}
+	}
+	return ClassifyError(err, statusCode)
+}
+
+// breakdown represents the state of the breakdown
+type breakdown struct {
+	mu       sync.Mutex
+	statuses map[int]*histogram
+	classes  map[string]*histogram
+}
+
+// newBreakdown creates a new breakdown state
+func newBreakdown() *breakdown {
+	return &breakdown{statuses: make(map[int]*histogram), classes: make(map[string]*histogram)}
+}
+
+// add records a query by the given status code, error class and latency
+func (b *breakdown) add(status int, class string, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if status != 0 {
+		h, ok := b.statuses[status]
+		if !ok {
+			h = &histogram{}
+			b.statuses[status] = h
+		}
+		h.add(latency)
+	}
+	if class != "" {
+		h, ok := b.classes[class]
+		if !ok {
+			h = &histogram{}
+			b.classes[class] = h
+		}
+		h.add(latency)
+	}
+}
+
+// Breakdown returns the breakdown of the current (or last) run by status code and error class.
+// It's empty unless Options.Breakdown is set.
+func (limiter *Limiter) Breakdown() Breakdown {
+	b := Breakdown{StatusCodes: map[int]ClassStats{}, ErrorClasses: map[string]ClassStats{}}
+	bd := runState(limiter, &limiter.breakdown)
+	if bd == nil {
+		return b
+	}
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	for code, h := range bd.statuses {
+		b.StatusCodes[code] = h.stats()
+	}
+	for class, h := range bd.classes {
+		b.ErrorClasses[class] = h.stats()
+	}
+	return b
+}
+
+// histogram represents a log-linear latency histogram with a bounded relative error
+type histogram struct {
+	counts [histSize]uint32
+	n      int
+}
+
+// histIndex returns the bucket index of the given latency
+func histIndex(d time.Duration) int {
+	if d < 1<<histSubBits {
+		return max(int(d), 0)
+	}
+	e := bits.Len64(uint64(d)) - histSubBits - 1
+	return (e+1)<<histSubBits + int(uint64(d)>>e) - 1<<histSubBits
+}
+
+// histValue returns the upper bound of the given bucket
+func histValue(i int) time.Duration {
+	if i < 1<<histSubBits {
+		return time.Duration(i)
+	}
+	e := i>>histSubBits - 1
+	m := uint64(i&(1<<histSubBits-1) + 1<<histSubBits)
+	return time.Duration((m+1)<<e - 1)
+}
+
+// add adds the given latency
+func (h *histogram) add(d time.Duration) {
+	h.counts[histIndex(d)]++
+	h.n++
+}
+
+// quantile returns the given quantile (0-1)
+func (h *histogram) quantile(q float64) time.Duration {
+	rank := int(q*float64(h.n) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for i, c := range h.counts {
+		if seen += int(c); seen >= rank {
+			return histValue(i)
+		}
+	}
+	return 0
+}
+
+// stats returns the class statistics of the histogram
+func (h *histogram) stats() ClassStats {
+	return ClassStats{Count: h.n, P50: h.quantile(0.5), P90: h.quantile(0.9), P99: h.quantile(0.99)}
+}
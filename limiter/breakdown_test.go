@@ -0,0 +1,136 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		want   string
+	}{
+		{name: "success", status: 200},
+		{name: "no status"},
+		{name: "4xx", status: 404, want: Class4xx},
+		{name: "5xx", status: 503, want: Class5xx},
+		{name: "deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), want: ClassTimeout},
+		{name: "query timeout", err: ErrQueryTimeout, want: ClassTimeout},
+		{name: "canceled", err: context.Canceled, want: ClassCanceled},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: ClassConnectionRefused},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, want: ClassConnectionReset},
+		{name: "dns", err: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, want: ClassDNS},
+		{name: "tls", err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, want: ClassTLS},
+		{name: "x509", err: x509.UnknownAuthorityError{}, want: ClassTLS},
+		{name: "net timeout", err: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, want: ClassTimeout},
+		{name: "other", err: errors.New("boom"), status: 200, want: ClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err, tt.status); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	// The bucket bounds are within the relative error of the values
+	for _, d := range []time.Duration{0, 1, 15, 16, 17, 100, time.Microsecond, 1234567, time.Second, time.Hour} {
+		v := histValue(histIndex(d))
+		if v < d || float64(v-d) > float64(d)/(1<<histSubBits)+1 {
+			t.Errorf("got bucket bound %d for %d", v, d)
+		}
+	}
+
+	var h histogram
+	for i := 1; i <= 100; i++ {
+		h.add(time.Duration(i) * time.Millisecond)
+	}
+	s := h.stats()
+	if s.Count != 100 {
+		t.Errorf("got count %d, want 100", s.Count)
+	}
+	for _, tc := range []struct {
+		got, want time.Duration
+	}{{s.P50, 50 * time.Millisecond}, {s.P90, 90 * time.Millisecond}, {s.P99, 99 * time.Millisecond}} {
+		if tc.got < tc.want || tc.got > tc.want+tc.want/(1<<histSubBits) {
+			t.Errorf("got percentile %s, want ~%s", tc.got, tc.want)
+		}
+	}
+}
+
+func TestBreakdown(t *testing.T) {
+	statuses := []int{200, 429, 503}
+	limiter, err := New(Options{
+		Concurrency: 1,
+		Limit:       30,
+		Breakdown:   true,
+		Callback: func(cbp CallbackParams) error {
+			cbp.SetStatusCode(statuses[cbp.Sequence%len(statuses)])
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The 4xx and 5xx status codes are error classes as well
+	b := limiter.Report().Breakdown
+	for _, code := range statuses {
+		if got := b.StatusCodes[code].Count; got != 10 {
+			t.Errorf("got %d queries of status %d, want 10", got, code)
+		}
+	}
+	if len(b.ErrorClasses) != 2 || b.ErrorClasses[Class4xx].Count != 10 || b.ErrorClasses[Class5xx].Count != 10 {
+		t.Errorf("got error classes %v, want 10 of 4xx and 5xx", b.ErrorClasses)
+	}
+}
+
+func TestBreakdownErrorClassifier(t *testing.T) {
+	errQuota := errors.New("quota exceeded")
+	limiter, err := New(Options{
+		Concurrency: 2,
+		Limit:       10,
+		ErrorClassifier: func(err error) string {
+			if errors.Is(err, errQuota) {
+				return "quota"
+			}
+			return ""
+		},
+		Callback: func(cbp CallbackParams) error {
+			if cbp.GroupID == 1 {
+				return errQuota
+			}
+			return context.Canceled
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = limiter.Run()
+
+	// The classifier falls back to the default classes
+	b := limiter.Breakdown()
+	if b.ErrorClasses["quota"].Count != 1 || b.ErrorClasses[ClassCanceled].Count != 1 {
+		t.Errorf("got error classes %v, want 1 of quota and canceled", b.ErrorClasses)
+	}
+}
@@ -0,0 +1,34 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// sleep waits for the given duration or until the given context is done.
+// In the busy-poll mode the last part of the wait is spun instead of slept, since the timers
+// may fire tens of microseconds late. The given timer is reused for the sleep.
+func (limiter *Limiter) sleep(ctx context.Context, d time.Duration, timer *time.Timer) error {
+	deadline := time.Now().Add(d)
+	if d -= limiter.busyPoll; d > 0 {
+		resetTimer(timer, d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+	}
+	return nil
+}
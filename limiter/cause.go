@@ -0,0 +1,73 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrStopped is the cause of a run stopped by Stop or the cancel function
+	ErrStopped = errors.New("limiter stopped")
+	// ErrSignal is the cause of a run stopped by a signal
+	ErrSignal = errors.New("limiter received signal")
+	// ErrQueryLimit is the cause of a run ended by reaching the query limit
+	ErrQueryLimit = errors.New("query limit reached")
+	// ErrDurationLimit is the cause of a run ended by reaching the duration limit
+	ErrDurationLimit = errors.New("duration limit reached")
+	// ErrQueryTimeout is the cause of a query context canceled by the query timeout
+	ErrQueryTimeout = errors.New("query timeout reached")
+)
+
+// NumOfTimeouts returns the number of queries that failed after reaching the query timeout
+func (limiter *Limiter) NumOfTimeouts() int {
+	return int(limiter.timeouts.Load())
+}
+
+// Stop stops the running limiter. The cause of the run context is ErrStopped.
+func (limiter *Limiter) Stop() {
+	limiter.StopWithCause(ErrStopped)
+}
+
+// StopWithCause stops the running limiter by the given cause (e.g. an operator action).
+// The cause is returned by context.Cause for the contexts passed to the callbacks.
+func (limiter *Limiter) StopWithCause(cause error) {
+	limiter.mu.RLock()
+	cancel := limiter.limCancelFunc
+	limiter.mu.RUnlock()
+	if cancel != nil {
+		cancel(cause)
+	}
+}
+
+// Cause returns the reason the run ended (or is ending), or nil if it's still running
+func (limiter *Limiter) Cause() error {
+	limiter.mu.RLock()
+	ctx := limiter.limContext
+	limiter.mu.RUnlock()
+	if ctx == nil {
+		return nil
+	}
+	if limiter.baseContext.Err() != nil {
+		return context.Cause(limiter.baseContext)
+	}
+	return context.Cause(ctx)
+}
+
+// endCause returns the cause of a run that ended by itself
+func (limiter *Limiter) endCause() error {
+	switch {
+	case limiter.isQueryLimit.Load():
+		return ErrQueryLimit
+	case limiter.isDeadline.Load():
+		return ErrDurationLimit
+	}
+	if err := limiter.LastError(); err != nil {
+		return err
+	}
+	return context.Canceled
+}
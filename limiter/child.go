@@ -0,0 +1,180 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ChildLimiter represents a limiter which borrows a bounded share of the budget of a parent
+// keyed limiter, e.g. for a background job which shares the budget of the interactive traffic.
+// Every query takes its token from the parent, and the child's own bucket caps the child at the
+// share of the parent's rate per key. The tokens of the abandoned queries are returned to both.
+// The buckets follow the keys of the parent: they're evicted with the parent's keys and resized
+// when the parent's limit of a key changes.
+type ChildLimiter struct {
+	parent   *KeyedLimiter
+	share    float64
+	mu       sync.Mutex
+	lims     map[string]*childBucket
+	allowed  atomic.Int64
+	rejected atomic.Int64
+}
+
+// childBucket represents the bucket of a key of a child limiter
+type childBucket struct {
+	// entry is the parent's entry of the key the bucket was sized by
+	entry *keyedEntry
+	lim   *rate.Limiter
+	qps   rate.Limit
+	burst int
+}
+
+var _ RateLimiter = (*ChildLimiter)(nil)
+
+// Child creates a new child limiter which borrows up to the given share (0-1, e.g. 0.2 for 20%)
+// of the rate of every key of the limiter
+func (kl *KeyedLimiter) Child(share float64) (*ChildLimiter, error) {
+	if share <= 0 || share > 1 {
+		return nil, errors.New("share value must be greater than zero and at most one")
+	}
+	cl := &ChildLimiter{parent: kl, share: share, lims: make(map[string]*childBucket)}
+	kl.childrenMu.Lock()
+	kl.children = append(kl.children, cl)
+	kl.childrenMu.Unlock()
+	return cl, nil
+}
+
+// limiter returns the bucket of the given key.
+// It's sized by the share of the parent's current limit of the key, and it's recreated if the
+// parent's key was evicted and seen again in the meantime.
+func (cl *ChildLimiter) limiter(key string) *rate.Limiter {
+	e := cl.parent.entry(key)
+	qps, burst := e.lim.Limit(), e.lim.Burst()
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	b, ok := cl.lims[key]
+	if !ok || b.entry != e {
+		b = &childBucket{entry: e, lim: rate.NewLimiter(cl.limit(qps), cl.burst(burst))}
+		cl.lims[key] = b
+	} else if b.qps != qps || b.burst != burst {
+		b.lim.SetLimit(cl.limit(qps))
+		b.lim.SetBurst(cl.burst(burst))
+	}
+	b.qps, b.burst = qps, burst
+	return b.lim
+}
+
+// limit returns the child's share of the given parent limit
+func (cl *ChildLimiter) limit(qps rate.Limit) rate.Limit {
+	return qps * rate.Limit(cl.share)
+}
+
+// burst returns the child's share of the given parent burst size (at least one)
+func (cl *ChildLimiter) burst(burst int) int {
+	return max(int(float64(burst)*cl.share), 1)
+}
+
+// evict removes the buckets of the given keys evicted by the parent
+func (cl *ChildLimiter) evict(keys []string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, k := range keys {
+		delete(cl.lims, k)
+	}
+}
+
+// Len returns the number of keys
+func (cl *ChildLimiter) Len() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return len(cl.lims)
+}
+
+// Reserve reserves a token for the given key from the child and the parent.
+// Canceling the reservation returns the token to both.
+func (cl *ChildLimiter) Reserve(key string) *Reservation {
+	return cl.reserveN(key, 1)
+}
+
+// reserveN reserves n tokens for the given key from the child and the parent
+func (cl *ChildLimiter) reserveN(key string, n int) *Reservation {
+	lim := cl.limiter(key)
+	now := time.Now()
+	cr := lim.ReserveN(now, n)
+	if !cr.OK() {
+		return &Reservation{}
+	}
+	pr := cl.parent.reserveN(key, n)
+	if !pr.OK() {
+		cr.CancelAt(now)
+		return &Reservation{delay: pr.Delay()}
+	}
+	return &Reservation{ok: true, delay: max(cr.DelayFrom(now), pr.Delay()), cancel: func() {
+		cr.Cancel()
+		pr.Cancel()
+	}}
+}
+
+// Allow reports whether a query for the given key may happen now
+func (cl *ChildLimiter) Allow(key string) bool {
+	lim := cl.limiter(key)
+	now := time.Now()
+	cr := lim.ReserveN(now, 1)
+	if !cr.OK() || cr.DelayFrom(now) > 0 || !cl.parent.Allow(key) {
+		cr.CancelAt(now)
+		cl.rejected.Add(1)
+		return false
+	}
+	cl.allowed.Add(1)
+	return true
+}
+
+// Wait blocks until a query for the given key may happen
+func (cl *ChildLimiter) Wait(ctx context.Context, key string) error {
+	return cl.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until n queries for the given key may happen.
+// The tokens are returned if the context is done first.
+func (cl *ChildLimiter) WaitN(ctx context.Context, key string, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := cl.reserveN(key, n)
+	if !r.OK() {
+		cl.rejected.Add(1)
+		return fmt.Errorf("rate: Wait(n=%d) exceeds the burst or the key is banned", n)
+	}
+	if d := r.Delay(); d > 0 {
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(time.Now().Add(d)) {
+			r.Cancel()
+			return waitDeadlineError{name: "rate", n: n}
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			r.Cancel()
+			return ctx.Err()
+		}
+	}
+	cl.allowed.Add(int64(n))
+	return nil
+}
+
+// Stats returns the statistics
+func (cl *ChildLimiter) Stats() Stats {
+	return Stats{Allowed: int(cl.allowed.Load()), Rejected: int(cl.rejected.Load())}
+}
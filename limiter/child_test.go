@@ -0,0 +1,67 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestChildEviction(t *testing.T) {
+	kl, err := NewKeyed(KeyedOptions{QPS: 10, Shards: 1, MaxKeys: 10, IdleTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kl.Close()
+	cl, err := kl.Child(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The child keys are capped with the parent's keys
+	for i := 0; i < 100; i++ {
+		cl.Allow(fmt.Sprintf("key-%d", i))
+	}
+	if got := cl.Len(); got != 10 {
+		t.Errorf("got %d child keys, want 10", got)
+	}
+
+	// The idle child keys are evicted with the parent's keys
+	kl.sweep(time.Now().Add(2 * time.Hour))
+	if got := cl.Len(); got != 0 {
+		t.Errorf("got %d child keys after the sweep, want 0", got)
+	}
+}
+
+func TestChildFollowsParentLimit(t *testing.T) {
+	kl, err := NewKeyed(KeyedOptions{QPS: 10, Burst: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := kl.Child(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		set   func()
+		qps   rate.Limit
+		burst int
+	}{
+		{"initial", func() {}, 5, 5},
+		{"set limit", func() { kl.SetLimit("key", 100, 40) }, 50, 20},
+		{"set plan", func() { kl.SetPlan("key", Plan{QPS: 2, Burst: 1}) }, 1, 1},
+	} {
+		tc.set()
+		lim := cl.limiter("key")
+		if lim.Limit() != tc.qps || lim.Burst() != tc.burst {
+			t.Errorf("%s: got child limit %v and burst %d, want %v and %d", tc.name, lim.Limit(), lim.Burst(), tc.qps, tc.burst)
+		}
+	}
+}
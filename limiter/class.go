@@ -0,0 +1,174 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// TokenClass represents the admission class of the queries of a class limiter
+type TokenClass int
+
+const (
+	// ClassGuaranteed is the class of the queries which always get up to the guaranteed rate
+	// (e.g. the user-facing traffic). Beyond it they compete with the best-effort queries.
+	ClassGuaranteed TokenClass = iota
+	// ClassBestEffort is the class of the queries which use whatever is left of the rate
+	// (e.g. the batch traffic)
+	ClassBestEffort
+)
+
+// String returns the name of the class
+func (c TokenClass) String() string {
+	switch c {
+	case ClassGuaranteed:
+		return "guaranteed"
+	case ClassBestEffort:
+		return "best_effort"
+	}
+	return fmt.Sprintf("class(%d)", int(c))
+}
+
+// ClassOptions represents the options that can be set when creating a new class limiter
+type ClassOptions struct {
+	// QPS is the limit for the number of queries per second of all the classes
+	QPS float64
+	// GuaranteedQPS is the rate the guaranteed queries always get
+	GuaranteedQPS float64
+	// Burst is the burst size (default 1)
+	Burst int
+}
+
+// ClassLimiter represents a limiter shared by the guaranteed and the best-effort queries, so the
+// mixed workloads can share one budget safely. The guaranteed queries take their tokens from their
+// own bucket and put the shared bucket into debt, so the best-effort queries get what is left.
+type ClassLimiter struct {
+	total      *rate.Limiter
+	guaranteed *rate.Limiter
+	stats      [2]struct{ allowed, rejected atomic.Int64 }
+}
+
+// NewClassLimiter creates a new class limiter by the given options
+func NewClassLimiter(o ClassOptions) (*ClassLimiter, error) {
+	if o.QPS <= 0 {
+		return nil, errors.New("qps value must be greater than zero")
+	} else if o.GuaranteedQPS < 0 || o.GuaranteedQPS > o.QPS {
+		return nil, errors.New("guaranteed qps value must be positive and must not exceed qps value")
+	} else if o.Burst < 0 {
+		return nil, errors.New("burst value must be positive")
+	}
+	if o.Burst == 0 {
+		o.Burst = 1
+	}
+	return &ClassLimiter{
+		total:      rate.NewLimiter(rate.Limit(o.QPS), o.Burst),
+		guaranteed: rate.NewLimiter(rate.Limit(o.GuaranteedQPS), o.Burst),
+	}, nil
+}
+
+// Allow reports whether a query of the given class may happen now
+func (cl *ClassLimiter) Allow(class TokenClass) bool {
+	if err := cl.check(class); err != nil {
+		return false
+	}
+	now := time.Now()
+	ok := false
+	if class == ClassGuaranteed && cl.guaranteed.AllowN(now, 1) {
+		cl.total.ReserveN(now, 1)
+		ok = true
+	} else {
+		ok = cl.total.AllowN(now, 1)
+	}
+	cl.count(class, ok)
+	return ok
+}
+
+// Wait blocks until a query of the given class may happen
+func (cl *ClassLimiter) Wait(ctx context.Context, class TokenClass) error {
+	return cl.WaitN(ctx, class, 1)
+}
+
+// WaitN blocks until n queries of the given class may happen.
+// A guaranteed wait goes ahead by whichever of its own and the shared budget is due first.
+func (cl *ClassLimiter) WaitN(ctx context.Context, class TokenClass, n int) error {
+	if err := cl.check(class); err != nil {
+		return err
+	}
+	if class == ClassBestEffort {
+		err := rateWaitError(ctx, cl.total, n, cl.total.WaitN(ctx, n))
+		cl.count(class, err == nil)
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Reserve from both budgets
+	now := time.Now()
+	reservations := [2]rate.Reservation{*cl.guaranteed.ReserveN(now, n), *cl.total.ReserveN(now, n)}
+	if !reservations[0].OK() || !reservations[1].OK() {
+		cancelReservations(reservations[:], now)
+		cl.count(class, false)
+		return fmt.Errorf("rate: Wait(n=%d) exceeds limiter's burst %d", n, cl.total.Burst())
+	}
+	delay := reservations[0].DelayFrom(now)
+	if d := reservations[1].DelayFrom(now); d < delay {
+		// The shared budget is due first so the guaranteed tokens are returned
+		delay = d
+		reservations[0].CancelAt(now)
+	}
+
+	// Wait
+	if delay > 0 {
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(delay)) {
+			cancelReservations(reservations[:], now)
+			cl.count(class, false)
+			return waitDeadlineError{name: "rate", n: n}
+		}
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			cancelReservations(reservations[:], time.Now())
+			cl.count(class, false)
+			return ctx.Err()
+		}
+	}
+	cl.count(class, true)
+	return nil
+}
+
+// check checks the given class
+func (cl *ClassLimiter) check(class TokenClass) error {
+	if class != ClassGuaranteed && class != ClassBestEffort {
+		return fmt.Errorf("unknown token class %d", int(class))
+	}
+	return nil
+}
+
+// count counts a check of the given class
+func (cl *ClassLimiter) count(class TokenClass, allowed bool) {
+	if allowed {
+		cl.stats[class].allowed.Add(1)
+	} else {
+		cl.stats[class].rejected.Add(1)
+	}
+}
+
+// Stats returns the statistics of the given class
+func (cl *ClassLimiter) Stats(class TokenClass) Stats {
+	if cl.check(class) != nil {
+		return Stats{}
+	}
+	return Stats{Allowed: int(cl.stats[class].allowed.Load()), Rejected: int(cl.stats[class].rejected.Load())}
+}
@@ -0,0 +1,116 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResourceCost is the name of the cost budget resource
+const ResourceCost = "cost"
+
+// costBudget represents a fixed window cost budget
+type costBudget struct {
+	mu     sync.Mutex
+	limit  float64
+	window time.Duration
+	start  time.Time
+	used   float64
+	total  atomicFloat64
+	wait   atomic.Int64
+}
+
+// add adds the given cost to the current window
+func (cb *costBudget) add(v float64) {
+	cb.mu.Lock()
+	cb.used += v
+	cb.mu.Unlock()
+	cb.total.add(v)
+}
+
+// acquire waits until the current window has budget left
+func (cb *costBudget) acquire(ctx context.Context) error {
+	for {
+		cb.mu.Lock()
+		now := time.Now()
+		if elapsed := now.Sub(cb.start); elapsed >= cb.window {
+			// Windows are aligned to the start of the run
+			cb.start = cb.start.Add(elapsed - elapsed%cb.window)
+			cb.used = 0
+		}
+		if cb.used < cb.limit {
+			cb.mu.Unlock()
+			return nil
+		}
+		next := cb.start.Add(cb.window)
+		cb.mu.Unlock()
+
+		// Wait for the next window
+		delay := next.Sub(now)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(next) {
+			return waitDeadlineError{name: "cost", n: 1}
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+			cb.wait.Add(int64(delay))
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// AddCost reports the given cost (e.g. dollars, credits or LLM tokens) of the query.
+// When a cost limit is set the following queries are held once the window's budget is used up.
+func (cbp CallbackParams) AddCost(v float64) {
+	if v <= 0 || cbp.usage == nil {
+		return
+	}
+	cbp.usage.cost.add(v)
+	if cb := cbp.Limiter.cost; cb != nil {
+		cb.add(v)
+	} else {
+		cbp.Limiter.totalCost.add(v)
+	}
+}
+
+// TotalCost returns the total cost reported by the callbacks
+func (limiter *Limiter) TotalCost() float64 {
+	if limiter.cost != nil {
+		return limiter.cost.total.load()
+	}
+	return limiter.totalCost.load()
+}
+
+// atomicFloat64 represents a float64 value that can be updated atomically
+type atomicFloat64 struct {
+	bits atomic.Uint64
+}
+
+// add adds the given value
+func (f *atomicFloat64) add(v float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// load returns the value
+func (f *atomicFloat64) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// store stores the given value
+func (f *atomicFloat64) store(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
@@ -0,0 +1,46 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"testing"
+)
+
+func TestTotalCostPerRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		costLimit float64
+	}{
+		{name: "without budget"},
+		{name: "with budget", costLimit: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := New(Options{
+				Concurrency: 1,
+				Limit:       5,
+				CostLimit:   tt.costLimit,
+				Callback: func(cbp CallbackParams) error {
+					cbp.AddCost(2)
+					return nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The total cost is reset at the start of each run
+			for run := 0; run < 2; run++ {
+				if err := limiter.Run(); err != nil {
+					t.Fatal(err)
+				}
+				if got := limiter.TotalCost(); got != 10 {
+					t.Errorf("run %d: got total cost %v, want 10", run, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"sync/atomic"
+)
+
+// cacheLineSize is the assumed size of a CPU cache line
+const cacheLineSize = 64
+
+// groupCounters represents the counters of a concurrency group.
+// It's padded to a cache line so the workers don't invalidate each other's counters. Index 0 holds only
+// the total number of queries, which is shared since it orders the queries (the sequence numbers) and
+// enforces the limit; the other totals are summed over the groups.
+type groupCounters struct {
+	// active, throttled and executing are the cumulative times in nanoseconds (see TimeAccounting)
+	active    atomic.Int64
+	throttled atomic.Int64
+	executing atomic.Int64
+	queries   atomic.Uint32
+	successes atomic.Uint32
+	failures  atomic.Uint32
+	_         [cacheLineSize - 36]byte
+}
+
+// groups returns the counters of the current (or last) run, or nil before the first run
+func (limiter *Limiter) groups() []groupCounters {
+	return runState(limiter, &limiter.counters)
+}
+
+// counter returns the counter loaded by the given function by the given group id (0 for the total)
+func (limiter *Limiter) counter(id int, load func(c *groupCounters) int64) int64 {
+	counters := limiter.groups()
+	if id < 0 || id >= len(counters) {
+		return 0
+	} else if id > 0 {
+		return load(&counters[id])
+	}
+	var n int64
+	for i := 1; i < len(counters); i++ {
+		n += load(&counters[i])
+	}
+	return n
+}
+
+// callbackLatency returns the total latency in nanoseconds and the number of the callbacks
+func (limiter *Limiter) callbackLatency() (int64, int64) {
+	if limiter.callback == nil {
+		return 0, 0
+	}
+	latency := limiter.counter(0, func(c *groupCounters) int64 { return c.executing.Load() })
+	count := limiter.counter(0, func(c *groupCounters) int64 { return int64(c.successes.Load()) + int64(c.failures.Load()) })
+	return latency, count
+}
+
+// runState returns the given per-run state of the limiter (e.g. the counters or the token bucket).
+// The state is replaced by every run under the lock, so the getters which may run concurrently
+// with the setup of a run read it under the lock too.
+func runState[T any](limiter *Limiter, state *T) T {
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
+	return *state
+}
@@ -0,0 +1,138 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+)
+
+// unpaddedCounters represents the counters of a group without the padding
+type unpaddedCounters struct {
+	active    atomic.Int64
+	throttled atomic.Int64
+	executing atomic.Int64
+	queries   atomic.Uint32
+	successes atomic.Uint32
+	failures  atomic.Uint32
+}
+
+func TestGroupCountersSize(t *testing.T) {
+	if size := unsafe.Sizeof(groupCounters{}); size != cacheLineSize {
+		t.Errorf("got %d bytes, want %d", size, cacheLineSize)
+	}
+}
+
+func TestCounterTotals(t *testing.T) {
+	limiter, err := New(Options{Concurrency: 4, Limit: 100, Callback: func(cbp CallbackParams) error {
+		if cbp.Sequence%10 == 0 {
+			return ErrThrottled
+		}
+		return nil
+	}, Adaptive: true, QPS: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var queries, successes, failures int
+	for id := 1; id <= 4; id++ {
+		queries += limiter.NumOfQueriesByGroupID(id)
+		successes += limiter.NumOfSuccessesByGroupID(id)
+		failures += limiter.NumOfFailuresByGroupID(id)
+	}
+	if queries != 100 || limiter.NumOfQueries() != queries {
+		t.Errorf("got %d queries (%d by group), want 100", limiter.NumOfQueries(), queries)
+	}
+	if limiter.NumOfSuccesses() != successes || limiter.NumOfFailures() != failures || successes+failures != 100 {
+		t.Errorf("got %d successes and %d failures (%d and %d by group), want the sums", limiter.NumOfSuccesses(), limiter.NumOfFailures(), successes, failures)
+	}
+	var executing int64
+	for _, ta := range limiter.GroupTimes() {
+		executing += int64(ta.Executing)
+	}
+	if total := limiter.TimeAccountingByGroupID(0); int64(total.Executing) != executing {
+		t.Errorf("got %s executing, want %d", total.Executing, executing)
+	}
+}
+
+// benchmarkCounters increments the counters of a group per goroutine, as the workers do
+func benchmarkCounters[T any](b *testing.B, inc func(c *T)) {
+	for _, procs := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("procs=%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			counters := make([]T, procs+1)
+			var next atomic.Int32
+			b.RunParallel(func(pb *testing.PB) {
+				c := &counters[int(next.Add(1))%len(counters)]
+				for pb.Next() {
+					inc(c)
+				}
+			})
+		})
+	}
+}
+
+// The per-query updates of a worker: the group counters, the time accounting and the shared total
+// of the sequence numbers
+
+func BenchmarkGroupCountersPadded(b *testing.B) {
+	var total atomic.Uint32
+	benchmarkCounters(b, func(c *groupCounters) {
+		c.queries.Add(1)
+		total.Add(1)
+		c.successes.Add(1)
+		c.active.Add(1)
+		c.executing.Add(1)
+	})
+}
+
+func BenchmarkGroupCountersUnpadded(b *testing.B) {
+	var total atomic.Uint32
+	benchmarkCounters(b, func(c *unpaddedCounters) {
+		c.queries.Add(1)
+		total.Add(1)
+		c.successes.Add(1)
+		c.active.Add(1)
+		c.executing.Add(1)
+	})
+}
+
+// The updates of the previous layout which kept every total at index 0 as well
+func BenchmarkGroupCountersSharedTotals(b *testing.B) {
+	var totals groupCounters
+	benchmarkCounters(b, func(c *groupCounters) {
+		c.queries.Add(1)
+		totals.queries.Add(1)
+		c.successes.Add(1)
+		totals.successes.Add(1)
+		c.active.Add(1)
+		totals.active.Add(1)
+		c.executing.Add(1)
+		totals.executing.Add(1)
+	})
+}
+
+func BenchmarkRunConcurrency(b *testing.B) {
+	for _, concurrency := range []uint32{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			b.ReportAllocs()
+			limiter, err := New(Options{Concurrency: concurrency, Limit: uint32(max(b.N, int(concurrency))), Callback: func(cbp CallbackParams) error { return nil }})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			if err := limiter.Run(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package dashboards provides generated dashboards for the metrics of the metrics package
+package dashboards
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/devfacet/gorate/limiter/metrics"
+)
+
+// GrafanaOptions represents the options that can be set when generating a Grafana dashboard
+type GrafanaOptions struct {
+	// Title is the title of the dashboard (default "gorate")
+	Title string
+	// UID is the unique id of the dashboard (default "gorate")
+	UID string
+	// Datasource is the name of the Prometheus datasource (default "Prometheus")
+	Datasource string
+	// RateInterval is the range of the rate queries (default "1m")
+	RateInterval string
+}
+
+// panel represents a Grafana panel
+type panel struct {
+	ID         int                    `json:"id"`
+	Title      string                 `json:"title"`
+	Type       string                 `json:"type"`
+	Datasource string                 `json:"datasource"`
+	GridPos    map[string]int         `json:"gridPos"`
+	Targets    []target               `json:"targets"`
+	FieldCfg   map[string]interface{} `json:"fieldConfig"`
+}
+
+// target represents a Grafana panel query
+type target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+// Grafana returns the JSON model of a Grafana dashboard for the limiter metrics.
+// The dashboard has the "limiter" and "run_id" variables to filter the limiters and their runs.
+func Grafana(o GrafanaOptions) ([]byte, error) {
+	if o.Title == "" {
+		o.Title = "gorate"
+	}
+	if o.UID == "" {
+		o.UID = "gorate"
+	}
+	if o.Datasource == "" {
+		o.Datasource = "Prometheus"
+	}
+	if o.RateInterval == "" {
+		o.RateInterval = "1m"
+	}
+
+	sel := `{limiter=~"$limiter",run_id=~"$run_id"}`
+	rate := func(name string) string { return fmt.Sprintf("rate(%s%s[%s])", name, sel, o.RateInterval) }
+	specs := []struct {
+		title string
+		unit  string
+		exprs [][2]string // expression, legend
+	}{
+		{"Queries per second", "reqps", [][2]string{
+			{rate(metrics.QueriesTotal), "{{limiter}} {{run_id}} attempts"},
+			{rate(metrics.SuccessesTotal), "{{limiter}} {{run_id}} successes"},
+			{rate(metrics.FailuresTotal), "{{limiter}} {{run_id}} failures"},
+		}},
+		{"Rate limit", "reqps", [][2]string{{metrics.RateLimit + sel, "{{limiter}} {{run_id}}"}}},
+		{"Throttles and skips", "reqps", [][2]string{
+			{rate(metrics.ThrottlesTotal), "{{limiter}} {{run_id}} throttles"},
+			{rate(metrics.SkipsTotal), "{{limiter}} {{run_id}} skips"},
+		}},
+		{"Concurrency", "short", [][2]string{{metrics.Concurrency + sel, "{{limiter}} {{run_id}}"}}},
+		{"Resource waits", "percentunit", [][2]string{{rate(metrics.ResourceWaitSecondsTotal), "{{limiter}} {{run_id}} {{resource}}"}}},
+		{"Throughput", "Bps", [][2]string{{rate(metrics.BytesTotal), "{{limiter}} {{run_id}}"}}},
+		{"Cost", "short", [][2]string{{rate(metrics.CostTotal), "{{limiter}} {{run_id}}"}}},
+		{"Progress", "percentunit", [][2]string{{metrics.Progress + sel, "{{limiter}} {{run_id}}"}}},
+		{"Health", "short", [][2]string{{metrics.Healthy + sel, "{{limiter}} {{run_id}}"}}},
+	}
+	panels := make([]panel, 0, len(specs))
+	for i, s := range specs {
+		p := panel{
+			ID:         i + 1,
+			Title:      s.title,
+			Type:       "timeseries",
+			Datasource: o.Datasource,
+			GridPos:    map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			FieldCfg:   map[string]interface{}{"defaults": map[string]interface{}{"unit": s.unit}, "overrides": []interface{}{}},
+		}
+		for j, e := range s.exprs {
+			p.Targets = append(p.Targets, target{Expr: e[0], LegendFormat: e[1], RefID: string(rune('A' + j))})
+		}
+		panels = append(panels, p)
+	}
+
+	dashboard := map[string]interface{}{
+		"uid":           o.UID,
+		"title":         o.Title,
+		"tags":          []string{"gorate"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "10s",
+		"time":          map[string]string{"from": "now-1h", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []map[string]interface{}{
+				variable("limiter", "Limiter", o.Datasource, fmt.Sprintf("label_values(%s, limiter)", metrics.QueriesTotal)),
+				variable("run_id", "Run", o.Datasource, fmt.Sprintf(`label_values(%s{limiter=~"$limiter"}, run_id)`, metrics.QueriesTotal)),
+			},
+		},
+		"panels": panels,
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+// variable returns a multi-value query variable of a Grafana dashboard
+func variable(name, label, datasource, query string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       name,
+		"label":      label,
+		"type":       "query",
+		"datasource": datasource,
+		"query":      query,
+		"refresh":    2,
+		"multi":      true,
+		"includeAll": true,
+		"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+	}
+}
@@ -0,0 +1,44 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package dashboards
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGrafanaRunIDVariable(t *testing.T) {
+	b, err := Grafana(GrafanaOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d struct {
+		Templating struct {
+			List []struct {
+				Name string `json:"name"`
+			} `json:"list"`
+		} `json:"templating"`
+		Panels []panel `json:"panels"`
+	}
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range d.Templating.List {
+		names = append(names, v.Name)
+	}
+	if strings.Join(names, ",") != "limiter,run_id" {
+		t.Errorf("got variables %q, want limiter and run_id", names)
+	}
+	for _, p := range d.Panels {
+		for _, tg := range p.Targets {
+			if !strings.Contains(tg.Expr, `run_id=~"$run_id"`) || !strings.Contains(tg.LegendFormat, "{{run_id}}") {
+				t.Errorf("got target %+v of panel %q, want the run_id filter and legend", tg, p.Title)
+			}
+		}
+	}
+}
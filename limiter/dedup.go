@@ -0,0 +1,100 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Deduper represents a dedup layer in front of a limiter.
+// Identical concurrent queries (by key) consume a single token and share the result of a single call.
+type Deduper struct {
+	wait   func(ctx context.Context) error
+	mu     sync.Mutex
+	calls  map[string]*dedupCall
+	shared atomic.Int64
+}
+
+// dedupCall represents an in-flight call
+type dedupCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+	// canceled reports whether the call failed by the leader's context
+	canceled bool
+}
+
+// NewDeduper creates a new deduper by the given function that waits for a token
+// (e.g. the Wait method of a limiter).
+func NewDeduper(wait func(ctx context.Context) error) *Deduper {
+	return &Deduper{wait: wait, calls: make(map[string]*dedupCall)}
+}
+
+// Do waits for a token and calls the given function unless a call for the same key is already
+// in flight, in which case it waits for that call and returns its result.
+// The shared value reports whether the result was shared with another caller.
+// A waiting caller returns as soon as its own context is done, and it makes the call itself if the
+// shared call failed by the context of its caller. A panic of the function is returned to the
+// waiting callers as an error and re-panicked.
+func (d *Deduper) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (v interface{}, shared bool, err error) {
+	for {
+		d.mu.Lock()
+		c, ok := d.calls[key]
+		if !ok {
+			break
+		}
+		d.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if !c.canceled {
+			d.shared.Add(1)
+			return c.val, true, c.err
+		}
+	}
+	c := &dedupCall{done: make(chan struct{})}
+	d.calls[key] = c
+	d.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.val, c.err, c.canceled = nil, fmt.Errorf("dedup call panicked: %v", r), false
+			d.release(key, c)
+			panic(r)
+		}
+		d.release(key, c)
+	}()
+
+	c.val, c.err = d.call(ctx, fn)
+	c.canceled = c.err != nil && ctx.Err() != nil
+	return c.val, false, c.err
+}
+
+// call waits for a token and calls the given function
+func (d *Deduper) call(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if err := d.wait(ctx); err != nil {
+		return nil, err
+	}
+	return fn(ctx)
+}
+
+// release removes the given call and releases its waiting callers
+func (d *Deduper) release(key string, c *dedupCall) {
+	d.mu.Lock()
+	delete(d.calls, key)
+	d.mu.Unlock()
+	close(c.done)
+}
+
+// NumOfShared returns the number of calls that shared the result of another call
+func (d *Deduper) NumOfShared() int {
+	return int(d.shared.Load())
+}
@@ -0,0 +1,115 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// noWait is a token wait that never blocks
+func noWait(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// startLeader starts a call of the given deduper which blocks until release is closed
+// and returns once the call is in flight
+func startLeader(ctx context.Context, d *Deduper, release chan struct{}, fn func()) chan error {
+	started := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			recover()
+			close(errc)
+		}()
+		_, _, err := d.Do(ctx, "key", func(ctx context.Context) (interface{}, error) {
+			close(started)
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			fn()
+			return "leader", nil
+		})
+		errc <- err
+	}()
+	<-started
+	return errc
+}
+
+func TestDedupFollowerCanceled(t *testing.T) {
+	d := NewDeduper(noWait)
+	release := make(chan struct{})
+	defer close(release)
+	startLeader(context.Background(), d, release, func() {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := d.Do(ctx, "key", func(ctx context.Context) (interface{}, error) { return "follower", nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the follower's deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("got a follower wait of %s, want ~50ms", elapsed)
+	}
+}
+
+func TestDedupLeaderCanceled(t *testing.T) {
+	d := NewDeduper(noWait)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := startLeader(ctx, d, make(chan struct{}), func() {})
+
+	type result struct {
+		v      interface{}
+		shared bool
+		err    error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		v, shared, err := d.Do(context.Background(), "key", func(ctx context.Context) (interface{}, error) { return "follower", nil })
+		resc <- result{v, shared, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("got leader error %v, want context.Canceled", err)
+	}
+
+	// The follower makes the call itself instead of sharing the leader's context error
+	if r := <-resc; r.err != nil || r.shared || r.v != "follower" {
+		t.Errorf("got %v (shared %v, error %v), want the follower's own result", r.v, r.shared, r.err)
+	}
+}
+
+func TestDedupPanic(t *testing.T) {
+	d := NewDeduper(noWait)
+	release := make(chan struct{})
+	errc := startLeader(context.Background(), d, release, func() { panic("boom") })
+
+	followerErr := make(chan error, 1)
+	go func() {
+		_, _, err := d.Do(context.Background(), "key", func(ctx context.Context) (interface{}, error) { return "follower", nil })
+		followerErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	// The leader re-panics and the follower gets the panic as an error
+	if _, ok := <-errc; ok {
+		t.Error("got a leader result, want a panic")
+	}
+	if err := <-followerErr; err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("got follower error %v, want the panic", err)
+	}
+	if got := d.NumOfShared(); got != 1 {
+		t.Errorf("got %d shared calls, want 1", got)
+	}
+}
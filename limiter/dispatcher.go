@@ -0,0 +1,176 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrDispatcherClosed is returned when a job is submitted to a closed dispatcher
+var ErrDispatcherClosed = errors.New("dispatcher is closed")
+
+// DispatcherOptions represents the options that can be set when creating a new dispatcher
+type DispatcherOptions struct {
+	// QPS is the limit for the number of jobs per second across all the keys (0 for no limit)
+	QPS float64
+	// Burst is the burst size (default 1)
+	Burst int
+	// Concurrency is the number of workers
+	Concurrency int
+	// Context is the base context (optional). Its cancellation stops the workers and drops the queued jobs.
+	Context context.Context
+}
+
+// NewDispatcher creates a new dispatcher by the given options and starts its workers
+func NewDispatcher(o DispatcherOptions) (*Dispatcher, error) {
+	if o.Concurrency <= 0 {
+		return nil, errors.New("concurrency value must be greater than zero")
+	} else if o.QPS < 0 || o.Burst < 0 {
+		return nil, errors.New("qps and burst values must be positive")
+	}
+	if o.Burst == 0 {
+		o.Burst = 1
+	}
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+	d := &Dispatcher{
+		ctx:    o.Context,
+		lim:    rate.NewLimiter(rate.Inf, 0),
+		queues: make(map[string]*jobQueue),
+	}
+	if o.QPS > 0 {
+		d.lim = rate.NewLimiter(rate.Limit(o.QPS), o.Burst)
+	}
+	d.cond = sync.NewCond(&d.mu)
+	for i := 0; i < o.Concurrency; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	d.stop = context.AfterFunc(d.ctx, func() {
+		d.mu.Lock()
+		d.cond.Broadcast()
+		d.mu.Unlock()
+	})
+	return d, nil
+}
+
+// Dispatcher represents a keyed FIFO dispatch layer above a worker pool.
+// Jobs with the same key run one at a time in the submission order while the jobs of different
+// keys run in parallel, and every job respects the shared rate.
+type Dispatcher struct {
+	ctx     context.Context
+	lim     *rate.Limiter
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  map[string]*jobQueue
+	ready   []*jobQueue
+	pending int
+	stop    func() bool
+	closed  bool
+	err     error
+	wg      sync.WaitGroup
+}
+
+// jobQueue represents the pending jobs of a key
+type jobQueue struct {
+	key  string
+	jobs []func(ctx context.Context) error
+}
+
+// Submit queues the given job by the given key.
+// Jobs with an empty key aren't sequenced.
+func (d *Dispatcher) Submit(key string, job func(ctx context.Context) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+	d.pending++
+	if key == "" {
+		d.ready = append(d.ready, &jobQueue{jobs: []func(ctx context.Context) error{job}})
+		d.cond.Signal()
+		return nil
+	}
+	// A queue is either ready or being run by a worker while it's in the map
+	if q, ok := d.queues[key]; ok {
+		q.jobs = append(q.jobs, job)
+		return nil
+	}
+	q := &jobQueue{key: key, jobs: []func(ctx context.Context) error{job}}
+	d.queues[key] = q
+	d.ready = append(d.ready, q)
+	d.cond.Signal()
+	return nil
+}
+
+// work runs the jobs of the ready queues until the dispatcher is closed and drained
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		for len(d.ready) == 0 && !d.closed && d.ctx.Err() == nil {
+			d.cond.Wait()
+		}
+		if d.ctx.Err() != nil || len(d.ready) == 0 {
+			d.mu.Unlock()
+			return
+		}
+		q := d.ready[0]
+		d.ready[0] = nil
+		d.ready = d.ready[1:]
+		job := q.jobs[0]
+		q.jobs[0] = nil
+		q.jobs = q.jobs[1:]
+		d.pending--
+		d.mu.Unlock()
+
+		err := rateWaitError(d.ctx, d.lim, 1, d.lim.Wait(d.ctx))
+		if err == nil {
+			err = job(d.ctx)
+		}
+
+		d.mu.Lock()
+		if err != nil && d.err == nil {
+			d.err = err
+		}
+		// The next job of the key goes to the back of the ready list so the keys are served fairly
+		if len(q.jobs) > 0 {
+			d.ready = append(d.ready, q)
+			d.cond.Signal()
+		} else if q.key != "" {
+			delete(d.queues, q.key)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// Len returns the number of queued jobs
+func (d *Dispatcher) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pending
+}
+
+// Close stops accepting jobs, waits for the queued jobs and returns the first job error
+func (d *Dispatcher) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	d.cond.Broadcast()
+	d.mu.Unlock()
+	d.wg.Wait()
+	d.stop()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		return d.ctx.Err()
+	}
+	return d.err
+}
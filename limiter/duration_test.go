@@ -0,0 +1,91 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExtendDuration(t *testing.T) {
+	var once sync.Once
+	var extendErr error
+	limiter, err := New(Options{
+		Concurrency: 1,
+		QPS:         100,
+		Duration:    200 * time.Millisecond,
+		Callback: func(cbp CallbackParams) error {
+			once.Do(func() { extendErr = cbp.Limiter.ExtendDuration(200 * time.Millisecond) })
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if extendErr != nil {
+		t.Fatal(extendErr)
+	}
+
+	// The run ends at the extended deadline
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond || elapsed > 700*time.Millisecond {
+		t.Errorf("got run of %s, want ~400ms", elapsed)
+	}
+	if got := limiter.NumOfQueries(); got < 30 {
+		t.Errorf("got %d queries, want at least 30", got)
+	}
+}
+
+func TestExtendDurationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		extend  time.Duration
+		want    string
+	}{
+		{name: "no duration", options: Options{Concurrency: 1, Limit: 1}, extend: time.Second, want: "limiter has no duration"},
+		{name: "zero", options: Options{Concurrency: 1, Duration: time.Second, Limit: 1}, want: "duration extension must be greater than zero"},
+		{name: "negative", options: Options{Concurrency: 1, Duration: time.Second, Limit: 1}, extend: -time.Second, want: "duration extension must be greater than zero"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var extendErr error
+			tt.options.Callback = func(cbp CallbackParams) error {
+				extendErr = cbp.Limiter.ExtendDuration(tt.extend)
+				return nil
+			}
+			limiter, err := New(tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := limiter.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if extendErr == nil || extendErr.Error() != tt.want {
+				t.Errorf("got %v, want %s", extendErr, tt.want)
+			}
+		})
+	}
+
+	// The limiter must be running
+	limiter, err := New(Options{Concurrency: 1, Duration: time.Second, Limit: 1, Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.ExtendDuration(time.Second); err == nil || err.Error() != "limiter is not running" {
+		t.Errorf("got %v before the run, want limiter is not running", err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.ExtendDuration(time.Second); err == nil || err.Error() != "limiter is not running" {
+		t.Errorf("got %v after the run, want limiter is not running", err)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"time"
+)
+
+// minSweepInterval is the min interval of the idle key sweeper
+const minSweepInterval = time.Second
+
+// touch updates the last access time of the entry
+func (e *keyedEntry) touch(now time.Time) {
+	e.lastSeen.Store(now.UnixNano())
+}
+
+// evictOldest evicts the least recently used entry of the shard if the shard is full
+// and returns its key. It must be called while holding the shard lock.
+func (kl *KeyedLimiter) evictOldest(sh *keyedShard) (string, bool) {
+	return evictLRU(sh.entries, kl.maxKeysPerShard, (*keyedEntry).lastAccess)
+}
+
+// lastAccess returns the last access time (unix nano) of the entry
+func (e *keyedEntry) lastAccess() int64 {
+	return e.lastSeen.Load()
+}
+
+// evictLRU evicts the least recently used entry of the given entries if there are max (> 0)
+// entries or more and returns its key. It's the eviction of the keyed and the two-tier limiters.
+func evictLRU[E any](entries map[string]*E, max int, lastAccess func(e *E) int64) (string, bool) {
+	if max <= 0 || len(entries) < max {
+		return "", false
+	}
+	var oldestKey string
+	var oldest int64
+	found := false
+	for k, e := range entries {
+		if t := lastAccess(e); !found || t < oldest {
+			oldestKey, oldest, found = k, t, true
+		}
+	}
+	delete(entries, oldestKey)
+	return oldestKey, true
+}
+
+// evictIdle evicts the entries of the given entries that weren't accessed since the given
+// threshold (unix nano) and returns their keys
+func evictIdle[E any](entries map[string]*E, threshold int64, lastAccess func(e *E) int64) []string {
+	var evicted []string
+	for k, e := range entries {
+		if lastAccess(e) < threshold {
+			delete(entries, k)
+			evicted = append(evicted, k)
+		}
+	}
+	return evicted
+}
+
+// sweepInterval returns the interval of the idle key sweeper by the given idle TTL
+func sweepInterval(idleTTL time.Duration) time.Duration {
+	return max(idleTTL/2, minSweepInterval)
+}
+
+// runSweeper evicts the idle entries every interval until the limiter is closed
+func (kl *KeyedLimiter) runSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-kl.closed:
+			return
+		case now := <-ticker.C:
+			kl.sweep(now)
+		}
+	}
+}
+
+// sweep evicts the entries that weren't accessed within the idle TTL
+func (kl *KeyedLimiter) sweep(now time.Time) {
+	threshold := now.Add(-kl.idleTTL).UnixNano()
+	for i := range kl.shards {
+		sh := &kl.shards[i]
+		sh.mu.Lock()
+		evicted := evictIdle(sh.entries, threshold, (*keyedEntry).lastAccess)
+		sh.mu.Unlock()
+		kl.evicted(evicted...)
+	}
+}
+
+// evicted evicts the given keys from the child limiters and invokes the eviction callback for them
+func (kl *KeyedLimiter) evicted(keys ...string) {
+	kl.evictions.Add(int64(len(keys)))
+	if len(keys) == 0 {
+		return
+	}
+	kl.childrenMu.Lock()
+	children := kl.children
+	kl.childrenMu.Unlock()
+	for _, cl := range children {
+		cl.evict(keys)
+	}
+	if kl.onEvict == nil {
+		return
+	}
+	for _, k := range keys {
+		kl.onEvict(k)
+	}
+}
+
+// NumOfEvictions returns the number of evicted keys
+func (kl *KeyedLimiter) NumOfEvictions() int {
+	return int(kl.evictions.Load())
+}
+
+// Close stops the background work (idle key sweeper) of the limiter
+func (kl *KeyedLimiter) Close() {
+	kl.closeOnce.Do(func() { close(kl.closed) })
+}
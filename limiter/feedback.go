@@ -0,0 +1,46 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// feedbackMinFactor is the lower bound of the adjusted rate relative to the target rate
+	feedbackMinFactor = 0.5
+	// feedbackMaxFactor is the upper bound of the adjusted rate relative to the target rate
+	feedbackMaxFactor = 2.0
+)
+
+// runFeedback runs the achieved-rate feedback controller until the given context is done.
+// Every interval it compares the number of queries made so far with the number expected at the
+// target rate and sets the internal rate so the difference is made up within the next interval.
+func (limiter *Limiter) runFeedback(ctx context.Context, interval time.Duration) {
+	target := float64(limiter.qps)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expected := target * time.Since(limiter.start).Seconds()
+			actual := float64(limiter.NumOfQueries())
+			r := target + (expected-actual)/interval.Seconds()
+			if r < target*feedbackMinFactor {
+				r = target * feedbackMinFactor
+			} else if r > target*feedbackMaxFactor {
+				r = target * feedbackMaxFactor
+			}
+			limiter.lim.SetLimit(rate.Limit(r))
+		}
+	}
+}
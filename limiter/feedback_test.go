@@ -0,0 +1,73 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFeedbackCatchesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		min, max int
+	}{
+		// The stall costs ~25 queries which are only made up by the controller
+		{name: "without feedback", min: 60, max: 85},
+		{name: "with feedback", interval: 50 * time.Millisecond, min: 90, max: 110},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries atomic.Int64
+			limiter, err := New(Options{
+				Concurrency:      1,
+				QPS:              100,
+				Duration:         time.Second,
+				FeedbackInterval: tt.interval,
+				Callback: func(cbp CallbackParams) error {
+					if queries.Add(1) == 10 {
+						time.Sleep(250 * time.Millisecond)
+					}
+					return nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := limiter.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if got := limiter.NumOfQueries(); got < tt.min || got > tt.max {
+				t.Errorf("got %d queries, want between %d and %d", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestFeedbackBounds(t *testing.T) {
+	// The target can't keep up, so the controller is held at the upper bound
+	limiter, err := New(Options{
+		Concurrency:      1,
+		QPS:              100,
+		Duration:         300 * time.Millisecond,
+		FeedbackInterval: 20 * time.Millisecond,
+		Callback: func(cbp CallbackParams) error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := float64(limiter.lim.Limit()), 100*feedbackMaxFactor; got != want {
+		t.Errorf("got rate %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,21 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+// GateInfo represents the gate function parameters
+type GateInfo struct {
+	// GroupID is the id for the concurrency group
+	GroupID int
+	// RunID is the unique id of the run
+	RunID string
+	// NumOfQueries is the number of queries made so far
+	NumOfQueries int
+}
+
+// NumOfSkips returns the number of slots skipped by the gate
+func (limiter *Limiter) NumOfSkips() int {
+	return int(limiter.skips.Load())
+}
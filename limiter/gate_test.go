@@ -0,0 +1,73 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGateSkipReturnsToken(t *testing.T) {
+	// Every other slot is skipped
+	var slots atomic.Int64
+	limiter, err := New(Options{
+		Concurrency: 1,
+		QPS:         50,
+		Limit:       25,
+		Gate: func(ctx context.Context, gi GateInfo) (bool, error) {
+			return slots.Add(1)%2 == 0, nil
+		},
+		Callback: func(cbp CallbackParams) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The admitted slots run at the full QPS (~0.5s) instead of the half (~1s)
+	if elapsed := time.Since(start); elapsed > 750*time.Millisecond {
+		t.Errorf("got %d queries in %s, want ~500ms", limiter.NumOfQueries(), elapsed)
+	}
+	if got := limiter.NumOfQueries(); got != 25 {
+		t.Errorf("got %d queries, want 25", got)
+	}
+	if got := limiter.NumOfSkips(); got < 24 {
+		t.Errorf("got %d skips, want at least 24", got)
+	}
+}
+
+func TestGateClosedIsPaced(t *testing.T) {
+	var calls atomic.Int64
+	limiter, err := New(Options{
+		Concurrency: 1,
+		QPS:         50,
+		Duration:    200 * time.Millisecond,
+		Gate: func(ctx context.Context, gi GateInfo) (bool, error) {
+			calls.Add(1)
+			return false, nil
+		},
+		Callback: func(cbp CallbackParams) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A closed gate is polled about twice per token instead of spinning
+	if got := calls.Load(); got > 50 {
+		t.Errorf("got %d gate calls in 200ms at 50 qps, want at most 50", got)
+	}
+	if got := limiter.NumOfQueries(); got != 0 {
+		t.Errorf("got %d queries, want 0", got)
+	}
+}
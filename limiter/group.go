@@ -0,0 +1,75 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"time"
+)
+
+// GroupLimit represents the limits of a concurrency group
+type GroupLimit struct {
+	// Duration is the run duration of the group (optional).
+	// The group stops at the first dispatch after it, so a waiting worker may stop a token later.
+	Duration time.Duration
+	// Limit is the limit for the number of queries of the group (optional)
+	Limit uint32
+}
+
+// isGroupDone returns whether the group by the given id reached any of the given limits
+func (limiter *Limiter) isGroupDone(i int, gl GroupLimit) bool {
+	if gl.Limit > 0 && limiter.counters[i].queries.Load() >= gl.Limit {
+		limiter.isQueryLimit.Store(true)
+		return true
+	} else if gl.Duration > 0 && time.Since(limiter.start) >= gl.Duration {
+		limiter.isDeadline.Store(true)
+		return true
+	}
+	return false
+}
+
+// goBackground runs the given function in a background goroutine of the run.
+// The function must return once the given context is done, which happens after the workers are done.
+func (limiter *Limiter) goBackground(fn func(ctx context.Context)) {
+	ctx := limiter.baseContext
+	limiter.background.Add(1)
+	go pprof.Do(ctx, limiter.pprofLabels(), func(ctx context.Context) {
+		defer limiter.background.Done()
+		fn(ctx)
+	})
+}
+
+// pprofLabels returns the pprof labels of the goroutines of the run with the given extra labels.
+// They attribute the CPU and heap profiles of the embedding services to the limiters.
+func (limiter *Limiter) pprofLabels(args ...string) pprof.LabelSet {
+	return pprof.Labels(append([]string{"gorate.limiter", limiter.name, "gorate.run_id", limiter.RunID()}, args...)...)
+}
+
+// setError records the given error unless an error was recorded before,
+// so the first error of a run is reported regardless of the worker scheduling
+func (limiter *Limiter) setError(err error) {
+	limiter.mu.Lock()
+	if limiter.lastError == nil {
+		limiter.lastError = err
+	}
+	limiter.mu.Unlock()
+}
+
+// recoverWorker recovers a panicking worker by the given group id.
+// The panic is recorded as the run error, the run is stopped and the worker slot is released.
+func (limiter *Limiter) recoverWorker(i int) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := fmt.Errorf("worker %d panicked: %v", i, r)
+	limiter.isCallbackError.Store(true)
+	limiter.setError(err)
+	limiter.limCancelFunc(err)
+	limiter.stopWorker(i, false)
+}
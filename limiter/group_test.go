@@ -0,0 +1,176 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkLeaks fails the test if the goroutines started since the given number of goroutines
+// are still running once the run is over (an equivalent of goleak without the dependency)
+func checkLeaks(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("got %d goroutines after the run, want %d:\n%s", runtime.NumGoroutine(), before, buf)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// leakOptions returns the options which start every background goroutine of a run
+func leakOptions(callback Callback) Options {
+	var buf bytes.Buffer
+	return Options{
+		Concurrency:        8,
+		QPS:                1000,
+		Duration:           time.Second,
+		Callback:           callback,
+		Instrument:         func(ts TokenStats) {},
+		InstrumentInterval: 5 * time.Millisecond,
+		OnProgress:         func(p ProgressInfo) {},
+		ProgressInterval:   5 * time.Millisecond,
+		WatchdogInterval:   5 * time.Millisecond,
+		AutoTune:           true,
+		MaxConcurrency:     16,
+		Sink:               NewWriterSink(&buf),
+		SinkBuffer:         16,
+		SinkBackpressure:   BackpressureDrop,
+	}
+}
+
+func TestRunLeaks(t *testing.T) {
+	errCallback := errors.New("callback failed")
+	for _, tc := range []struct {
+		name     string
+		callback Callback
+		stop     func(limiter *Limiter, cancel context.CancelFunc)
+		wantErr  string
+	}{
+		{
+			name: "panic",
+			callback: func(cbp CallbackParams) error {
+				if cbp.Sequence == 20 {
+					panic("boom")
+				}
+				return nil
+			},
+			wantErr: "panicked: boom",
+		},
+		{
+			name: "callback error",
+			callback: func(cbp CallbackParams) error {
+				if cbp.Sequence >= 20 {
+					return errCallback
+				}
+				return nil
+			},
+			wantErr: errCallback.Error(),
+		},
+		{
+			name:     "stop",
+			callback: func(cbp CallbackParams) error { return nil },
+			stop:     func(limiter *Limiter, cancel context.CancelFunc) { limiter.Stop() },
+		},
+		{
+			name:     "context cancellation",
+			callback: func(cbp CallbackParams) error { return nil },
+			stop:     func(limiter *Limiter, cancel context.CancelFunc) { cancel() },
+		},
+		{
+			name: "blocked callbacks",
+			callback: func(cbp CallbackParams) error {
+				<-cbp.Context.Done()
+				return cbp.Context.Err()
+			},
+			stop:    func(limiter *Limiter, cancel context.CancelFunc) { cancel() },
+			wantErr: context.Canceled.Error(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			o := leakOptions(tc.callback)
+			o.Context = ctx
+			limiter, err := New(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.stop != nil {
+				time.AfterFunc(50*time.Millisecond, func() { tc.stop(limiter, cancel) })
+			}
+
+			done := make(chan error)
+			go func() { done <- limiter.Run() }()
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("run didn't end")
+			}
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("got %v, want no error", err)
+			} else if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("got %v, want %q", err, tc.wantErr)
+			}
+			checkLeaks(t, before)
+		})
+	}
+}
+
+func TestRunFirstError(t *testing.T) {
+	errFirst, errLater := errors.New("first"), errors.New("later")
+	for i := 0; i < 20; i++ {
+		// The later errors happen while the run is stopping; the first one must win regardless of
+		// the worker scheduling
+		limiter, err := New(Options{
+			Concurrency: 8,
+			Limit:       100,
+			Callback: func(cbp CallbackParams) error {
+				if cbp.Sequence == 1 {
+					return errFirst
+				}
+				time.Sleep(5 * time.Millisecond)
+				return errLater
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := limiter.Run(); err != errFirst {
+			t.Fatalf("got %v at run %d, want %v", err, i, errFirst)
+		}
+	}
+}
+
+func TestRunFirstErrorPanic(t *testing.T) {
+	limiter, err := New(Options{
+		Concurrency: 4,
+		Limit:       100,
+		Callback: func(cbp CallbackParams) error {
+			if cbp.Sequence == 1 {
+				panic("boom")
+			}
+			time.Sleep(5 * time.Millisecond)
+			return errors.New("later")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err == nil || !strings.Contains(err.Error(), "panicked: boom") {
+		t.Fatalf("got %v, want the panic", err)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultHealthCheckInterval is the default interval of the health checks
+	defaultHealthCheckInterval = time.Second
+	// defaultHealthyThreshold is the default number of consecutive successful health checks to restore the QPS
+	defaultHealthyThreshold = 3
+	// defaultSafeQPSDivisor is the divisor of the QPS for the default safe rate
+	defaultSafeQPSDivisor = 10
+)
+
+// HTTPHealthCheck returns a health check which sends a GET request to the given url
+// and fails on transport errors and status codes 400 and above
+func HTTPHealthCheck(client *http.Client, url string) func(ctx context.Context) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("health check failed with status %d", res.StatusCode)
+		}
+		return nil
+	}
+}
+
+// runHealthCheck runs the health check every interval until the given context is done.
+// The run starts at the safe rate which is kept while the target is unhealthy; the QPS is
+// restored after the healthy threshold is reached. Each check is bounded by the interval
+// and a check which times out counts as a failure.
+func (limiter *Limiter) runHealthCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	successes := 0
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		err := limiter.healthCheck(checkCtx)
+		if err == nil {
+			err = checkCtx.Err()
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			successes = 0
+			if limiter.healthy.Swap(false) {
+				limiter.lim.SetLimit(rate.Limit(float64(limiter.safeQPS)))
+			}
+		} else if successes++; successes >= limiter.healthyThreshold && !limiter.healthy.Swap(true) {
+			limiter.lim.SetLimit(rate.Limit(float64(limiter.qps)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// IsHealthy returns whether the target passed the health checks.
+// It's always true if the health check isn't set.
+func (limiter *Limiter) IsHealthy() bool {
+	return limiter.healthCheck == nil || limiter.healthy.Load()
+}
@@ -0,0 +1,69 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(ctx context.Context) error
+	}{
+		{
+			name: "honours context",
+			check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+		{
+			name: "ignores context error",
+			check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			limiter, err := New(Options{
+				Concurrency:         1,
+				QPS:                 100,
+				Duration:            500 * time.Millisecond,
+				HealthCheckInterval: 50 * time.Millisecond,
+				HealthyThreshold:    1,
+				HealthCheck: func(ctx context.Context) error {
+					calls.Add(1)
+					return tt.check(ctx)
+				},
+				Callback: func(cbp CallbackParams) error { return nil },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := limiter.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			// A hanging check times out every interval and keeps the safe rate
+			if got := calls.Load(); got < 3 {
+				t.Errorf("got %d health checks, want at least 3", got)
+			}
+			if limiter.IsHealthy() {
+				t.Error("got healthy, want unhealthy")
+			}
+			if got := limiter.NumOfQueries(); got > 10 {
+				t.Errorf("got %d queries at the safe rate, want at most 10", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// defaultInstrumentInterval is the default interval of the instrumentation hook
+	defaultInstrumentInterval = time.Second
+	// waitThreshold is the min wait duration for a grant to be counted as waited-on
+	waitThreshold = 50 * time.Microsecond
+)
+
+// TokenStats represents the token statistics of an interval
+type TokenStats struct {
+	// Interval is the length of the interval
+	Interval time.Duration
+	// Granted is the number of tokens granted
+	Granted int
+	// Waited is the number of tokens that workers had to wait for
+	Waited int
+	// AvgWait is the average wait per grant
+	AvgWait time.Duration
+	// AvgLatency is the average callback latency
+	AvgLatency time.Duration
+}
+
+// IsLimiterBound returns whether the workers spent more time waiting for tokens than executing callbacks
+func (ts TokenStats) IsLimiterBound() bool {
+	return ts.AvgWait > ts.AvgLatency
+}
+
+// runInstrument invokes the instrumentation hook every interval until the given context is done
+func (limiter *Limiter) runInstrument(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastGranted, lastWaited, lastWait, lastLatency, lastCount int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			granted, waited, wait := limiter.granted.Load(), limiter.waited.Load(), limiter.waitTotal.Load()
+			latency, count := limiter.callbackLatency()
+			ts := TokenStats{
+				Interval: interval,
+				Granted:  int(granted - lastGranted),
+				Waited:   int(waited - lastWaited),
+			}
+			if ts.Granted > 0 {
+				ts.AvgWait = time.Duration((wait - lastWait) / int64(ts.Granted))
+			}
+			if count > lastCount {
+				ts.AvgLatency = time.Duration((latency - lastLatency) / (count - lastCount))
+			}
+			lastGranted, lastWaited, lastWait, lastLatency, lastCount = granted, waited, wait, latency, count
+			limiter.instrument(ts)
+		}
+	}
+}
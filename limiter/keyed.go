@@ -0,0 +1,601 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultShards is the default number of shards of a keyed limiter
+const defaultShards = 32
+
+// KeyedOptions represents the options that can be set when creating a new keyed limiter
+type KeyedOptions struct {
+	// QPS is the default limit for the number of queries per second per key
+	QPS float64
+	// Burst is the default burst size per key (default 1)
+	Burst int
+	// NegativeCache enables caching the rejections per key until the next token is available,
+	// so rejected keys are rejected without touching their limiters during that window
+	NegativeCache bool
+	// BanThreshold enables banning the keys rejected more than the given number of times within the ban window
+	BanThreshold int
+	// BanWindow is the window for counting the rejections (default 1m)
+	BanWindow time.Duration
+	// BanDuration is the duration of the first ban which doubles on every subsequent ban (default 1m)
+	BanDuration time.Duration
+	// BanMaxDuration is the max duration of a ban (default 24h)
+	BanMaxDuration time.Duration
+	// PlanResolver returns the plan of the given key (optional).
+	// It's called once when a key is seen for the first time, see SetPlan for reassignments.
+	PlanResolver func(key string) Plan
+	// UsageWindow enables the usage accounting per key by the given window (e.g. time.Hour)
+	UsageWindow time.Duration
+	// UsageRetention is the number of usage windows kept per key (default 24)
+	UsageRetention int
+	// TopK enables tracking the heavy hitters (the keys that consumed the most tokens) by
+	// the given capacity. The memory used is bounded by the capacity, not the number of keys.
+	TopK int
+	// TopKWindow is the window of the top-K tracking (default 5m)
+	TopKWindow time.Duration
+	// Shards is the number of shards the keys are distributed to, each with its own lock,
+	// so high throughput keyed limiting doesn't serialize on a single lock (default 32)
+	Shards int
+	// IdleTTL enables evicting the keys that aren't accessed within the given duration.
+	// The sweeper runs in the background until Close is called.
+	IdleTTL time.Duration
+	// MaxKeys is the hard cap on the number of keys (optional). It's enforced per shard
+	// (MaxKeys / Shards) by evicting the least recently used key of the shard.
+	MaxKeys int
+	// OnEvict is the function that is invoked with the evicted keys (optional)
+	OnEvict func(key string)
+}
+
+// Plan represents a plan (tier) that defines the limits of the keys assigned to it
+type Plan struct {
+	// Name is the name of the plan
+	Name string `json:"name"`
+	// QPS is the limit for the number of queries per second (default KeyedOptions.QPS)
+	QPS float64 `json:"qps"`
+	// Burst is the burst size (default KeyedOptions.Burst)
+	Burst int `json:"burst"`
+}
+
+// BanState represents the ban state of a key
+type BanState struct {
+	// Key is the key
+	Key string
+	// Until is the end of the ban
+	Until time.Time
+	// Count is the number of times the key was banned
+	Count int
+}
+
+// BanError is the error of a wait for a banned key
+type BanError struct {
+	// Key is the key
+	Key string
+	// Until is the end of the ban
+	Until time.Time
+}
+
+// Error implements the error interface
+func (e *BanError) Error() string {
+	return fmt.Sprintf("key %q is banned until %s", e.Key, e.Until.Format(time.RFC3339))
+}
+
+// NewKeyed creates a new keyed limiter by the given options
+func NewKeyed(o KeyedOptions) (*KeyedLimiter, error) {
+	if o.QPS <= 0 {
+		return nil, errors.New("qps value must be greater than zero")
+	} else if o.Burst < 0 {
+		return nil, errors.New("burst value must be positive")
+	}
+	if o.Burst == 0 {
+		o.Burst = 1
+	}
+	if o.BanThreshold < 0 || o.BanWindow < 0 || o.BanDuration < 0 || o.BanMaxDuration < 0 {
+		return nil, errors.New("ban values must be positive")
+	}
+	if o.UsageWindow < 0 || o.UsageRetention < 0 {
+		return nil, errors.New("usage values must be positive")
+	} else if o.UsageRetention == 0 {
+		o.UsageRetention = defaultUsageRetention
+	}
+	if o.TopK < 0 || o.TopKWindow < 0 {
+		return nil, errors.New("top-k values must be positive")
+	} else if o.TopKWindow == 0 {
+		o.TopKWindow = defaultTopKWindow
+	}
+	if o.BanWindow == 0 {
+		o.BanWindow = time.Minute
+	}
+	if o.BanDuration == 0 {
+		o.BanDuration = time.Minute
+	}
+	if o.BanMaxDuration == 0 {
+		o.BanMaxDuration = 24 * time.Hour
+	}
+	if o.Shards < 0 {
+		return nil, errors.New("shards value must be positive")
+	} else if o.Shards == 0 {
+		o.Shards = defaultShards
+	}
+	shards := make([]keyedShard, o.Shards)
+	for i := range shards {
+		shards[i].entries = make(map[string]*keyedEntry)
+	}
+	if o.IdleTTL < 0 || o.MaxKeys < 0 {
+		return nil, errors.New("idle ttl and max keys values must be positive")
+	}
+	var maxKeysPerShard int
+	if o.MaxKeys > 0 {
+		maxKeysPerShard = (o.MaxKeys + o.Shards - 1) / o.Shards
+	}
+	var tk *topK
+	if o.TopK > 0 {
+		tk = newTopK(o.TopK, o.TopKWindow)
+	}
+	kl := &KeyedLimiter{
+		qps:             o.QPS,
+		burst:           o.Burst,
+		negativeCache:   o.NegativeCache,
+		banThreshold:    o.BanThreshold,
+		banWindow:       o.BanWindow,
+		banDuration:     o.BanDuration,
+		banMaxDuration:  o.BanMaxDuration,
+		planResolver:    o.PlanResolver,
+		usageWindow:     o.UsageWindow,
+		usageRetention:  o.UsageRetention,
+		topK:            tk,
+		shards:          shards,
+		idleTTL:         o.IdleTTL,
+		maxKeysPerShard: maxKeysPerShard,
+		onEvict:         o.OnEvict,
+		closed:          make(chan struct{}),
+	}
+	if kl.idleTTL > 0 {
+		go kl.runSweeper(sweepInterval(kl.idleTTL))
+	}
+	return kl, nil
+}
+
+// KeyedLimiter represents a limiter that maintains a separate token bucket for every key
+type KeyedLimiter struct {
+	qps             float64
+	burst           int
+	negativeCache   bool
+	banThreshold    int
+	banWindow       time.Duration
+	banDuration     time.Duration
+	banMaxDuration  time.Duration
+	planResolver    func(key string) Plan
+	usageWindow     time.Duration
+	usageRetention  int
+	topK            *topK
+	shards          []keyedShard
+	idleTTL         time.Duration
+	maxKeysPerShard int
+	onEvict         func(key string)
+	evictions       atomic.Int64
+	childrenMu      sync.Mutex
+	children        []*ChildLimiter
+	closed          chan struct{}
+	closeOnce       sync.Once
+	cacheHits       atomic.Int64
+	grantsUsed      atomic.Int64
+	allowed         atomic.Int64
+	rejected        atomic.Int64
+}
+
+// keyedShard represents a shard of the keys
+type keyedShard struct {
+	mu      sync.Mutex
+	entries map[string]*keyedEntry
+	_       [48]byte // pad to a cache line to avoid false sharing between the shard locks
+}
+
+// shard returns the shard of the given key
+func (kl *KeyedLimiter) shard(key string) *keyedShard {
+	return &kl.shards[fnv1a(key)%uint64(len(kl.shards))]
+}
+
+// fnv1a returns the 64-bit FNV-1a hash of the given string without allocating.
+// It's the hash of the keyed limiter shards and the hash ring.
+func fnv1a(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// lookup returns the entry by the given key without creating it
+func (kl *KeyedLimiter) lookup(key string) (*keyedEntry, bool) {
+	sh := kl.shard(key)
+	sh.mu.Lock()
+	e, ok := sh.entries[key]
+	sh.mu.Unlock()
+	return e, ok
+}
+
+// snapshot returns the entries of all the shards
+func (kl *KeyedLimiter) snapshot() []*keyedEntry {
+	var entries []*keyedEntry
+	for i := range kl.shards {
+		sh := &kl.shards[i]
+		sh.mu.Lock()
+		for _, e := range sh.entries {
+			entries = append(entries, e)
+		}
+		sh.mu.Unlock()
+	}
+	return entries
+}
+
+// keyedEntry represents the state of a key
+type keyedEntry struct {
+	key string
+	lim *rate.Limiter
+	// rejectedUntil is the time (unix nano) until the key is rejected (negative cache)
+	rejectedUntil atomic.Int64
+	// bannedUntil is the time (unix nano) until the key is banned
+	bannedUntil atomic.Int64
+	// lastSeen is the last access time (unix nano)
+	lastSeen atomic.Int64
+	// mu guards the plan, grant, usage, rejection and ban state
+	mu          sync.Mutex
+	plan        Plan
+	grantTokens int
+	grantExpiry time.Time
+	usage       []usageBucket
+	rejectStart time.Time
+	rejections  int
+	bans        int
+}
+
+// entry returns the entry by the given key and creates it if it doesn't exist
+func (kl *KeyedLimiter) entry(key string) *keyedEntry {
+	now := time.Now()
+	if e, ok := kl.lookup(key); ok {
+		e.touch(now)
+		return e
+	}
+
+	// Resolve the plan without holding the lock since the resolver may be slow
+	var plan Plan
+	if kl.planResolver != nil {
+		plan = kl.planResolver(key)
+	}
+	plan = kl.withDefaults(plan)
+
+	sh := kl.shard(key)
+	sh.mu.Lock()
+	if e, ok := sh.entries[key]; ok {
+		sh.mu.Unlock()
+		e.touch(now)
+		return e
+	}
+	evictedKey, evicted := kl.evictOldest(sh)
+	e := &keyedEntry{key: key, lim: rate.NewLimiter(rate.Limit(plan.QPS), plan.Burst), plan: plan}
+	e.touch(now)
+	sh.entries[key] = e
+	sh.mu.Unlock()
+	if evicted {
+		kl.evicted(evictedKey)
+	}
+	return e
+}
+
+// withDefaults returns the given plan with the default limits set for the missing values
+func (kl *KeyedLimiter) withDefaults(plan Plan) Plan {
+	if plan.QPS <= 0 {
+		plan.QPS = kl.qps
+	}
+	if plan.Burst <= 0 {
+		plan.Burst = kl.burst
+	}
+	return plan
+}
+
+// SetPlan assigns the given plan to the given key at runtime (e.g. after an upgrade)
+func (kl *KeyedLimiter) SetPlan(key string, plan Plan) {
+	plan = kl.withDefaults(plan)
+	e := kl.entry(key)
+	e.mu.Lock()
+	e.plan = plan
+	e.lim.SetLimit(rate.Limit(plan.QPS))
+	e.lim.SetBurst(plan.Burst)
+	e.mu.Unlock()
+	e.rejectedUntil.Store(0)
+}
+
+// PlanOf returns the plan of the given key
+func (kl *KeyedLimiter) PlanOf(key string) Plan {
+	e := kl.entry(key)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.plan
+}
+
+// Allow reports whether a query for the given key may happen now
+func (kl *KeyedLimiter) Allow(key string) bool {
+	ok, _ := kl.Check(key)
+	return ok
+}
+
+// Check reports whether a query for the given key may happen now.
+// If not, it returns the duration until the next token is available.
+func (kl *KeyedLimiter) Check(key string) (bool, time.Duration) {
+	ok, retryAfter := kl.check(key)
+	if !ok {
+		kl.rejected.Add(1)
+	}
+	return ok, retryAfter
+}
+
+// check makes the decision for Check
+func (kl *KeyedLimiter) check(key string) (bool, time.Duration) {
+	e := kl.entry(key)
+	now := time.Now()
+
+	// Ban
+	if until := e.bannedUntil.Load(); until > now.UnixNano() {
+		return false, time.Duration(until - now.UnixNano())
+	}
+
+	// Negative cache
+	var cached time.Duration
+	if kl.negativeCache {
+		if until := e.rejectedUntil.Load(); until > now.UnixNano() {
+			cached = time.Duration(until - now.UnixNano())
+		}
+	}
+	if cached == 0 && e.lim.AllowN(now, 1) {
+		kl.consumed(e, now, 1)
+		return true, 0
+	}
+
+	// Grants are used once the regular budget is exhausted
+	if e.useGrant(now, 1) {
+		kl.grantsUsed.Add(1)
+		kl.consumed(e, now, 1)
+		return true, 0
+	}
+	if cached > 0 {
+		kl.cacheHits.Add(1)
+		return false, cached
+	}
+
+	retryAfter := retryAfter(e.lim, now)
+	if kl.negativeCache && retryAfter > 0 {
+		e.rejectedUntil.Store(now.Add(retryAfter).UnixNano())
+	}
+	if kl.banThreshold > 0 {
+		if d := kl.reject(e, now); d > 0 {
+			return false, d
+		}
+	}
+	return false, retryAfter
+}
+
+// reject records a rejection of the given entry and bans it if the rejections exceed
+// the threshold within the window. It returns the ban duration if the key is banned.
+func (kl *KeyedLimiter) reject(e *keyedEntry, now time.Time) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if now.Sub(e.rejectStart) > kl.banWindow {
+		e.rejectStart, e.rejections = now, 0
+	}
+	e.rejections++
+	if e.rejections <= kl.banThreshold {
+		return 0
+	}
+
+	// Escalate the ban duration on every ban
+	d := kl.banDuration
+	for i := 0; i < e.bans && d < kl.banMaxDuration; i++ {
+		d *= 2
+	}
+	if d > kl.banMaxDuration {
+		d = kl.banMaxDuration
+	}
+	e.bans++
+	e.rejections = 0
+	e.bannedUntil.Store(now.Add(d).UnixNano())
+	return d
+}
+
+// Ban returns the ban state of the given key.
+// The returned bool reports whether the key is currently banned.
+func (kl *KeyedLimiter) Ban(key string) (BanState, bool) {
+	e, ok := kl.lookup(key)
+	if !ok {
+		return BanState{Key: key}, false
+	}
+	return e.banState(key, time.Now())
+}
+
+// Bans returns the ban states of the currently banned keys
+func (kl *KeyedLimiter) Bans() []BanState {
+	now := time.Now()
+	var bans []BanState
+	for _, e := range kl.snapshot() {
+		if bs, ok := e.banState(e.key, now); ok {
+			bans = append(bans, bs)
+		}
+	}
+	return bans
+}
+
+// Unban lifts the ban of the given key and returns whether it was banned
+func (kl *KeyedLimiter) Unban(key string) bool {
+	e, ok := kl.lookup(key)
+	if !ok {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	banned := e.bannedUntil.Load() > time.Now().UnixNano()
+	e.bannedUntil.Store(0)
+	e.rejections = 0
+	return banned
+}
+
+// banState returns the ban state of the entry
+func (e *keyedEntry) banState(key string, now time.Time) (BanState, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	until := e.bannedUntil.Load()
+	bs := BanState{Key: key, Count: e.bans}
+	if until > 0 {
+		bs.Until = time.Unix(0, until)
+	}
+	return bs, until > now.UnixNano()
+}
+
+// NumOfCacheHits returns the number of rejections served by the negative cache
+func (kl *KeyedLimiter) NumOfCacheHits() int {
+	return int(kl.cacheHits.Load())
+}
+
+// retryAfter returns the duration until the next token of the given limiter is available
+func retryAfter(lim *rate.Limiter, now time.Time) time.Duration {
+	limit := float64(lim.Limit())
+	if limit <= 0 {
+		return 0
+	}
+	tokens := lim.TokensAt(now)
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / limit * float64(time.Second))
+}
+
+// Wait blocks until a query for the given key may happen
+func (kl *KeyedLimiter) Wait(ctx context.Context, key string) error {
+	return kl.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until n queries for the given key may happen.
+// It returns a BanError without waiting if the key is banned.
+func (kl *KeyedLimiter) WaitN(ctx context.Context, key string, n int) error {
+	e := kl.entry(key)
+	now := time.Now()
+	if until := e.bannedUntil.Load(); until > now.UnixNano() {
+		kl.rejected.Add(1)
+		return &BanError{Key: key, Until: time.Unix(0, until)}
+	}
+	if e.lim.AllowN(now, n) {
+		kl.consumed(e, now, n)
+		return nil
+	}
+	if e.useGrant(now, n) {
+		kl.grantsUsed.Add(int64(n))
+		kl.consumed(e, now, n)
+		return nil
+	}
+	if err := e.lim.WaitN(ctx, n); err != nil {
+		return rateWaitError(ctx, e.lim, n, err)
+	}
+	kl.consumed(e, time.Now(), n)
+	return nil
+}
+
+// consumed records the given number of tokens consumed by the entry
+func (kl *KeyedLimiter) consumed(e *keyedEntry, now time.Time, n int) {
+	kl.allowed.Add(int64(n))
+	if kl.topK != nil {
+		kl.topK.add(e.key, int64(n), now)
+	}
+	if kl.usageWindow <= 0 {
+		return
+	}
+	e.mu.Lock()
+	kl.recordUsage(e, now, n)
+	e.mu.Unlock()
+}
+
+// Grant grants the given number of extra tokens to the given key for the given duration.
+// The extra tokens are used once the regular budget of the key is exhausted and expire
+// automatically. Grants add up with the existing grant of the key.
+func (kl *KeyedLimiter) Grant(key string, tokens int, ttl time.Duration) {
+	if tokens <= 0 || ttl <= 0 {
+		return
+	}
+	e := kl.entry(key)
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if now.After(e.grantExpiry) {
+		e.grantTokens = 0
+	}
+	e.grantTokens += tokens
+	if expiry := now.Add(ttl); expiry.After(e.grantExpiry) {
+		e.grantExpiry = expiry
+	}
+	e.rejectedUntil.Store(0)
+}
+
+// GrantOf returns the remaining extra tokens and the expiry of the grant of the given key
+func (kl *KeyedLimiter) GrantOf(key string) (int, time.Time) {
+	e := kl.entry(key)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if time.Now().After(e.grantExpiry) {
+		return 0, time.Time{}
+	}
+	return e.grantTokens, e.grantExpiry
+}
+
+// NumOfGrantsUsed returns the number of granted extra tokens used
+func (kl *KeyedLimiter) NumOfGrantsUsed() int {
+	return int(kl.grantsUsed.Load())
+}
+
+// useGrant uses n granted tokens of the entry if available
+func (e *keyedEntry) useGrant(now time.Time, n int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.grantTokens < n || now.After(e.grantExpiry) {
+		return false
+	}
+	e.grantTokens -= n
+	return true
+}
+
+// SetLimit overrides the limit of the given key
+func (kl *KeyedLimiter) SetLimit(key string, qps float64, burst int) {
+	e := kl.entry(key)
+	e.lim.SetLimit(rate.Limit(qps))
+	e.lim.SetBurst(burst)
+	e.rejectedUntil.Store(0)
+}
+
+// Stats returns the statistics
+func (kl *KeyedLimiter) Stats() Stats {
+	return Stats{Allowed: int(kl.allowed.Load()), Rejected: int(kl.rejected.Load())}
+}
+
+// Len returns the number of keys
+func (kl *KeyedLimiter) Len() int {
+	n := 0
+	for i := range kl.shards {
+		sh := &kl.shards[i]
+		sh.mu.Lock()
+		n += len(sh.entries)
+		sh.mu.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,102 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchmarkKeyed runs the given function over the keys in parallel for every combination of
+// the shard and the key counts. The scaling shows by the -cpu flag (e.g. -cpu 1,2,4,8).
+func benchmarkKeyed(b *testing.B, fn func(kl *KeyedLimiter, key string)) {
+	for _, shards := range []int{1, 32} {
+		for _, numOfKeys := range []int{1, 1000, 100000} {
+			b.Run(fmt.Sprintf("shards=%d/keys=%d", shards, numOfKeys), func(b *testing.B) {
+				kl, err := NewKeyed(KeyedOptions{QPS: 1e9, Burst: 1e9, Shards: shards})
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer kl.Close()
+				keys := make([]string, numOfKeys)
+				for i := range keys {
+					keys[i] = "key-" + strconv.Itoa(i)
+					kl.Allow(keys[i])
+				}
+				var offset atomic.Int64
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					// Every goroutine starts from a different key so they don't walk the shards in lockstep
+					i := int(offset.Add(7919))
+					for pb.Next() {
+						fn(kl, keys[i%len(keys)])
+						i++
+					}
+				})
+			})
+		}
+	}
+}
+
+func BenchmarkKeyedAllow(b *testing.B) {
+	benchmarkKeyed(b, func(kl *KeyedLimiter, key string) { kl.Allow(key) })
+}
+
+func BenchmarkKeyedCheck(b *testing.B) {
+	benchmarkKeyed(b, func(kl *KeyedLimiter, key string) { kl.Check(key) })
+}
+
+func TestKeyedShards(t *testing.T) {
+	kl, err := NewKeyed(KeyedOptions{QPS: 1, Shards: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kl.Close()
+	for i := 0; i < 1000; i++ {
+		kl.Allow("key-" + strconv.Itoa(i))
+	}
+	if kl.Len() != 1000 {
+		t.Fatalf("got %d keys, want 1000", kl.Len())
+	}
+	for i := range kl.shards {
+		if n := len(kl.shards[i].entries); n < 1000/8/2 {
+			t.Errorf("got %d keys in shard %d, want an even distribution", n, i)
+		}
+	}
+}
+
+func TestKeyedWaitBanned(t *testing.T) {
+	kl, err := NewKeyed(KeyedOptions{QPS: 100, BanThreshold: 1, BanDuration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		kl.Allow("key")
+	}
+	if _, banned := kl.Ban("key"); !banned {
+		t.Fatal("key isn't banned")
+	}
+
+	// The wait fails right away until the key is unbanned
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var be *BanError
+	if err := kl.Wait(ctx, "key"); !errors.As(err, &be) || be.Key != "key" || time.Until(be.Until) < 59*time.Minute {
+		t.Fatalf("got %v, want a ban error", err)
+	}
+	if !kl.Unban("key") {
+		t.Fatal("key wasn't unbanned")
+	}
+	if err := kl.Wait(ctx, "key"); err != nil {
+		t.Errorf("got %v after the unban", err)
+	}
+}
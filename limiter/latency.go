@@ -0,0 +1,96 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// LatencyStats represents the latency statistics of a run
+type LatencyStats struct {
+	// Service is the service time (the callback duration)
+	Service ClassStats
+	// QueueWait is the time the queries waited for a worker after they were due by the pacing.
+	// It's the latency the limiter's own queuing added in the open-loop (rate limited) mode.
+	QueueWait ClassStats
+	// Response is the response time (the queue wait and the service time)
+	Response ClassStats
+	// Corrected is the callback duration corrected for the coordinated omission. When a worker
+	// stalls, the queries it should have dispatched by the rate in the meantime are recorded as
+	// if they had waited for the stall (like HdrHistogram's recordValueWithExpectedInterval).
+	// It's the same as the service time when the rate isn't limited.
+	Corrected ClassStats
+}
+
+// latencyStats represents the state of the latency statistics
+type latencyStats struct {
+	mu        sync.Mutex
+	service   histogram
+	queueWait histogram
+	response  histogram
+	corrected histogram
+}
+
+// add records a query by the given queue wait, latency and the expected interval between the
+// queries of a worker
+func (ls *latencyStats) add(wait, latency, interval time.Duration) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.service.add(latency)
+	ls.queueWait.add(wait)
+	ls.response.add(wait + latency)
+	ls.corrected.add(latency)
+	if interval <= 0 {
+		return
+	}
+	for missed := latency - interval; missed >= interval; missed -= interval {
+		ls.corrected.add(missed)
+	}
+}
+
+// expectedInterval returns the expected interval between the queries of a worker by the
+// current rate, or zero if the rate isn't limited
+func (limiter *Limiter) expectedInterval() time.Duration {
+	r := limiter.lim.Limit()
+	if r == rate.Inf || r <= 0 {
+		return 0
+	}
+	return time.Duration(math.Round(float64(limiter.targetConcurrency.Load()) / float64(r) * float64(time.Second)))
+}
+
+// LatencyStats returns the latency statistics of the current (or last) run.
+// It's empty unless Options.LatencyStats is set.
+func (limiter *Limiter) LatencyStats() LatencyStats {
+	ls := runState(limiter, &limiter.latencyStats)
+	if ls == nil {
+		return LatencyStats{}
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return LatencyStats{
+		Service:   ls.service.stats(),
+		QueueWait: ls.queueWait.stats(),
+		Response:  ls.response.stats(),
+		Corrected: ls.corrected.stats(),
+	}
+}
+
+// QueueWait returns the time the query waited for a worker after it was due by the pacing
+func (r Result) QueueWait() time.Duration {
+	if r.Scheduled.IsZero() || r.Start.Before(r.Scheduled) {
+		return 0
+	}
+	return r.Start.Sub(r.Scheduled)
+}
+
+// ResponseTime returns the response time of the query (the queue wait and the service time)
+func (r Result) ResponseTime() time.Duration {
+	return r.QueueWait() + r.Duration
+}
@@ -8,10 +8,17 @@ package limiter
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"math"
 	"os"
 	"os/signal"
-	"strings"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -22,18 +29,169 @@ import (
 
 // Options represents the options that can be set when creating a new limiter
 type Options struct {
+	// Name is the name of the limiter (default "gorate")
+	Name string
 	// Concurrency level
 	Concurrency uint32
+	// AutoTune enables the concurrency auto-tuner which adjusts the number of workers to sustain
+	// the QPS by the observed callback latency. Concurrency is used as the initial level.
+	AutoTune bool
+	// MaxConcurrency is the max concurrency level for the auto-tuner (default 1024)
+	MaxConcurrency uint32
+	// MaxInFlight is the limit for the number of queries in flight (optional).
+	// It's enforced together with the QPS and the other configured resources.
+	MaxInFlight uint32
+	// BytesPerSecond is the limit for the number of bytes per second reported by the callbacks
+	// via CallbackParams.AddBytes (optional). It's enforced together with the QPS.
+	BytesPerSecond uint64
+	// CostLimit is the limit for the total cost reported by the callbacks via CallbackParams.AddCost
+	// within a cost window (optional)
+	CostLimit float64
+	// CostWindow is the length of the cost window (default 1h)
+	CostWindow time.Duration
 	// Limit is the limit for the total number of queries
 	Limit uint32
 	// QPS is the limit for the number of queries per second
 	QPS uint32
 	// Duration is the limit for making queries
 	Duration time.Duration
+	// GroupLimits are the limits of the concurrency groups by group id (optional), so the groups
+	// can run for different durations or query counts. A group stops once it reaches any of its
+	// limits (or the limits of the run) and the run ends when all the groups stop.
+	GroupLimits map[int]GroupLimit
+	// StartAt is the time the run starts at (optional), so the runs of multiple hosts can start
+	// together. Run blocks until then and the duration limit counts from it.
+	StartAt time.Time
+	// TimeSource is the source of the time StartAt is compared to (default the local clock), e.g.
+	// an NTPTimeSource so the start times line up across the hosts regardless of their clocks
+	TimeSource TimeSource
+	// StaggerStart spreads the start of the workers evenly over the given duration (optional), so
+	// a high concurrency level with a burst doesn't start with a synchronized burst
+	StaggerStart time.Duration
+	// Overdraft is the number of tokens the QPS budget may go into debt during spikes (optional).
+	// Once a wait would exceed it, the debt is paid back by running below the rate before the
+	// budget can be overdrawn again, like the quotas of some upstream providers.
+	Overdraft uint32
+	// BatchSize is the number of tokens granted to a worker at once (default 1)
+	BatchSize uint32
+	// LockOSThread locks every worker to its own OS thread for the duration of the run so the
+	// pacing isn't delayed by the goroutine scheduler at very high rates. Locked threads aren't
+	// shared, so the concurrency should stay below GOMAXPROCS; for the least jitter the process
+	// can also be pinned to dedicated CPUs (e.g. by taskset or cpusets).
+	LockOSThread bool
+	// BusyPoll enables the busy-poll mode by the given duration (optional, e.g. 200µs).
+	// The workers spin for the last part of every pacing wait instead of sleeping so the dispatch
+	// times are accurate below 100µs. It costs a CPU core per waiting worker, so it should be used
+	// with a low concurrency level and ideally with LockOSThread.
+	BusyPoll time.Duration
+	// RecordSchedule records the dispatch times of the run (see Limiter.Schedule)
+	RecordSchedule bool
+	// Schedule replays the given dispatch schedule instead of pacing the queries by the QPS,
+	// so runs of different builds can be compared with the identical schedule. The run ends
+	// once the schedule is done.
+	Schedule Schedule
+	// FeedbackInterval enables the achieved-rate feedback controller by the given interval.
+	// The controller adjusts the internal rate so the delivered rate matches the QPS over the whole run.
+	FeedbackInterval time.Duration
+	// Adaptive enables the adaptive client-side rate limiting which slows down when the target
+	// throttles and speeds back up to the QPS on success. Throttling errors don't stop the workers.
+	Adaptive bool
+	// IsThrottle reports whether the given callback error is a throttling error (default errors.Is(err, ErrThrottled))
+	IsThrottle func(err error) bool
+	// Blackouts are the windows during which no queries are dispatched (optional).
+	// The run resumes after a window ends; the duration limit isn't extended.
+	Blackouts []Blackout
+	// HealthCheck checks the health of the target (optional, see HTTPHealthCheck).
+	// The run starts at the safe rate, switches to the QPS after HealthyThreshold consecutive
+	// successful checks and drops back to the safe rate on a failed check.
+	HealthCheck func(ctx context.Context) error
+	// HealthCheckInterval is the interval of the health checks (default 1s)
+	HealthCheckInterval time.Duration
+	// HealthyThreshold is the number of consecutive successful health checks to restore the QPS (default 3)
+	HealthyThreshold int
+	// SafeQPS is the rate while the target is unhealthy (default QPS/10, at least 1)
+	SafeQPS uint32
 	// Callback is the function that is invoked on every query
 	Callback func(cbp CallbackParams) error
+	// BatchCallback enables the batch mode where up to BatchMaxSize admitted queries (or the queries
+	// admitted within BatchMaxWait) are passed to a single call (see Batch). It replaces the callback.
+	BatchCallback func(batch []CallbackParams) error
+	// BatchMaxSize is the max number of queries in a batch
+	BatchMaxSize uint32
+	// BatchMaxWait is the max wait for a batch to fill up (default 100ms)
+	BatchMaxWait time.Duration
+	// QueryTimeout is the timeout of every callback invocation (optional).
+	// The callback context is canceled with the ErrQueryTimeout cause once it's reached, and the
+	// timed out queries are counted as failures and by NumOfTimeouts.
+	QueryTimeout time.Duration
+	// Gate is evaluated after a token is acquired but before the callback (optional).
+	// When it returns false the slot is skipped without counting as a query and its token is returned
+	// to the worker, so the admitted slots keep the full QPS. The consecutive skips of a worker use up
+	// their tokens so a closed gate is polled at the rate limit. Gate errors are treated like callback errors.
+	Gate func(ctx context.Context, gi GateInfo) (proceed bool, err error)
+	// Middlewares wrap the callback for the cross-cutting concerns such as logging, timing,
+	// retries and panic recovery (optional). The first middleware is the outermost one.
+	Middlewares []Middleware
+	// WatchdogInterval enables the watchdog which samples the goroutine count, the heap and the
+	// GC pauses of the process by the given interval, so leaks in the target or the callbacks are
+	// caught during long runs. The run is aborted with ErrWatchdog once a threshold is exceeded.
+	WatchdogInterval time.Duration
+	// MaxGoroutines is the goroutine count threshold of the watchdog (optional)
+	MaxGoroutines int
+	// MaxHeapBytes is the allocated heap bytes threshold of the watchdog (optional)
+	MaxHeapBytes uint64
+	// MaxGCPause is the GC pause threshold of the watchdog (optional)
+	MaxGCPause time.Duration
+	// Breakdown enables the breakdown of the queries by the status code reported by the callbacks
+	// (see CallbackParams.SetStatusCode) and by error class, with latency percentiles (see Breakdown)
+	Breakdown bool
+	// ErrorClassifier groups the callback errors into named classes for the breakdown and the
+	// results (default ClassifyError). An empty class falls back to the default classifier.
+	// Setting it enables the breakdown.
+	ErrorClassifier func(err error) string
+	// LatencyStats enables the latency statistics of the callbacks, including the percentiles
+	// corrected for the coordinated omission (see LatencyStats)
+	LatencyStats bool
 	// SignalHandler enables the signal handler
 	SignalHandler bool
+	// Seed is the seed of the random sources of the run (default random).
+	// It's recorded in the report so a run can be replayed deterministically (see CallbackParams.Rand).
+	Seed int64
+	// Context is the base context (optional).
+	// Its values are propagated to the contexts passed to callbacks and its cancellation stops the limiter.
+	Context context.Context
+	// Tracer creates a root span for the run and a child span for every query (optional)
+	Tracer Tracer
+	// Sink is the destination for the query results (optional)
+	Sink Sink
+	// SinkBuffer is the size of the queue between the workers and the sink (optional).
+	// When it's set, a single goroutine writes the results so the workers don't wait for the sink
+	// unless the queue is full. Otherwise the workers write to the sink directly.
+	SinkBuffer int
+	// SinkBackpressure is the behavior when the sink queue is full (default BackpressureBlock)
+	SinkBackpressure Backpressure
+	// SinkSpillDir is the directory of the spill file of BackpressureSpill (default os.TempDir())
+	SinkSpillDir string
+	// SinkSpillMaxSize is the max size of the spill file in bytes (default 256MiB)
+	SinkSpillMaxSize int64
+	// RateErrorPolicy is the behavior when waiting for a resource fails by a non-context error
+	// (default RateErrorStop)
+	RateErrorPolicy RateErrorPolicy
+	// RateErrorRetries is the max number of consecutive retries of RateErrorRetry (default 3)
+	RateErrorRetries uint32
+	// RateErrorRetryDelay is the delay between the retries of RateErrorRetry (default 100ms)
+	RateErrorRetryDelay time.Duration
+	// Instrument is the hook that is invoked with the token statistics every instrument interval (optional)
+	Instrument func(ts TokenStats)
+	// InstrumentInterval is the interval of the instrumentation hook (default 1s)
+	InstrumentInterval time.Duration
+	// OnProgress is the hook that is invoked with the progress every progress interval and once
+	// when the run ends (optional)
+	OnProgress func(p ProgressInfo)
+	// ProgressInterval is the interval of the progress hook (default 1s)
+	ProgressInterval time.Duration
+	// Webhooks are the webhook notifications of the run lifecycle events (optional, see Webhook)
+	Webhooks []Webhook
 }
 
 // CallbackParams represents the callback function parameters
@@ -42,194 +200,1040 @@ type CallbackParams struct {
 	Limiter *Limiter
 	// GroupID is the id for the concurrency group
 	GroupID int
+	// Sequence is the sequence number of the query (starts from 1)
+	Sequence int
+	// ScheduleIndex is the index of the dispatch in Options.Schedule, or -1 without a schedule.
+	// The dispatches skipped by the gate don't get a sequence number so it may differ from Sequence-1.
+	ScheduleIndex int
+	// RunID is the unique id of the run
+	RunID string
+	// Context is the query context.
+	// It's canceled when the limiter is canceled but not when the deadline is reached so in-flight queries can finish.
+	Context context.Context
+
+	usage *usage
 }
 
 // New creates a new limiter by the given options
 func New(o Options) (*Limiter, error) {
 	// Init the limiter
 	limiter := Limiter{
-		concurrency:   o.Concurrency,
-		limit:         o.Limit,
-		qps:           o.QPS,
-		duration:      o.Duration,
-		callback:      o.Callback,
-		signalHandler: o.SignalHandler,
+		name:               o.Name,
+		concurrency:        o.Concurrency,
+		autoTune:           o.AutoTune,
+		maxConcurrency:     o.MaxConcurrency,
+		maxInFlight:        o.MaxInFlight,
+		bytesPerSecond:     o.BytesPerSecond,
+		limit:              o.Limit,
+		qps:                o.QPS,
+		duration:           o.Duration,
+		groupLimits:        o.GroupLimits,
+		startAt:            o.StartAt,
+		timeSource:         o.TimeSource,
+		staggerStart:       o.StaggerStart,
+		overdraft:          o.Overdraft,
+		batchSize:          o.BatchSize,
+		lockOSThread:       o.LockOSThread,
+		busyPoll:           o.BusyPoll,
+		adaptiveMode:       o.Adaptive,
+		isThrottle:         o.IsThrottle,
+		feedbackInterval:   o.FeedbackInterval,
+		callback:           o.Callback,
+		queryTimeout:       o.QueryTimeout,
+		healthCheck:        o.HealthCheck,
+		blackouts:          o.Blackouts,
+		healthInterval:     o.HealthCheckInterval,
+		healthyThreshold:   o.HealthyThreshold,
+		safeQPS:            o.SafeQPS,
+		gate:               o.Gate,
+		watchdogInterval:   o.WatchdogInterval,
+		breakdownMode:      o.Breakdown || o.ErrorClassifier != nil,
+		errorClassifier:    o.ErrorClassifier,
+		maxGoroutines:      o.MaxGoroutines,
+		maxHeapBytes:       o.MaxHeapBytes,
+		maxGCPause:         o.MaxGCPause,
+		latencyMode:        o.LatencyStats,
+		signalHandler:      o.SignalHandler,
+		schedule:           o.Schedule,
+		seed:               o.Seed,
+		parentContext:      o.Context,
+		tracer:             o.Tracer,
+		sink:               o.Sink,
+		sinkBuffer:         o.SinkBuffer,
+		sinkBackpressure:   o.SinkBackpressure,
+		sinkSpillDir:       o.SinkSpillDir,
+		sinkSpillMaxSize:   o.SinkSpillMaxSize,
+		rateErrorPolicy:    o.RateErrorPolicy,
+		rateErrorRetries:   o.RateErrorRetries,
+		rateErrorDelay:     o.RateErrorRetryDelay,
+		instrument:         o.Instrument,
+		instrumentInterval: o.InstrumentInterval,
+		onProgress:         o.OnProgress,
+		progressInterval:   o.ProgressInterval,
+		webhooks:           slices.Clone(o.Webhooks),
 	}
 
 	// Check the options
-	if o.Limit > 0 && o.Limit < o.Concurrency {
-		return nil, errors.New("limit value must be greater than concurrency value")
-	} else if o.Limit == 0 && o.Duration == 0 {
-		return nil, errors.New("set either limit or duration value")
+	if err := validate(o); err != nil {
+		return nil, err
+	}
+	if limiter.name == "" {
+		limiter.name = "gorate"
+	}
+	if limiter.batchSize == 0 {
+		limiter.batchSize = 1
+	}
+	if o.BatchCallback != nil {
+		limiter.callback = Batch(int(o.BatchMaxSize), o.BatchMaxWait, o.BatchCallback)
+	}
+	if limiter.callback != nil && len(o.Middlewares) > 0 {
+		limiter.callback = Chain(limiter.callback, o.Middlewares...)
+	}
+	if o.RecordSchedule {
+		limiter.recorder = &scheduleRecorder{}
+	}
+	if len(limiter.schedule) > 0 && (limiter.limit == 0 || limiter.limit > uint32(len(limiter.schedule))) {
+		limiter.limit = uint32(len(limiter.schedule))
+	}
+	if limiter.sinkSpillMaxSize == 0 {
+		limiter.sinkSpillMaxSize = defaultSpillMaxSize
+	}
+	if limiter.rateErrorRetries == 0 {
+		limiter.rateErrorRetries = defaultRateErrorRetries
+	}
+	if limiter.rateErrorDelay == 0 {
+		limiter.rateErrorDelay = defaultRateErrorRetryDelay
+	}
+	if limiter.instrumentInterval == 0 {
+		limiter.instrumentInterval = defaultInstrumentInterval
+	}
+	if limiter.progressInterval == 0 {
+		limiter.progressInterval = defaultProgressInterval
+	}
+	for _, wh := range limiter.webhooks {
+		if wh.SLOLatency > 0 {
+			limiter.latencyMode = true
+		}
+	}
+	if limiter.autoTune {
+		if limiter.concurrency == 0 {
+			limiter.concurrency = 1
+		}
+		if limiter.maxConcurrency == 0 {
+			limiter.maxConcurrency = defaultMaxConcurrency
+		}
+	} else {
+		limiter.maxConcurrency = limiter.concurrency
+	}
+	if o.CostLimit > 0 {
+		limiter.cost = &costBudget{limit: o.CostLimit, window: o.CostWindow}
+		if limiter.cost.window == 0 {
+			limiter.cost.window = time.Hour
+		}
+	}
+	if limiter.healthCheck != nil {
+		if limiter.healthInterval == 0 {
+			limiter.healthInterval = defaultHealthCheckInterval
+		}
+		if limiter.healthyThreshold == 0 {
+			limiter.healthyThreshold = defaultHealthyThreshold
+		}
+		if limiter.safeQPS == 0 {
+			limiter.safeQPS = o.QPS / defaultSafeQPSDivisor
+			if limiter.safeQPS == 0 {
+				limiter.safeQPS = 1
+			}
+		}
 	}
 
 	return &limiter, nil
 }
 
-// Limiter represents a limiter
+// Limiter represents a limiter.
+// The counters use the sync/atomic types (never plain integers with the atomic functions) so they
+// are 64-bit aligned on the 32-bit platforms too regardless of the field order.
 type Limiter struct {
-	concurrency     uint32
-	limit           uint32
-	qps             uint32
-	duration        time.Duration
-	callback        func(cbp CallbackParams) error
-	signalHandler   bool
-	lim             *rate.Limiter
-	limContext      context.Context
-	limCancelFunc   context.CancelFunc
-	counters        []uint32
-	wg              sync.WaitGroup
-	start           time.Time
-	since           time.Duration
-	done            bool
-	lastError       error
-	isDeadline      bool
-	isCanceled      bool
-	isQueryLimit    bool
-	isRateError     bool
-	isCallbackError bool
+	name               string
+	runID              string
+	concurrency        uint32
+	autoTune           bool
+	maxConcurrency     uint32
+	maxInFlight        uint32
+	bytesPerSecond     uint64
+	limit              uint32
+	qps                uint32
+	duration           time.Duration
+	groupLimits        map[int]GroupLimit
+	startAt            time.Time
+	timeSource         TimeSource
+	staggerStart       time.Duration
+	overdraft          uint32
+	overdrafts         atomic.Int64
+	repaidAt           atomic.Int64
+	batchSize          uint32
+	lockOSThread       bool
+	busyPoll           time.Duration
+	adaptiveMode       bool
+	isThrottle         func(err error) bool
+	feedbackInterval   time.Duration
+	callback           Callback
+	queryTimeout       time.Duration
+	timeouts           atomic.Int64
+	healthCheck        func(ctx context.Context) error
+	blackouts          []Blackout
+	healthInterval     time.Duration
+	healthyThreshold   int
+	safeQPS            uint32
+	healthy            atomic.Bool
+	gate               func(ctx context.Context, gi GateInfo) (bool, error)
+	skips              atomic.Int64
+	watchdogInterval   time.Duration
+	maxGoroutines      int
+	maxHeapBytes       uint64
+	maxGCPause         time.Duration
+	watchdog           *watchdog
+	breakdownMode      bool
+	errorClassifier    func(err error) string
+	breakdown          *breakdown
+	latencyMode        bool
+	latencyStats       *latencyStats
+	signalHandler      bool
+	recorder           *scheduleRecorder
+	schedule           Schedule
+	scheduleNext       atomic.Int64
+	seed               int64
+	runSeed            int64
+	parentContext      context.Context
+	tracer             Tracer
+	sink               Sink
+	sinkBuffer         int
+	sinkBackpressure   Backpressure
+	sinkSpillDir       string
+	sinkSpillMaxSize   int64
+	sinkQueue          *sinkQueue
+	rateErrorPolicy    RateErrorPolicy
+	rateErrorRetries   uint32
+	rateErrorDelay     time.Duration
+	rateErrors         atomic.Int64
+	qpsMeter           atomic.Pointer[qpsMeter]
+	instrument         func(ts TokenStats)
+	instrumentInterval time.Duration
+	onProgress         func(p ProgressInfo)
+	progressInterval   time.Duration
+	webhooks           []Webhook
+	notifier           *webhookNotifier
+	webhookErrors      atomic.Int64
+	lim                *rate.Limiter
+	resources          []*resource
+	inFlight           chan struct{}
+	inFlightWait       atomic.Int64
+	bandwidth          *rate.Limiter
+	numOfBytes         atomic.Int64
+	cost               *costBudget
+	totalCost          atomicFloat64
+	adaptive           *adaptive
+	mu                 sync.RWMutex
+	baseContext        context.Context
+	limContext         context.Context
+	limCancelFunc      context.CancelCauseFunc
+	deadline           time.Time
+	deadlineCancel     context.CancelFunc
+	counters           []groupCounters
+	wg                 sync.WaitGroup
+	workersMu          sync.Mutex
+	workers            []workerState
+	numOfWorkers       int
+	targetConcurrency  atomic.Uint32
+	granted            atomic.Int64
+	waited             atomic.Int64
+	waitTotal          atomic.Int64
+	start              time.Time
+	since              time.Duration
+	done               bool
+	background         sync.WaitGroup
+	lastError          error
+	isDeadline         atomic.Bool
+	isCanceled         atomic.Bool
+	isQueryLimit       atomic.Bool
+	isRateError        atomic.Bool
+	isCallbackError    atomic.Bool
 }
 
 // Run runs the limiter
 func (limiter *Limiter) Run() error {
 	// Context
+	// The deadline context is derived from the base context so it can be recreated by ExtendDuration
+	parent := limiter.parentContext
+	if parent == nil {
+		parent = context.Background()
+	}
+	runID, err := newRunID()
+	if err != nil {
+		return err
+	}
+	seed := limiter.seed
+	if seed == 0 {
+		if seed, err = newSeed(); err != nil {
+			return err
+		}
+	}
+	limiter.mu.Lock()
+	limiter.runID = runID
+	limiter.runSeed = seed
+	limiter.done = false
+	limiter.start = time.Time{}
+	limiter.mu.Unlock()
+	if limiter.tracer != nil {
+		var endSpan EndSpanFunc
+		parent, endSpan = limiter.tracer.StartSpan(parent, limiter.name+".run", SpanAttribute{Key: "gorate.run_id", Value: runID})
+		defer func() { endSpan(limiter.LastError()) }()
+	}
+	startIn := limiter.startDelay()
+	limiter.mu.Lock()
+	limiter.baseContext, limiter.limCancelFunc = context.WithCancelCause(parent)
 	if limiter.duration > 0 {
-		limiter.limContext, limiter.limCancelFunc = context.WithTimeout(context.Background(), limiter.duration)
+		limiter.deadline = time.Now().Add(startIn + limiter.duration)
+		limiter.limContext, limiter.deadlineCancel = context.WithDeadlineCause(limiter.baseContext, limiter.deadline, ErrDurationLimit)
 	} else {
-		limiter.limContext, limiter.limCancelFunc = context.WithCancel(context.Background())
+		limiter.limContext, limiter.deadlineCancel = limiter.baseContext, func() {}
 	}
-	defer limiter.limCancelFunc()
+	limiter.mu.Unlock()
+	defer func() {
+		limiter.mu.RLock()
+		limiter.deadlineCancel()
+		limiter.mu.RUnlock()
+		limiter.limCancelFunc(limiter.endCause())
+	}()
 
 	// Singal handling
 	if limiter.signalHandler {
 		ch := make(chan os.Signal, 1)
 		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-		go func() {
-			<-ch
-			limiter.limCancelFunc()
-		}()
+		defer signal.Stop(ch)
+		limiter.goBackground(func(ctx context.Context) {
+			select {
+			case sig := <-ch:
+				limiter.limCancelFunc(fmt.Errorf("%w: %v", ErrSignal, sig))
+			case <-ctx.Done():
+			}
+		})
 	}
 
-	// Wait group
-	limiter.wg.Add(int(limiter.concurrency))
+	// Start time
+	// The run can be stopped while waiting, in which case the workers return right away
+	if startIn > 0 {
+		timer := time.NewTimer(startIn)
+		_ = limiter.sleep(limiter.baseContext, startIn, timer)
+		timer.Stop()
+	}
 
 	// Limiter
+	// The per-run state is set under the lock so the getters (e.g. of a manager report) can read it during the run
+	limiter.mu.Lock()
 	limiter.start = time.Now()
 	if limiter.qps > 0 {
-		limiter.lim = rate.NewLimiter(rate.Limit(float64(limiter.qps)), 1) // burst should be 1
+		limiter.lim = rate.NewLimiter(rate.Limit(float64(limiter.qps)), int(limiter.batchSize)) // burst should be the batch size
 	} else {
 		limiter.lim = rate.NewLimiter(rate.Inf, 0)
 	}
+	if limiter.cost != nil {
+		limiter.cost.start = limiter.start
+		limiter.cost.used = 0
+		limiter.cost.total.store(0)
+		limiter.cost.wait.Store(0)
+	}
+	limiter.totalCost.store(0)
+	limiter.scheduleNext.Store(0)
+	limiter.rateErrors.Store(0)
+	limiter.overdrafts.Store(0)
+	limiter.repaidAt.Store(0)
+	limiter.qpsMeter.Store(newQPSMeter(limiter.start))
+	if limiter.recorder != nil {
+		limiter.recorder.mu.Lock()
+		limiter.recorder.entries = limiter.recorder.entries[:0]
+		limiter.recorder.mu.Unlock()
+	}
+	limiter.resources = []*resource{
+		{name: ResourceQPS, lim: limiter.lim, n: func(batch int) int { return batch }},
+	}
+	if limiter.adaptiveMode {
+		limiter.adaptive = newAdaptive(limiter.lim, float64(limiter.qps), limiter.isThrottle)
+	}
+	if limiter.bytesPerSecond > 0 {
+		// The bucket holds one second worth of bytes and the callbacks put it into debt after the queries
+		limiter.bandwidth = rate.NewLimiter(rate.Limit(float64(limiter.bytesPerSecond)), int(limiter.bytesPerSecond))
+		limiter.resources = append(limiter.resources, &resource{name: ResourceBandwidth, lim: limiter.bandwidth, n: func(int) int { return 0 }})
+	}
+	if limiter.maxInFlight > 0 {
+		limiter.inFlight = make(chan struct{}, limiter.maxInFlight)
+	}
+	if limiter.watchdogInterval > 0 {
+		limiter.watchdog = &watchdog{}
+	}
+	if limiter.breakdownMode {
+		limiter.breakdown = newBreakdown()
+	}
+	if limiter.latencyMode {
+		limiter.latencyStats = &latencyStats{}
+	}
+	limiter.webhookErrors.Store(0)
+	limiter.notifier = nil
+	if len(limiter.webhooks) > 0 {
+		limiter.notifier = newWebhookNotifier(&limiter.webhookErrors)
+	}
+	if limiter.sink != nil && limiter.sinkBuffer > 0 {
+		limiter.sinkQueue = newSinkQueue(limiter.sink, limiter.sinkBuffer, limiter.sinkBackpressure, limiter.sinkSpillDir, limiter.sinkSpillMaxSize, limiter.setError)
+	}
+	// Counters and worker slots are sized by the max concurrency so the auto-tuner can add workers later
+	l := int(limiter.maxConcurrency) + 1
+	limiter.counters = make([]groupCounters, l)
+	limiter.workers = make([]workerState, l)
+	limiter.mu.Unlock()
+
+	// Health check
+	if limiter.healthCheck != nil {
+		limiter.healthy.Store(false)
+		limiter.lim.SetLimit(rate.Limit(float64(limiter.safeQPS)))
+		limiter.goBackground(func(ctx context.Context) { limiter.runHealthCheck(ctx, limiter.healthInterval) })
+	}
+
+	// Feedback controller
+	if limiter.qps > 0 && limiter.feedbackInterval > 0 {
+		limiter.goBackground(func(ctx context.Context) { limiter.runFeedback(ctx, limiter.feedbackInterval) })
+	}
 
 	// Concurrency loop
-	l := int(limiter.concurrency) + 1
-	limiter.counters = make([]uint32, l)
-	for i := 1; i < l; i++ {
-		go func(i int) {
-			// Request loop
-			for {
-				// Limiter
-				err := limiter.lim.Wait(limiter.limContext)
-				if err != nil {
-					if err == context.DeadlineExceeded || strings.Contains(err.Error(), "context deadline") {
-						limiter.isDeadline = true
-					} else if err == context.Canceled {
-						limiter.isCanceled = true
-					} else {
-						limiter.isRateError = true
-						limiter.lastError = err
-					}
-					limiter.wg.Done()
-					break
+	limiter.targetConcurrency.Store(limiter.concurrency)
+	limiter.workersMu.Lock()
+	for i := 1; i <= int(limiter.concurrency); i++ {
+		limiter.startWorker(i)
+	}
+	limiter.workersMu.Unlock()
+
+	// Auto-tuner
+	if limiter.autoTune {
+		limiter.goBackground(func(ctx context.Context) { limiter.runAutoTune(ctx, autoTuneInterval) })
+	}
+
+	// Watchdog
+	if limiter.watchdogInterval > 0 {
+		limiter.goBackground(func(ctx context.Context) { limiter.runWatchdog(ctx, limiter.watchdogInterval) })
+	}
+
+	// Instrumentation
+	if limiter.instrument != nil {
+		limiter.goBackground(func(ctx context.Context) { limiter.runInstrument(ctx, limiter.instrumentInterval) })
+	}
+
+	// Progress
+	if limiter.onProgress != nil {
+		limiter.goBackground(func(ctx context.Context) { limiter.runProgress(ctx, limiter.progressInterval) })
+	}
+
+	// Webhooks
+	limiter.notify(WebhookRunStart, "run started")
+	for i, wh := range limiter.webhooks {
+		if wh.SLOLatency > 0 || wh.ErrorRate > 0 {
+			limiter.goBackground(func(ctx context.Context) { limiter.runWebhookChecks(ctx, i) })
+		}
+	}
+
+	// The background goroutines are stopped once the workers are done so none of them outlives the run
+	limiter.wg.Wait()
+	limiter.limCancelFunc(limiter.endCause())
+	limiter.background.Wait()
+	if limiter.sinkQueue != nil {
+		limiter.sinkQueue.close()
+	}
+	if limiter.sink != nil {
+		if err := limiter.sink.Flush(); err != nil {
+			limiter.setError(err)
+		}
+	}
+	limiter.mu.Lock()
+	limiter.since = time.Since(limiter.start)
+	limiter.done = true
+	limiter.mu.Unlock()
+	if rs, ok := limiter.sink.(ReportSink); ok {
+		if err := rs.WriteReport(limiter); err != nil {
+			limiter.setError(err)
+		}
+	}
+	if limiter.onProgress != nil {
+		limiter.onProgress(limiter.progressInfo())
+	}
+	if limiter.notifier != nil {
+		limiter.notify(WebhookRunEnd, fmt.Sprintf("run ended: %v", limiter.Cause()))
+		limiter.notifier.close()
+	}
+
+	return limiter.LastError()
+}
+
+// workerState represents the state of a worker slot
+type workerState uint8
+
+const (
+	// workerUnused is a slot whose worker was never started
+	workerUnused workerState = iota
+	// workerRunning is a running worker
+	workerRunning
+	// workerParked is a worker stopped by the auto-tuner, which may restart it
+	workerParked
+	// workerDone is a worker stopped for good (e.g. by an error or a limit)
+	workerDone
+)
+
+// startWorker starts the worker by the given group id.
+// It must be called while holding the workers lock.
+func (limiter *Limiter) startWorker(i int) {
+	limiter.workers[i] = workerRunning
+	limiter.numOfWorkers++
+	limiter.wg.Add(1)
+	go pprof.Do(limiter.baseContext, limiter.pprofLabels("gorate.group", strconv.Itoa(i)), func(context.Context) {
+		// The wait group is released once the worker returns so its deferred accounting is part of the run
+		defer limiter.wg.Done()
+		limiter.work(i)
+	})
+}
+
+// stopWorker stops the worker by the given group id.
+// When the worker is stopped by the auto-tuner (scaleDown) and the target concurrency was
+// raised again in the meantime, the worker is kept and false is returned.
+func (limiter *Limiter) stopWorker(i int, scaleDown bool) bool {
+	limiter.workersMu.Lock()
+	defer limiter.workersMu.Unlock()
+	if scaleDown && i <= int(limiter.targetConcurrency.Load()) {
+		return false
+	}
+	limiter.workers[i] = workerDone
+	if scaleDown {
+		limiter.workers[i] = workerParked
+	}
+	limiter.numOfWorkers--
+	return true
+}
+
+// work runs the request loop for the given group id
+func (limiter *Limiter) work(i int) {
+	runID := limiter.RunID()
+	baseCtx := limiter.baseContext
+	spanName := fmt.Sprintf("%s.group.%d", limiter.name, i)
+	defer limiter.recoverWorker(i)
+	if limiter.lockOSThread {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	// Request loop
+	// The usage and the timer are reused across the queries so the loop doesn't allocate
+	var tokens uint32
+	var granted, scheduled time.Time
+	skipped := false
+	slot := -1
+	gl := limiter.groupLimits[i]
+	var u usage
+	rr := rateRecovery{batch: limiter.batchSize}
+	qm := limiter.qpsMeter.Load()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	// Time accounting
+	// The waits interrupted by the retries are accounted at the next iteration or once the worker returns
+	mark := time.Now()
+	since := mark
+	defer func() {
+		limiter.accountThrottled(i, since)
+		limiter.account(i, mark)
+	}()
+
+	// Staggered start
+	// The waits are cut short once the context is done so the loop handles the end of the run
+	if limiter.staggerStart > 0 {
+		offset := limiter.staggerStart * time.Duration(i-1) / time.Duration(limiter.concurrency)
+		if d := time.Until(limiter.start.Add(offset)); d > 0 {
+			resetTimer(timer, d)
+			select {
+			case <-timer.C:
+			case <-limiter.Context().Done():
+			}
+		}
+	}
+	for {
+		// Auto-tuner
+		if limiter.autoTune && i > int(limiter.targetConcurrency.Load()) {
+			if limiter.stopWorker(i, true) {
+				return
+			}
+		}
+
+		ctx := limiter.Context()
+
+		// Time accounting
+		limiter.accountThrottled(i, since)
+		mark = limiter.account(i, mark)
+		since = mark
+
+		// Blackout windows
+		// Tokens granted before the window are dropped
+		if len(limiter.blackouts) > 0 && limiter.waitBlackout(ctx) {
+			tokens = 0
+			continue
+		}
+
+		// In-flight
+		if limiter.inFlight != nil {
+			if err := limiter.acquireInFlight(ctx); err != nil {
+				if limiter.handleWaitError(ctx, err, nil, timer) {
+					tokens = 0
+					continue
+				}
+				limiter.stopWorker(i, false)
+				return
+			}
+		}
+
+		// Cost budget
+		if limiter.cost != nil {
+			if err := limiter.cost.acquire(ctx); err != nil {
+				limiter.releaseInFlight()
+				if limiter.handleWaitError(ctx, err, nil, timer) {
+					tokens = 0
+					continue
+				}
+				limiter.stopWorker(i, false)
+				return
+			}
+		}
+
+		// Schedule replay
+		// The dispatch is kept across the retries so none of them is lost
+		if limiter.schedule != nil {
+			var at time.Time
+			var err error
+			slot, at, err = limiter.waitSchedule(ctx, slot, timer)
+			if err == errScheduleDone {
+				limiter.isQueryLimit.Store(true)
+				limiter.releaseInFlight()
+				limiter.stopWorker(i, false)
+				return
+			} else if err != nil {
+				limiter.releaseInFlight()
+				if limiter.handleWaitError(ctx, err, nil, timer) {
+					continue
+				}
+				limiter.stopWorker(i, false)
+				return
+			}
+			scheduled = at
+		}
+
+		// Limiter
+		// Tokens are granted in batches so the limiter overhead is amortized at high rates.
+		// Remaining tokens are dropped once the context is done.
+		if tokens == 0 || ctx.Err() != nil {
+			t := time.Now()
+			due, err := limiter.waitTokens(ctx, int(rr.batch), timer)
+			if err != nil {
+				limiter.releaseInFlight()
+				if limiter.handleWaitError(ctx, err, &rr, timer) {
+					tokens = 0
+					continue
 				}
-				// Check the query limit
-				if limiter.limit > 0 && atomic.LoadUint32(&limiter.counters[0]) >= limiter.limit {
-					limiter.isQueryLimit = true
-					limiter.wg.Done()
-					return
+				limiter.stopWorker(i, false)
+				return
+			}
+			tokens = rr.batch
+			granted = due
+			rr.retries = 0
+			wait := time.Since(t)
+			limiter.granted.Add(int64(tokens))
+			limiter.waitTotal.Add(int64(wait))
+			if wait >= waitThreshold {
+				limiter.waited.Add(int64(tokens))
+			}
+		}
+		tokens--
+		if limiter.schedule == nil {
+			scheduled = granted
+		}
+		limiter.accountThrottled(i, since)
+		since = time.Time{}
+
+		// Check the query limit
+		if limiter.limit > 0 && limiter.counters[0].queries.Load() >= limiter.limit {
+			limiter.isQueryLimit.Store(true)
+			limiter.releaseInFlight()
+			limiter.stopWorker(i, false)
+			return
+		}
+
+		// Check the group limits
+		if gl != (GroupLimit{}) && limiter.isGroupDone(i, gl) {
+			limiter.releaseInFlight()
+			limiter.stopWorker(i, false)
+			return
+		}
+
+		// Gate
+		if limiter.gate != nil {
+			proceed, err := limiter.gate(ctx, GateInfo{GroupID: i, RunID: runID, NumOfQueries: limiter.NumOfQueries()})
+			if err != nil {
+				limiter.releaseInFlight()
+				limiter.isCallbackError.Store(true)
+				limiter.setError(err)
+				limiter.stopWorker(i, false)
+				return
+			} else if !proceed {
+				limiter.releaseInFlight()
+				limiter.skips.Add(1)
+				slot = -1
+				// The token is returned unless the previous slot was skipped too,
+				// so a closed gate is polled at the rate limit instead of spinning
+				if !skipped {
+					tokens++
 				}
+				skipped = true
+				continue
+			}
+			skipped = false
+		}
+
+		// Update counters
+		limiter.counters[i].queries.Add(1)
+		seq := limiter.counters[0].queries.Add(1) // total
+		index := slot
+		slot = -1
+		now := time.Now()
+		qm.add(now)
+		if limiter.recorder != nil {
+			limiter.recorder.record(seq, now.Sub(limiter.start))
+		}
 
-				// Update counters
-				atomic.AddUint32(&limiter.counters[i], 1)
-				atomic.AddUint32(&limiter.counters[0], 1) // total
-
-				// Callback
-				if limiter.callback != nil {
-					cbp := CallbackParams{Limiter: limiter, GroupID: i}
-					if err := limiter.callback(cbp); err != nil {
-						limiter.isCallbackError = true
-						limiter.lastError = err
-						limiter.wg.Done()
-						break
-					}
+		// Callback
+		var err error
+		var latency time.Duration
+		start := time.Now()
+		if limiter.callback != nil {
+			u.bytes.Store(0)
+			u.cost.store(0)
+			u.status.Store(0)
+			cbp := CallbackParams{Limiter: limiter, GroupID: i, Sequence: int(seq), ScheduleIndex: index, RunID: runID, Context: baseCtx, usage: &u}
+			var cancel context.CancelFunc
+			if limiter.queryTimeout > 0 {
+				cbp.Context, cancel = context.WithTimeoutCause(baseCtx, limiter.queryTimeout, ErrQueryTimeout)
+			}
+			qctx := cbp.Context
+			var endSpan EndSpanFunc
+			if limiter.tracer != nil {
+				cbp.Context, endSpan = limiter.tracer.StartSpan(qctx, spanName, SpanAttribute{Key: "gorate.sequence", Value: cbp.Sequence})
+			}
+			err = limiter.callback(cbp)
+			if endSpan != nil {
+				endSpan(err)
+			}
+			if cancel != nil {
+				if err != nil && context.Cause(qctx) == ErrQueryTimeout {
+					limiter.timeouts.Add(1)
 				}
+				cancel()
 			}
-		}(i)
+			latency = time.Since(start)
+			limiter.accountExecuting(i, latency)
+			if limiter.latencyStats != nil {
+				limiter.latencyStats.add(max(start.Sub(scheduled), 0), latency, limiter.expectedInterval())
+			}
+		}
+		limiter.releaseInFlight()
+
+		if err == nil {
+			limiter.counters[i].successes.Add(1)
+		} else {
+			limiter.counters[i].failures.Add(1)
+		}
+
+		// Breakdown
+		status, class := int(u.status.Load()), ""
+		if limiter.breakdown != nil || limiter.sink != nil {
+			class = limiter.classifyError(err, status)
+		}
+		if limiter.breakdown != nil {
+			limiter.breakdown.add(status, class, latency)
+		}
+
+		// Adaptive mode
+		throttled := limiter.adaptive != nil && limiter.adaptive.update(err)
+
+		// Sink
+		if limiter.sink != nil {
+			r := Result{RunID: runID, GroupID: i, Sequence: int(seq), Scheduled: scheduled, Start: start, Duration: latency, Bytes: u.bytes.Load(), Cost: u.cost.load(), StatusCode: status, ErrorClass: class, Err: err}
+			if limiter.sinkQueue != nil {
+				limiter.sinkQueue.write(r)
+			} else if err := limiter.sink.Write(r); err != nil {
+				limiter.setError(err)
+			}
+		}
+
+		if err != nil && !throttled {
+			limiter.isCallbackError.Store(true)
+			limiter.setError(err)
+			limiter.stopWorker(i, false)
+			return
+		}
 	}
-	limiter.wg.Wait()
-	limiter.since = time.Since(limiter.start)
-	limiter.done = true
+}
 
-	return limiter.lastError
+// handleWaitError handles the given error returned while waiting for a resource.
+// It returns true if the worker should retry with the current context.
+func (limiter *Limiter) handleWaitError(ctx context.Context, err error, rr *rateRecovery, timer *time.Timer) bool {
+	// The context is replaced when the duration is extended
+	if ctx != limiter.Context() {
+		return true
+	}
+	// The error may be wrapped so the context is inspected as well
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		limiter.isDeadline.Store(true)
+	case errors.Is(err, context.Canceled):
+		limiter.isCanceled.Store(true)
+	case ctx.Err() != nil:
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			limiter.isDeadline.Store(true)
+		} else {
+			limiter.isCanceled.Store(true)
+		}
+	default:
+		return limiter.handleRateError(ctx, err, rr, timer)
+	}
+	return false
 }
 
 // Context returns the context
 func (limiter *Limiter) Context() context.Context {
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
 	return limiter.limContext
 }
 
-// CancelFunc returns the cancel function
+// Name returns the name
+func (limiter *Limiter) Name() string {
+	return limiter.name
+}
+
+// RunID returns the unique id of the current (or last) run
+func (limiter *Limiter) RunID() string {
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
+	return limiter.runID
+}
+
+// CancelFunc returns the cancel function.
+// Canceling by it sets the cause of the run context to ErrStopped.
 func (limiter *Limiter) CancelFunc() context.CancelFunc {
-	return limiter.limCancelFunc
+	if limiter.limCancelFunc == nil {
+		return nil
+	}
+	return limiter.Stop
+}
+
+// ExtendDuration extends the duration of a running limiter by the given value
+func (limiter *Limiter) ExtendDuration(d time.Duration) error {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if limiter.limContext == nil || limiter.done || limiter.limContext.Err() != nil {
+		return errors.New("limiter is not running")
+	} else if limiter.duration == 0 {
+		return errors.New("limiter has no duration")
+	} else if d <= 0 {
+		return errors.New("duration extension must be greater than zero")
+	}
+
+	// Replace the deadline context first so workers waiting on the old one switch to the new one
+	cancel := limiter.deadlineCancel
+	limiter.duration += d
+	limiter.deadline = limiter.deadline.Add(d)
+	limiter.limContext, limiter.deadlineCancel = context.WithDeadlineCause(limiter.baseContext, limiter.deadline, ErrDurationLimit)
+	cancel()
+
+	return nil
 }
 
-// Since returns the since value
+// Since returns the elapsed time of the current (or last) run, or zero if it isn't started yet
 func (limiter *Limiter) Since() time.Duration {
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
 	if limiter.done {
 		return limiter.since
+	} else if limiter.start.IsZero() {
+		return 0
 	}
 	return time.Since(limiter.start)
 }
 
-// NumOfQueries returns the number of queries
+// NumOfQueries returns the number of queries (attempts, including the failed ones)
 func (limiter *Limiter) NumOfQueries() int {
-	return int(atomic.LoadUint32(&limiter.counters[0]))
+	if c := limiter.groups(); c != nil {
+		return int(c[0].queries.Load())
+	}
+	return 0
+}
+
+// ETA returns the estimated remaining time.
+// It's based on the achieved QPS when the limit is set and the deadline when the duration is set.
+func (limiter *Limiter) ETA() time.Duration {
+	if limiter.groups() == nil || limiter.IsDone() {
+		return 0
+	}
+	var eta time.Duration
+	if limiter.limit > 0 {
+		since := limiter.Since()
+		n := limiter.NumOfQueries()
+		if n > 0 && since > 0 {
+			remaining := int(limiter.limit) - n
+			if remaining < 0 {
+				remaining = 0
+			}
+			eta = time.Duration(float64(since) / float64(n) * float64(remaining))
+		}
+	}
+	if limiter.duration > 0 {
+		limiter.mu.RLock()
+		d := time.Until(limiter.deadline)
+		limiter.mu.RUnlock()
+		if d < 0 {
+			d = 0
+		}
+		if eta == 0 || d < eta {
+			eta = d
+		}
+	}
+	return eta
+}
+
+// Progress returns the percentage complete (0-100).
+// When both the limit and the duration are set, the one closer to completion is returned.
+func (limiter *Limiter) Progress() float64 {
+	if limiter.groups() == nil {
+		return 0
+	} else if limiter.IsDone() {
+		return 100
+	}
+	var p float64
+	if limiter.limit > 0 {
+		p = float64(limiter.NumOfQueries()) / float64(limiter.limit) * 100
+	}
+	if limiter.duration > 0 {
+		// Since takes the lock itself; taking it here too could deadlock behind a queued writer
+		d := float64(limiter.Since()) / float64(limiter.duration) * 100
+		if d > p {
+			p = d
+		}
+	}
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
+
+// Remaining returns the number of queries left before the query limit is reached.
+// It returns -1 when the limit isn't set.
+func (limiter *Limiter) Remaining() int {
+	if limiter.limit == 0 {
+		return -1
+	} else if limiter.groups() == nil {
+		return int(limiter.limit)
+	}
+	return max(int(limiter.limit)-limiter.NumOfQueries(), 0)
+}
+
+// TokensAvailable returns the current level of the token bucket.
+// It's negative while the workers wait for reserved tokens and +Inf when the rate isn't limited.
+// The tokens granted to the workers in batches aren't included.
+func (limiter *Limiter) TokensAvailable() float64 {
+	lim := runState(limiter, &limiter.lim)
+	if lim == nil {
+		return 0
+	} else if lim.Limit() == rate.Inf {
+		return math.Inf(1)
+	}
+	return lim.Tokens()
 }
 
 // NumOfQueriesByGroupID returns the number of queries by the given group id
 func (limiter *Limiter) NumOfQueriesByGroupID(id int) int {
-	if id > 0 && id < len(limiter.counters) {
-		return int(atomic.LoadUint32(&limiter.counters[id]))
+	if c := limiter.groups(); id > 0 && id < len(c) {
+		return int(c[id].queries.Load())
 	}
 	return 0
 }
 
-// LastError returns the last error
+// NumOfSuccesses returns the number of queries whose callbacks succeeded
+func (limiter *Limiter) NumOfSuccesses() int {
+	return limiter.NumOfSuccessesByGroupID(0)
+}
+
+// NumOfFailures returns the number of queries whose callbacks failed (including the throttled ones)
+func (limiter *Limiter) NumOfFailures() int {
+	return limiter.NumOfFailuresByGroupID(0)
+}
+
+// NumOfSuccessesByGroupID returns the number of succeeded queries by the given group id (0 for the total)
+func (limiter *Limiter) NumOfSuccessesByGroupID(id int) int {
+	return int(limiter.counter(id, func(c *groupCounters) int64 { return int64(c.successes.Load()) }))
+}
+
+// NumOfFailuresByGroupID returns the number of failed queries by the given group id (0 for the total)
+func (limiter *Limiter) NumOfFailuresByGroupID(id int) int {
+	return int(limiter.counter(id, func(c *groupCounters) int64 { return int64(c.failures.Load()) }))
+}
+
+// IsDone returns whether the limiter is done
+func (limiter *Limiter) IsDone() bool {
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
+	return limiter.done
+}
+
+// LastError returns the first error of the run (callback, rate or sink error).
+// It's the same error returned by Run.
 func (limiter *Limiter) LastError() error {
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
 	return limiter.lastError
 }
 
 // IsDeadline returns whether the limiter reached deadline
 func (limiter *Limiter) IsDeadline() bool {
-	return limiter.isDeadline
+	return limiter.isDeadline.Load()
 }
 
 // IsCanceled returns whether the limiter is interupted
 func (limiter *Limiter) IsCanceled() bool {
-	return limiter.isCanceled
+	return limiter.isCanceled.Load()
 }
 
 // IsQueryLimit returns whether the limiter reached query limit
 func (limiter *Limiter) IsQueryLimit() bool {
-	return limiter.isQueryLimit
+	return limiter.isQueryLimit.Load()
 }
 
 // IsRateError returns whether the limiter had a rate error
 func (limiter *Limiter) IsRateError() bool {
-	return limiter.isRateError
+	return limiter.isRateError.Load()
 }
 
 // IsCallbackError returns whether the limiter had a rate error
 func (limiter *Limiter) IsCallbackError() bool {
-	return limiter.isCallbackError
+	return limiter.isCallbackError.Load()
+}
+
+// newRunID returns a new random run id
+func newRunID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate run id: %s", err)
+	}
+	return hex.EncodeToString(b), nil
 }
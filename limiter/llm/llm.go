@@ -0,0 +1,154 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package llm provides a rate limiter for LLM APIs which enforce both
+// requests per minute and tokens per minute
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Options represents the options that can be set when creating a new limiter
+type Options struct {
+	// RPM is the limit for the number of requests per minute
+	RPM int
+	// TPM is the limit for the number of tokens per minute
+	TPM int
+}
+
+// New creates a new limiter by the given options
+func New(o Options) (*Limiter, error) {
+	if o.RPM <= 0 || o.TPM <= 0 {
+		return nil, errors.New("rpm and tpm values must be greater than zero")
+	}
+	return &Limiter{
+		requests: rate.NewLimiter(rate.Limit(float64(o.RPM)/60), o.RPM),
+		tokens:   rate.NewLimiter(rate.Limit(float64(o.TPM)/60), o.TPM),
+	}, nil
+}
+
+// Limiter represents a limiter
+type Limiter struct {
+	requests *rate.Limiter
+	tokens   *rate.Limiter
+	mu       sync.Mutex
+	credit   int
+}
+
+// Reservation represents a reservation of a request and its estimated tokens
+type Reservation struct {
+	limiter   *Limiter
+	estimated int
+	done      bool
+}
+
+// Reserve waits until a request and the given estimated number of tokens are available and reserves them.
+// The actual number of tokens should be reported by calling Reconcile once the response is received.
+func (limiter *Limiter) Reserve(ctx context.Context, estimated int) (*Reservation, error) {
+	if estimated < 0 {
+		return nil, errors.New("estimated tokens must be positive")
+	} else if estimated > limiter.tokens.Burst() {
+		return nil, fmt.Errorf("estimated tokens %d exceed tpm %d", estimated, limiter.tokens.Burst())
+	}
+
+	// Use the tokens returned by the previous reconciliations first
+	n := limiter.useCredit(estimated)
+
+	// Reserve both at once so neither budget is consumed if the wait fails
+	now := time.Now()
+	rr := limiter.requests.ReserveN(now, 1)
+	tr := limiter.tokens.ReserveN(now, n)
+	if !rr.OK() || !tr.OK() {
+		rr.CancelAt(now)
+		tr.CancelAt(now)
+		limiter.addCredit(estimated - n)
+		return nil, errors.New("failed to reserve")
+	}
+	delay := rr.DelayFrom(now)
+	if d := tr.DelayFrom(now); d > delay {
+		delay = d
+	}
+	if delay > 0 {
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(delay)) {
+			rr.CancelAt(now)
+			tr.CancelAt(now)
+			limiter.addCredit(estimated - n)
+			return nil, errors.New("reserve would exceed context deadline")
+		}
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			rr.Cancel()
+			tr.Cancel()
+			limiter.addCredit(estimated - n)
+			return nil, ctx.Err()
+		}
+	}
+
+	return &Reservation{limiter: limiter, estimated: estimated}, nil
+}
+
+// Reconcile reconciles the reservation by the given actual number of tokens.
+// Extra tokens are charged to the tokens per minute budget and unused tokens are returned to it.
+func (r *Reservation) Reconcile(actual int) {
+	if r.done {
+		return
+	}
+	r.done = true
+
+	diff := actual - r.estimated
+	if diff < 0 {
+		r.limiter.addCredit(-diff)
+		return
+	}
+	// Charge in chunks of the burst size since a reservation can't exceed it
+	now := time.Now()
+	burst := r.limiter.tokens.Burst()
+	for diff > 0 {
+		c := diff
+		if c > burst {
+			c = burst
+		}
+		r.limiter.tokens.ReserveN(now, c)
+		diff -= c
+	}
+}
+
+// Cancel cancels the reservation (e.g. when the request is not sent) and returns the estimated tokens.
+// The request itself is not returned since the provider may have counted it.
+func (r *Reservation) Cancel() {
+	r.Reconcile(0)
+}
+
+// useCredit uses the available credit for the given number of tokens and returns the remaining tokens
+func (limiter *Limiter) useCredit(n int) int {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	c := limiter.credit
+	if c > n {
+		c = n
+	}
+	limiter.credit -= c
+	return n - c
+}
+
+// addCredit adds the given number of tokens to the credit (bounded by the tokens per minute)
+func (limiter *Limiter) addCredit(n int) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.credit += n
+	if b := limiter.tokens.Burst(); limiter.credit > b {
+		limiter.credit = b
+	}
+}
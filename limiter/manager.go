@@ -0,0 +1,233 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// managerPollInterval is the interval of the checks for the stopped limiters on shutdown
+const managerPollInterval = 10 * time.Millisecond
+
+// DefaultManager is the process-wide manager
+var DefaultManager = NewManager()
+
+// Manager represents a registry of named limiters, so the packages of a process can share the
+// limiter instances, aggregate their metrics and shut them all down together
+type Manager struct {
+	mu      sync.RWMutex
+	entries map[string]*managerEntry
+}
+
+// managerEntry represents a registered limiter.
+// The limiter is created once by GetOrCreate and it's nil until then.
+type managerEntry struct {
+	mu      sync.Mutex
+	limiter *Limiter
+	options *Options
+}
+
+// ManagerReport represents the aggregated report of the limiters of a manager
+type ManagerReport struct {
+	// Limiters are the reports of the limiters by name
+	Limiters map[string]Report
+	// NumOfQueries is the total number of queries
+	NumOfQueries int
+	// NumOfSuccesses is the total number of succeeded queries
+	NumOfSuccesses int
+	// NumOfFailures is the total number of failed queries
+	NumOfFailures int
+	// CurrentQPS is the total current queries per second (see Limiter.CurrentQPS)
+	CurrentQPS float64
+}
+
+// NewManager creates a new manager
+func NewManager() *Manager {
+	return &Manager{entries: make(map[string]*managerEntry)}
+}
+
+// Register registers the given limiter by its name.
+// It fails if another limiter is registered by the same name.
+func (m *Manager) Register(limiter *Limiter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[limiter.Name()]; ok {
+		return fmt.Errorf("limiter %q is already registered", limiter.Name())
+	}
+	m.entries[limiter.Name()] = &managerEntry{limiter: limiter}
+	return nil
+}
+
+// GetOrCreate returns the limiter by the given name, creating it by the given options if it isn't
+// registered yet. Concurrent calls for the same name share a single instance, which is created
+// once. The options of the later calls are merged into the options of the instance: their unset
+// limits are ignored and the set ones must match, otherwise the instance is returned with an error.
+func (m *Manager) GetOrCreate(name string, o Options) (*Limiter, error) {
+	m.mu.Lock()
+	e, ok := m.entries[name]
+	if !ok {
+		e = &managerEntry{}
+		m.entries[name] = e
+	}
+	m.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.limiter != nil {
+		if e.options == nil {
+			return e.limiter, nil
+		}
+		return e.limiter, mergeOptions(*e.options, o)
+	}
+	o.Name = name
+	limiter, err := New(o)
+	if err != nil {
+		// The failed entry is removed so a later call can retry with valid options
+		m.mu.Lock()
+		if m.entries[name] == e {
+			delete(m.entries, name)
+		}
+		m.mu.Unlock()
+		return nil, err
+	}
+	e.limiter, e.options = limiter, &o
+	return limiter, nil
+}
+
+// Unregister unregisters the limiter by the given name
+func (m *Manager) Unregister(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, name)
+}
+
+// Get returns the limiter by the given name, or nil if it isn't registered
+func (m *Manager) Get(name string) *Limiter {
+	m.mu.RLock()
+	e, ok := m.entries[name]
+	m.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.limiter
+}
+
+// Names returns the sorted names of the registered limiters
+func (m *Manager) Names() []string {
+	limiters := m.list()
+	names := make([]string, 0, len(limiters))
+	for _, limiter := range limiters {
+		names = append(names, limiter.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// list returns the registered limiters
+func (m *Manager) list() []*Limiter {
+	m.mu.RLock()
+	entries := make([]*managerEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	m.mu.RUnlock()
+	limiters := make([]*Limiter, 0, len(entries))
+	for _, e := range entries {
+		e.mu.Lock()
+		if e.limiter != nil {
+			limiters = append(limiters, e.limiter)
+		}
+		e.mu.Unlock()
+	}
+	return limiters
+}
+
+// Report returns the aggregated report of the registered limiters
+func (m *Manager) Report() ManagerReport {
+	mr := ManagerReport{Limiters: make(map[string]Report)}
+	for _, limiter := range m.list() {
+		r := limiter.Report()
+		mr.Limiters[limiter.Name()] = r
+		mr.NumOfQueries += r.NumOfQueries
+		mr.NumOfSuccesses += r.NumOfSuccesses
+		mr.NumOfFailures += r.NumOfFailures
+		mr.CurrentQPS += limiter.CurrentQPS()
+	}
+	return mr
+}
+
+// StopAll stops the running limiters by the given cause
+func (m *Manager) StopAll(cause error) {
+	for _, limiter := range m.list() {
+		if limiter.isRunning() {
+			limiter.StopWithCause(cause)
+		}
+	}
+}
+
+// Shutdown stops the running limiters and waits until they're done or the given context is done
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.StopAll(ErrStopped)
+	ticker := time.NewTicker(managerPollInterval)
+	defer ticker.Stop()
+	for {
+		running := 0
+		for _, limiter := range m.list() {
+			if limiter.isRunning() {
+				running++
+			}
+		}
+		if running == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d limiters are still running: %w", running, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// StopOnSignal stops the running limiters by the ErrSignal cause once the process receives any
+// of the given signals (default SIGINT and SIGTERM). The returned function stops the handling.
+func (m *Manager) StopOnSignal(signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, signals...)
+	go func() {
+		select {
+		case <-ch:
+			m.StopAll(ErrSignal)
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// isRunning returns whether the limiter is running
+func (limiter *Limiter) isRunning() bool {
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
+	return limiter.runID != "" && !limiter.done
+}
@@ -0,0 +1,200 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManagerReportDuringRun(t *testing.T) {
+	m := NewManager()
+	limiter, err := New(Options{Name: "test", Concurrency: 4, QPS: 1000, Duration: 200 * time.Millisecond, Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register(limiter); err != nil {
+		t.Fatal(err)
+	}
+	if r := m.Report(); r.Limiters["test"].Since != 0 || r.NumOfQueries != 0 {
+		t.Errorf("got since %s and %d queries before the run, want zero", r.Limiters["test"].Since, r.NumOfQueries)
+	}
+
+	// The reports are polled during the run so the race detector can catch the unguarded state
+	done := make(chan error)
+	go func() { done <- limiter.Run() }()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The deadline is set before the start time so the run may be a bit shorter
+			r := m.Report()
+			if r.NumOfQueries == 0 || r.Limiters["test"].Since < 150*time.Millisecond {
+				t.Errorf("got %d queries in %s, want a complete run", r.NumOfQueries, r.Limiters["test"].Since)
+			}
+			return
+		default:
+			m.Report()
+		}
+	}
+}
+
+func TestManagerRegister(t *testing.T) {
+	m := NewManager()
+	for _, name := range []string{"b", "a"} {
+		limiter, err := New(Options{Name: name, Concurrency: 1, Limit: 1, Callback: func(cbp CallbackParams) error { return nil }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Register(limiter); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dup, err := New(Options{Name: "a", Concurrency: 1, Limit: 1, Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register(dup); err == nil {
+		t.Error("got no error for a duplicate name, want an error")
+	}
+	if got := m.Get("a"); got == nil || got == dup {
+		t.Error("got the duplicate limiter, want the registered one")
+	}
+	if got, want := m.Names(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got names %v, want %v", got, want)
+	}
+	m.Unregister("a")
+	if got := m.Get("a"); got != nil {
+		t.Error("got an unregistered limiter, want nil")
+	}
+}
+
+func TestManagerGetOrCreate(t *testing.T) {
+	base := Options{Concurrency: 2, QPS: 100, Duration: time.Second, Callback: func(cbp CallbackParams) error { return nil }}
+	tests := []struct {
+		name   string
+		o      Options
+		fields []string
+	}{
+		{name: "unset", o: Options{}},
+		{name: "matching", o: Options{Concurrency: 2, QPS: 100}},
+		{name: "unset and matching", o: Options{QPS: 100, Callback: func(cbp CallbackParams) error { return nil }}},
+		{name: "conflicting", o: Options{Concurrency: 4, QPS: 100}, fields: []string{"Concurrency"}},
+		{name: "several conflicting", o: Options{QPS: 50, Duration: time.Minute, QueryTimeout: time.Second}, fields: []string{"QPS", "Duration", "QueryTimeout"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			shared, err := m.GetOrCreate("shared", base)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The instance is shared even when the options conflict
+			limiter, err := m.GetOrCreate("shared", tt.o)
+			if limiter != shared {
+				t.Error("got another instance, want the shared one")
+			}
+			var fields []string
+			for _, e := range unwrapJoined(err) {
+				var oe *OptionError
+				if !errors.As(e, &oe) {
+					t.Fatalf("got %T (%v), want *OptionError", e, e)
+				}
+				fields = append(fields, oe.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("got conflicts %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+// unwrapJoined returns the errors of the given joined error
+func unwrapJoined(err error) []error {
+	if err == nil {
+		return nil
+	} else if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+func TestManagerGetOrCreateOnce(t *testing.T) {
+	m := NewManager()
+
+	// A failed creation isn't registered so a later call can retry
+	if _, err := m.GetOrCreate("shared", Options{}); err == nil {
+		t.Fatal("got no error for invalid options, want an error")
+	}
+	if got := m.Get("shared"); got != nil {
+		t.Fatal("got a limiter for the failed creation, want nil")
+	}
+
+	var wg sync.WaitGroup
+	limiters := make([]*Limiter, 10)
+	for i := range limiters {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limiter, err := m.GetOrCreate("shared", Options{Concurrency: 1, Limit: 1, Callback: func(cbp CallbackParams) error { return nil }})
+			if err != nil {
+				t.Error(err)
+			}
+			limiters[i] = limiter
+		}(i)
+	}
+	wg.Wait()
+	for _, limiter := range limiters {
+		if limiter == nil || limiter != limiters[0] {
+			t.Fatal("got several instances, want a single shared one")
+		}
+	}
+	if got := limiters[0].Name(); got != "shared" {
+		t.Errorf("got name %q, want shared", got)
+	}
+}
+
+func TestManagerShutdown(t *testing.T) {
+	m := NewManager()
+	done := make(chan error, 2)
+	for _, name := range []string{"a", "b"} {
+		limiter, err := m.GetOrCreate(name, Options{Concurrency: 1, QPS: 100, Duration: time.Minute, Callback: func(cbp CallbackParams) error { return nil }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() { done <- limiter.Run() }()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The report aggregates the limiters
+	r := m.Report()
+	if len(r.Limiters) != 2 || r.NumOfQueries != r.Limiters["a"].NumOfQueries+r.Limiters["b"].NumOfQueries || r.NumOfQueries == 0 {
+		t.Errorf("got %d queries of %d limiters, want the sum of both", r.NumOfQueries, len(r.Limiters))
+	}
+	for _, name := range m.Names() {
+		if got := m.Get(name).Cause(); !errors.Is(got, ErrStopped) {
+			t.Errorf("got cause %v of %s, want %v", got, name, ErrStopped)
+		}
+	}
+}
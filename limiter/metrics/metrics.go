@@ -0,0 +1,173 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package metrics provides a Prometheus exporter for limiters without external dependencies.
+//
+// Metric names are stable and prefixed by "gorate_". Counters end with "_total" and the durations
+// are in seconds. Every metric has the "limiter" (the limiter name) and the "run_id" (the unique id
+// of the current or last run) labels, so the runs of the limiters with the same name don't collide;
+// the resource wait metric also has the "resource" label.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+// Metric names
+const (
+	// QueriesTotal is the number of queries (attempts)
+	QueriesTotal = "gorate_queries_total"
+	// SuccessesTotal is the number of queries whose callbacks succeeded
+	SuccessesTotal = "gorate_successes_total"
+	// FailuresTotal is the number of queries whose callbacks failed
+	FailuresTotal = "gorate_failures_total"
+	// ThrottlesTotal is the number of throttled queries in adaptive mode
+	ThrottlesTotal = "gorate_throttles_total"
+	// SkipsTotal is the number of slots skipped by the gate
+	SkipsTotal = "gorate_skips_total"
+	// BytesTotal is the number of bytes reported by the callbacks
+	BytesTotal = "gorate_bytes_total"
+	// CostTotal is the total cost reported by the callbacks
+	CostTotal = "gorate_cost_total"
+	// ResourceWaitSecondsTotal is the time spent waiting for a resource
+	ResourceWaitSecondsTotal = "gorate_resource_wait_seconds_total"
+	// BlackoutSecondsTotal is the time spent in blackout windows
+	BlackoutSecondsTotal = "gorate_blackout_seconds_total"
+	// RateLimit is the current internal rate limit (queries per second)
+	RateLimit = "gorate_rate_limit"
+	// Concurrency is the number of running workers
+	Concurrency = "gorate_concurrency"
+	// Progress is the completed ratio of the run (0-1)
+	Progress = "gorate_progress_ratio"
+	// Healthy is 1 if the target passed the health checks
+	Healthy = "gorate_healthy"
+)
+
+// Exporter represents a Prometheus exporter for limiters
+type Exporter struct {
+	mu       sync.Mutex
+	limiters []*limiter.Limiter
+}
+
+// NewExporter creates a new exporter for the given limiters
+func NewExporter(limiters ...*limiter.Limiter) *Exporter {
+	return &Exporter{limiters: limiters}
+}
+
+// Add adds the given limiter to the exporter
+func (e *Exporter) Add(l *limiter.Limiter) {
+	e.mu.Lock()
+	e.limiters = append(e.limiters, l)
+	e.mu.Unlock()
+}
+
+// metric represents a metric family
+type metric struct {
+	name    string
+	typ     string
+	help    string
+	samples []string
+}
+
+// WriteTo writes the metrics in the Prometheus text format to the given writer
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	e.mu.Lock()
+	limiters := append([]*limiter.Limiter(nil), e.limiters...)
+	e.mu.Unlock()
+
+	metrics := []*metric{
+		{name: QueriesTotal, typ: "counter", help: "Number of queries (attempts)."},
+		{name: SuccessesTotal, typ: "counter", help: "Number of queries whose callbacks succeeded."},
+		{name: FailuresTotal, typ: "counter", help: "Number of queries whose callbacks failed."},
+		{name: ThrottlesTotal, typ: "counter", help: "Number of throttled queries."},
+		{name: SkipsTotal, typ: "counter", help: "Number of slots skipped by the gate."},
+		{name: BytesTotal, typ: "counter", help: "Number of bytes reported by the callbacks."},
+		{name: CostTotal, typ: "counter", help: "Total cost reported by the callbacks."},
+		{name: ResourceWaitSecondsTotal, typ: "counter", help: "Time spent waiting for a resource."},
+		{name: BlackoutSecondsTotal, typ: "counter", help: "Time spent in blackout windows."},
+		{name: RateLimit, typ: "gauge", help: "Current internal rate limit."},
+		{name: Concurrency, typ: "gauge", help: "Number of running workers."},
+		{name: Progress, typ: "gauge", help: "Completed ratio of the run."},
+		{name: Healthy, typ: "gauge", help: "Whether the target passed the health checks."},
+	}
+	for _, l := range limiters {
+		r := l.Report()
+		labels := fmt.Sprintf(`limiter="%s",run_id="%s"`, escape(l.Name()), escape(l.RunID()))
+		values := []float64{
+			float64(r.NumOfQueries), float64(r.NumOfSuccesses), float64(r.NumOfFailures),
+			float64(l.NumOfThrottles()), float64(l.NumOfSkips()), float64(r.NumOfBytes), r.TotalCost,
+			0, r.BlackoutTime.Seconds(), l.RateLimit(), float64(l.Concurrency()), l.Progress() / 100, 0,
+		}
+		if l.IsHealthy() {
+			values[12] = 1
+		}
+		for i, m := range metrics {
+			if m.name == ResourceWaitSecondsTotal {
+				resources := make([]string, 0, len(r.ResourceWaits))
+				for name := range r.ResourceWaits {
+					resources = append(resources, name)
+				}
+				sort.Strings(resources)
+				for _, name := range resources {
+					m.samples = append(m.samples, fmt.Sprintf(`%s{%s,resource="%s"} %g`, m.name, labels, escape(name), r.ResourceWaits[name].Seconds()))
+				}
+				continue
+			}
+			m.samples = append(m.samples, fmt.Sprintf("%s{%s} %g", m.name, labels, values[i]))
+		}
+	}
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		if len(m.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, s := range m.samples {
+			fmt.Fprintln(cw, s)
+		}
+	}
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteTo(w)
+}
+
+// escape escapes the given label value
+func escape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// countWriter represents a writer which counts the written bytes and keeps the first error
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+// Write implements the io.Writer interface
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,45 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+func TestExporterRunIDLabel(t *testing.T) {
+	// Two runs of limiters with the same name
+	e := NewExporter()
+	var runIDs []string
+	for i := 0; i < 2; i++ {
+		l, err := limiter.New(limiter.Options{Concurrency: 1, Limit: 3, Callback: func(cbp limiter.CallbackParams) error { return nil }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Run(); err != nil {
+			t.Fatal(err)
+		}
+		e.Add(l)
+		runIDs = append(runIDs, l.RunID())
+	}
+	if runIDs[0] == "" || runIDs[0] == runIDs[1] {
+		t.Fatalf("got run ids %q, want unique ones", runIDs)
+	}
+
+	var b strings.Builder
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range runIDs {
+		want := fmt.Sprintf(`%s{limiter="gorate",run_id="%s"} 3`, QueriesTotal, id)
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("got metrics\n%s\nwant %s", b.String(), want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Callback represents the function that is invoked on every query
+type Callback func(cbp CallbackParams) error
+
+// Middleware represents a callback middleware which wraps the next callback
+type Middleware func(next Callback) Callback
+
+// Chain wraps the given callback by the given middlewares.
+// The first middleware is the outermost one.
+func Chain(cb Callback, middlewares ...Middleware) Callback {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		cb = middlewares[i](cb)
+	}
+	return cb
+}
+
+// Logging returns a middleware which logs the failed queries by the given logger (default log.Default())
+func Logging(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next Callback) Callback {
+		return func(cbp CallbackParams) error {
+			err := next(cbp)
+			if err != nil {
+				logger.Printf("%s: query %d (group %d) failed: %v", cbp.Limiter.Name(), cbp.Sequence, cbp.GroupID, err)
+			}
+			return err
+		}
+	}
+}
+
+// Timing returns a middleware which reports the duration and the error of every query to the given function
+func Timing(fn func(cbp CallbackParams, d time.Duration, err error)) Middleware {
+	return func(next Callback) Callback {
+		return func(cbp CallbackParams) error {
+			start := time.Now()
+			err := next(cbp)
+			fn(cbp, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Retry returns a middleware which retries the failed queries up to the given number of attempts.
+// The backoff is doubled after every attempt. Retries stop once the query context is done.
+func Retry(attempts int, backoff time.Duration) Middleware {
+	return func(next Callback) Callback {
+		return func(cbp CallbackParams) error {
+			var err error
+			wait := backoff
+			for attempt := 1; ; attempt++ {
+				if err = next(cbp); err == nil || attempt >= attempts {
+					return err
+				}
+				t := time.NewTimer(wait)
+				select {
+				case <-cbp.Context.Done():
+					t.Stop()
+					return err
+				case <-t.C:
+				}
+				wait *= 2
+			}
+		}
+	}
+}
+
+// Recover returns a middleware which turns the panics of the queries into errors
+func Recover() Middleware {
+	return func(next Callback) Callback {
+		return func(cbp CallbackParams) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("callback panic: %v", r)
+				}
+			}()
+			return next(cbp)
+		}
+	}
+}
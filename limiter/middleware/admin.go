@@ -0,0 +1,61 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+// AdminHandler returns a handler for the admin API of the given keyed limiter.
+// It should be mounted with http.StripPrefix and protected by the caller.
+//
+//	GET    /bans        lists the banned keys
+//	GET    /bans/{key}  returns the ban state of the key
+//	DELETE /bans/{key}  lifts the ban of the key
+func AdminHandler(kl *limiter.KeyedLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(r.URL.Path, "/")
+		if path != "bans" && !strings.HasPrefix(path, "bans/") {
+			http.NotFound(w, r)
+			return
+		}
+		key := strings.TrimPrefix(strings.TrimPrefix(path, "bans"), "/")
+
+		switch {
+		case r.Method == http.MethodGet && key == "":
+			bans := kl.Bans()
+			if bans == nil {
+				bans = []limiter.BanState{}
+			}
+			writeJSON(w, http.StatusOK, bans)
+		case r.Method == http.MethodGet:
+			bs, banned := kl.Ban(key)
+			writeJSON(w, http.StatusOK, struct {
+				limiter.BanState
+				Banned bool
+			}{bs, banned})
+		case r.Method == http.MethodDelete && key != "":
+			if !kl.Unban(key) {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// writeJSON writes the given value as JSON
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
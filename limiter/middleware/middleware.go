@@ -0,0 +1,66 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package middleware provides a keyed rate limiting HTTP middleware
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+// Options represents the options that can be set when creating a new middleware
+type Options struct {
+	// Limiter is the keyed limiter
+	Limiter *limiter.KeyedLimiter
+	// KeyFunc returns the limiter key of the given request (default RemoteIP)
+	KeyFunc func(r *http.Request) string
+}
+
+// New creates a new middleware by the given options
+func New(o Options) (*Middleware, error) {
+	if o.Limiter == nil {
+		return nil, errors.New("limiter is required")
+	}
+	if o.KeyFunc == nil {
+		o.KeyFunc = RemoteIP
+	}
+	return &Middleware{limiter: o.Limiter, keyFunc: o.KeyFunc}, nil
+}
+
+// Middleware represents a keyed rate limiting middleware
+type Middleware struct {
+	limiter *limiter.KeyedLimiter
+	keyFunc func(r *http.Request) string
+}
+
+// Handler returns a handler that rejects the requests over the limit with 429 Too Many Requests
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := m.limiter.Check(m.keyFunc(r))
+		if !ok {
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			}
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RemoteIP returns the IP address of the given request's remote address
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,230 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package notify posts the summary reports of the runs to the Slack and Microsoft Teams incoming
+// webhooks, e.g. for the scheduled soak tests.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+// defaultTimeout is the default timeout of the requests
+const defaultTimeout = 10 * time.Second
+
+// maxErrorClasses is the max number of the error classes in a message
+const maxErrorClasses = 5
+
+// Colors of the messages by the outcome of the run
+const (
+	colorSuccess = "2EB67D"
+	colorWarning = "ECB22E"
+	colorFailure = "E01E5A"
+)
+
+// Format represents the message format of a webhook
+type Format int
+
+const (
+	// FormatSlack is the format of the Slack incoming webhooks (attachments with fields)
+	FormatSlack Format = iota
+	// FormatTeams is the format of the Microsoft Teams incoming webhooks (message cards with facts)
+	FormatTeams
+)
+
+// Notifier represents a notifier which posts the reports to a webhook
+type Notifier struct {
+	// URL is the webhook URL
+	URL string
+	// Format is the message format
+	Format Format
+	// Title is the title of the messages (default "gorate report: <limiter name>")
+	Title string
+	// Client is the HTTP client (default a client with a 10s timeout)
+	Client *http.Client
+}
+
+// Slack creates a new notifier by the given Slack webhook URL
+func Slack(url string) *Notifier {
+	return &Notifier{URL: url, Format: FormatSlack}
+}
+
+// Teams creates a new notifier by the given Microsoft Teams webhook URL
+func Teams(url string) *Notifier {
+	return &Notifier{URL: url, Format: FormatTeams}
+}
+
+// field represents a field of a message
+type field struct {
+	name  string
+	value string
+	// short is whether the field can be displayed side by side with the others
+	short bool
+}
+
+// fields returns the fields of the given report
+func fields(r limiter.Report) []field {
+	fs := []field{
+		{"Queries", strconv.Itoa(r.NumOfQueries), true},
+		{"Successes", strconv.Itoa(r.NumOfSuccesses), true},
+		{"Failures", strconv.Itoa(r.NumOfFailures), true},
+		{"Timeouts", strconv.Itoa(r.NumOfTimeouts), true},
+		{"Duration", r.Since.Round(time.Millisecond).String(), true},
+		{"QPS", fmt.Sprintf("%.2f", r.QPS), true},
+	}
+	if r.NumOfQueries > 0 {
+		fs = append(fs, field{"Success rate", fmt.Sprintf("%.2f%%", float64(r.NumOfSuccesses)/float64(r.NumOfQueries)*100), true})
+	}
+	if r.Latency.Service.Count > 0 {
+		s := r.Latency.Service
+		fs = append(fs, field{"Latency (p50/p90/p99)", fmt.Sprintf("%s / %s / %s", s.P50, s.P90, s.P99), true})
+	}
+	if r.Bottleneck != "" {
+		fs = append(fs, field{"Bottleneck", r.Bottleneck, true})
+	}
+	if bound := r.TimeAccounting.Bound(); bound != "" {
+		fs = append(fs, field{"Bound by", bound, true})
+	}
+
+	// Failures
+	// The error classes are sorted by count so the most common ones are listed
+	if len(r.Breakdown.ErrorClasses) > 0 {
+		classes := make([]string, 0, len(r.Breakdown.ErrorClasses))
+		for class := range r.Breakdown.ErrorClasses {
+			classes = append(classes, class)
+		}
+		sort.Slice(classes, func(i, j int) bool {
+			ci, cj := r.Breakdown.ErrorClasses[classes[i]].Count, r.Breakdown.ErrorClasses[classes[j]].Count
+			return ci > cj || (ci == cj && classes[i] < classes[j])
+		})
+		var b bytes.Buffer
+		for i, class := range classes {
+			if i == maxErrorClasses {
+				fmt.Fprintf(&b, "and %d more", len(classes)-i)
+				break
+			}
+			fmt.Fprintf(&b, "%s: %d\n", class, r.Breakdown.ErrorClasses[class].Count)
+		}
+		fs = append(fs, field{"Error classes", string(bytes.TrimSpace(b.Bytes())), false})
+	}
+	if r.LastError != nil {
+		fs = append(fs, field{"Error", r.LastError.Error(), false})
+	}
+	return fs
+}
+
+// color returns the color of the given report
+func color(r limiter.Report) string {
+	switch {
+	case r.LastError != nil:
+		return colorFailure
+	case r.NumOfFailures > 0:
+		return colorWarning
+	}
+	return colorSuccess
+}
+
+// message returns the message of the given report by the format
+func (n *Notifier) message(r limiter.Report) (interface{}, error) {
+	title := n.Title
+	if title == "" {
+		title = "gorate report: " + r.Name
+	}
+	footer := "run " + r.RunID
+	switch n.Format {
+	case FormatSlack:
+		type slackField struct {
+			Title string `json:"title"`
+			Value string `json:"value"`
+			Short bool   `json:"short"`
+		}
+		type attachment struct {
+			Fallback string       `json:"fallback"`
+			Color    string       `json:"color"`
+			Title    string       `json:"title"`
+			Fields   []slackField `json:"fields"`
+			Footer   string       `json:"footer"`
+		}
+		a := attachment{Fallback: title, Color: "#" + color(r), Title: title, Footer: footer}
+		for _, f := range fields(r) {
+			a.Fields = append(a.Fields, slackField{Title: f.name, Value: f.value, Short: f.short})
+		}
+		return struct {
+			Text        string       `json:"text"`
+			Attachments []attachment `json:"attachments"`
+		}{Text: title, Attachments: []attachment{a}}, nil
+	case FormatTeams:
+		type fact struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		}
+		type section struct {
+			Facts []fact `json:"facts"`
+			Text  string `json:"text,omitempty"`
+		}
+		s := section{Text: footer}
+		for _, f := range fields(r) {
+			s.Facts = append(s.Facts, fact{Name: f.name, Value: f.value})
+		}
+		return struct {
+			Type       string    `json:"@type"`
+			Context    string    `json:"@context"`
+			ThemeColor string    `json:"themeColor"`
+			Summary    string    `json:"summary"`
+			Title      string    `json:"title"`
+			Sections   []section `json:"sections"`
+		}{"MessageCard", "https://schema.org/extensions", color(r), title, title, []section{s}}, nil
+	}
+	return nil, fmt.Errorf("unknown format %d", n.Format)
+}
+
+// PostReport posts the given report
+func (n *Notifier) PostReport(ctx context.Context, r limiter.Report) error {
+	msg, err := n.message(r)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("notification failed with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Run runs the given limiter and posts its report once it's done.
+// It returns the error of the run, or the error of the notification if the run succeeded.
+func (n *Notifier) Run(ctx context.Context, l *limiter.Limiter) error {
+	err := l.Run()
+	if nerr := n.PostReport(ctx, l.Report()); err == nil {
+		err = nerr
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"time"
+)
+
+// overdraw reports whether the queries may go ahead of the given delay of the QPS budget by
+// putting it into debt. The budget can't be overdrawn again until the debt which exceeded the
+// overdraft is paid back, so the queries run below the rate in the meantime.
+func (limiter *Limiter) overdraw(now time.Time, delay time.Duration) bool {
+	if limiter.overdraft == 0 || delay <= 0 {
+		return false
+	}
+	debt := delay.Seconds() * float64(limiter.lim.Limit())
+	if debt > float64(limiter.overdraft) {
+		// The reservation is due once the debt is paid back
+		for {
+			repaid := limiter.repaidAt.Load()
+			if due := now.Add(delay).UnixNano(); due <= repaid || limiter.repaidAt.CompareAndSwap(repaid, due) {
+				break
+			}
+		}
+		return false
+	} else if now.UnixNano() < limiter.repaidAt.Load() {
+		return false
+	}
+	limiter.overdrafts.Add(1)
+	return true
+}
+
+// NumOfOverdrafts returns the number of the token waits skipped by overdrawing the QPS budget
+func (limiter *Limiter) NumOfOverdrafts() int {
+	return int(limiter.overdrafts.Load())
+}
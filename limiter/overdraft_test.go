@@ -0,0 +1,101 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestOverdraw(t *testing.T) {
+	now := time.Now()
+	limiter := &Limiter{lim: rate.NewLimiter(10, 1), overdraft: 5}
+	steps := []struct {
+		name  string
+		at    time.Duration
+		delay time.Duration
+		want  bool
+	}{
+		{name: "no wait", delay: 0, want: false},
+		{name: "within overdraft", delay: 300 * time.Millisecond, want: true},
+		{name: "at overdraft", delay: 500 * time.Millisecond, want: true},
+		{name: "beyond overdraft", delay: time.Second, want: false},
+		{name: "before repaid", at: 500 * time.Millisecond, delay: 100 * time.Millisecond, want: false},
+		{name: "after repaid", at: 1100 * time.Millisecond, delay: 100 * time.Millisecond, want: true},
+	}
+	for _, s := range steps {
+		if got := limiter.overdraw(now.Add(s.at), s.delay); got != s.want {
+			t.Errorf("%s: got %v, want %v", s.name, got, s.want)
+		}
+	}
+	if got := limiter.NumOfOverdrafts(); got != 3 {
+		t.Errorf("got %d overdrafts, want 3", got)
+	}
+
+	// Without an overdraft the budget is never overdrawn
+	limiter = &Limiter{lim: rate.NewLimiter(10, 1)}
+	if limiter.overdraw(now, 100*time.Millisecond) {
+		t.Error("got an overdraft, want none")
+	}
+}
+
+func TestOverdraftSpike(t *testing.T) {
+	tests := []struct {
+		name      string
+		overdraft uint32
+		min, max  time.Duration
+	}{
+		{name: "without overdraft", min: 400 * time.Millisecond, max: 700 * time.Millisecond},
+		{name: "with overdraft", overdraft: 10, max: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries atomic.Int64
+			var spike atomic.Int64
+			var start time.Time
+			limiter, err := New(Options{
+				Concurrency: 1,
+				QPS:         20,
+				Limit:       20,
+				Overdraft:   tt.overdraft,
+				Callback: func(cbp CallbackParams) error {
+					if queries.Add(1) == 11 {
+						spike.Store(int64(time.Since(start)))
+					}
+					return nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			start = time.Now()
+			if err := limiter.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The first queries go ahead of the rate, and the next one waits until the debt is paid back
+			if d := time.Duration(spike.Load()); d < tt.min || d > tt.max {
+				t.Errorf("got 11 queries in %s, want between %s and %s", d, tt.min, tt.max)
+			}
+			if got := limiter.Since(); got < 450*time.Millisecond {
+				t.Errorf("got run of %s, want the debt of 10 tokens to be paid back at 20 qps", got)
+			}
+			if got := limiter.Report().Overdrafts; got < int(tt.overdraft) {
+				t.Errorf("got %d overdrafts, want at least %d", got, tt.overdraft)
+			}
+		})
+	}
+
+	// The overdraft applies to the QPS budget
+	_, err := New(Options{Concurrency: 1, Limit: 1, Overdraft: 10, Callback: func(cbp CallbackParams) error { return nil }})
+	if err == nil || !strings.Contains(err.Error(), "overdraft requires qps value") {
+		t.Errorf("got %v, want overdraft requires qps value", err)
+	}
+}
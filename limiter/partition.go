@@ -0,0 +1,123 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// PartitionOptions represents the options that can be set when creating a new partitioned limiter
+type PartitionOptions struct {
+	// Ring is the hash ring of the nodes that share the store
+	Ring *HashRing
+	// Node is the name of this node in the ring
+	Node string
+	// Remote is the store limiter that holds the global budgets
+	Remote *StoreLimiter
+	// LeaseTokens is the number of tokens of an owned key leased from the store at once (default 10).
+	// It must not exceed the burst size of the store limiter.
+	LeaseTokens int
+	// LeaseDuration is the max age of the leased tokens (default 100ms).
+	// It bounds the time a node keeps serving a key from its cache after losing its ownership.
+	LeaseDuration time.Duration
+}
+
+// NewPartitioned creates a new partitioned limiter by the given options
+func NewPartitioned(o PartitionOptions) (*PartitionedLimiter, error) {
+	if o.Ring == nil {
+		return nil, errors.New("ring is required")
+	} else if o.Node == "" {
+		return nil, errors.New("node is required")
+	}
+	owned, err := NewTwoTier(TwoTierOptions{Remote: o.Remote, SyncTokens: o.LeaseTokens, SyncInterval: o.LeaseDuration})
+	if err != nil {
+		return nil, err
+	}
+	return &PartitionedLimiter{ring: o.Ring, node: o.Node, remote: o.Remote, owned: owned}, nil
+}
+
+// PartitionedLimiter represents a distributed keyed limiter whose keys are partitioned across the
+// nodes by a consistent hash ring, so the state of every key is owned by a single node.
+// The owner caches the budget of its keys locally by leasing tokens from the store (see
+// TwoTierLimiter), so routing every key to its owner (see Owner) takes the store round-trips down
+// to one per lease. The keys owned by the other nodes are checked against the store directly,
+// which keeps the global budgets while the ring changes.
+type PartitionedLimiter struct {
+	ring     *HashRing
+	node     string
+	remote   *StoreLimiter
+	owned    *TwoTierLimiter
+	allowed  atomic.Int64
+	rejected atomic.Int64
+	local    atomic.Int64
+}
+
+// Owner returns the node that owns the given key
+func (pl *PartitionedLimiter) Owner(key string) string {
+	return pl.ring.Owner(key)
+}
+
+// Owns reports whether the node owns the given key
+func (pl *PartitionedLimiter) Owns(key string) bool {
+	return pl.ring.Owner(key) == pl.node
+}
+
+// Check reports whether a query for the given key may happen now.
+// If not, it returns the duration until the next token is available.
+func (pl *PartitionedLimiter) Check(ctx context.Context, key string) (bool, time.Duration) {
+	ok, retryAfter := pl.check(ctx, key)
+	if ok {
+		pl.allowed.Add(1)
+	} else {
+		pl.rejected.Add(1)
+	}
+	return ok, retryAfter
+}
+
+// check makes the decision for Check
+func (pl *PartitionedLimiter) check(ctx context.Context, key string) (bool, time.Duration) {
+	if pl.Owns(key) {
+		pl.local.Add(1)
+		return pl.owned.check(ctx, key)
+	}
+	return pl.remote.CheckN(ctx, key, 1)
+}
+
+// Allow reports whether a query for the given key may happen now
+func (pl *PartitionedLimiter) Allow(key string) bool {
+	ok, _ := pl.Check(context.Background(), key)
+	return ok
+}
+
+// Wait blocks until a query for the given key may happen
+func (pl *PartitionedLimiter) Wait(ctx context.Context, key string) error {
+	return pl.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until n queries for the given key may happen.
+// The tokens are taken one by one so a canceled wait may consume some of them.
+func (pl *PartitionedLimiter) WaitN(ctx context.Context, key string, n int) error {
+	return waitTokens(ctx, n, func() (bool, time.Duration) { return pl.check(ctx, key) }, &pl.allowed, &pl.rejected)
+}
+
+// NumOfLocalChecks returns the number of the checks of the owned keys, which are served from
+// the leases unless a lease has to be renewed
+func (pl *PartitionedLimiter) NumOfLocalChecks() int {
+	return int(pl.local.Load())
+}
+
+// Stats returns the statistics
+func (pl *PartitionedLimiter) Stats() Stats {
+	return Stats{Allowed: int(pl.allowed.Load()), Rejected: int(pl.rejected.Load())}
+}
+
+// Close stops the background work (idle lease sweeper) of the limiter
+func (pl *PartitionedLimiter) Close() {
+	pl.owned.Close()
+}
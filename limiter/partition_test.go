@@ -0,0 +1,154 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts the calls of a store
+type countingStore struct {
+	Store
+	takes atomic.Int64
+}
+
+// Take implements Store
+func (cs *countingStore) Take(ctx context.Context, key string, n int, qps float64, burst int) (bool, time.Duration, error) {
+	cs.takes.Add(1)
+	return cs.Store.Take(ctx, key, n, qps, burst)
+}
+
+func TestFNV1a(t *testing.T) {
+	for _, s := range []string{"", "a", "key-1", "user:42"} {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		if got, want := fnv1a(s), h.Sum64(); got != want {
+			t.Errorf("fnv1a(%q) = %x, want %x", s, got, want)
+		}
+	}
+}
+
+func TestPartitionedOwnership(t *testing.T) {
+	ring := NewHashRing(0, "a", "b", "c")
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		counts[ring.Owner(fmt.Sprintf("key-%d", i))]++
+	}
+	for _, node := range ring.Nodes() {
+		if counts[node] < 500 {
+			t.Errorf("node %s owns %d of 3000 keys", node, counts[node])
+		}
+	}
+
+	// Every key is owned by a single node
+	remote, err := NewStoreLimiter(StoreOptions{Store: NewMemoryStore(), QPS: 1, Burst: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var owners int
+	for _, node := range ring.Nodes() {
+		pl, err := NewPartitioned(PartitionOptions{Ring: ring, Node: node, Remote: remote})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(pl.Close)
+		if pl.Owns("key-1") {
+			owners++
+		}
+	}
+	if owners != 1 {
+		t.Errorf("got %d owners, want 1", owners)
+	}
+}
+
+func TestPartitionedStoreCalls(t *testing.T) {
+	store := &countingStore{Store: NewMemoryStore()}
+	remote, err := NewStoreLimiter(StoreOptions{Store: store, QPS: 1, Burst: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring := NewHashRing(0, "a", "b")
+	pl, err := NewPartitioned(PartitionOptions{Ring: ring, Node: "a", Remote: remote, LeaseTokens: 10, LeaseDuration: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pl.Close)
+	var owned, other string
+	for i := 0; owned == "" || other == ""; i++ {
+		if key := fmt.Sprintf("key-%d", i); pl.Owns(key) {
+			owned = key
+		} else {
+			other = key
+		}
+	}
+
+	// The owned keys are served from the leases
+	for i := 0; i < 50; i++ {
+		if !pl.Allow(owned) {
+			t.Fatalf("query %d of the owned key was rejected", i)
+		}
+	}
+	if got := store.takes.Load(); got != 5 {
+		t.Errorf("got %d store calls for the owned key, want 5", got)
+	}
+	if got := pl.NumOfLocalChecks(); got != 50 {
+		t.Errorf("got %d local checks, want 50", got)
+	}
+
+	// The other keys are checked against the store
+	store.takes.Store(0)
+	for i := 0; i < 50; i++ {
+		if !pl.Allow(other) {
+			t.Fatalf("query %d of the other key was rejected", i)
+		}
+	}
+	if got := store.takes.Load(); got != 50 {
+		t.Errorf("got %d store calls for the other key, want 50", got)
+	}
+	if got, want := pl.Stats(), (Stats{Allowed: 100}); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestPartitionedWaitRejections(t *testing.T) {
+	remote, err := NewStoreLimiter(StoreOptions{Store: NewMemoryStore(), QPS: 20, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, err := NewPartitioned(PartitionOptions{Ring: NewHashRing(0, "a", "b"), Node: "a", Remote: remote, LeaseTokens: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pl.Close)
+	if err := pl.WaitN(context.Background(), "key", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := pl.Stats(); got.Allowed != 3 || got.Rejected != 1 {
+		t.Errorf("got stats %+v, want 3 allowed and 1 rejected", got)
+	}
+}
+
+func TestNewPartitionedErrors(t *testing.T) {
+	remote, err := NewStoreLimiter(StoreOptions{Store: NewMemoryStore(), QPS: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring := NewHashRing(0, "a")
+	for _, o := range []PartitionOptions{
+		{Node: "a", Remote: remote},
+		{Ring: ring, Remote: remote},
+		{Ring: ring, Node: "a"},
+	} {
+		if _, err := NewPartitioned(o); err == nil {
+			t.Errorf("NewPartitioned(%+v) succeeded", o)
+		}
+	}
+}
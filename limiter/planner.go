@@ -0,0 +1,81 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// batchQPSThreshold is the QPS per batch token recommended by the planner
+const batchQPSThreshold = 10000
+
+// CapacityOptions represents the workload description for the capacity planner
+type CapacityOptions struct {
+	// QPS is the target number of succeeded queries per second
+	QPS float64
+	// Latency is the average callback latency
+	Latency time.Duration
+	// P99Latency is the 99th percentile callback latency (default Latency)
+	P99Latency time.Duration
+	// ErrorRate is the expected ratio of the failed queries (0-1).
+	// Failed queries use the budget too, so more attempts are needed for the target QPS.
+	ErrorRate float64
+	// MaxConcurrency is the max concurrency level (default 1024)
+	MaxConcurrency uint32
+}
+
+// Capacity represents the options recommended by the capacity planner
+type Capacity struct {
+	// QPS is the number of attempts per second needed for the target QPS
+	QPS float64
+	// Concurrency is the concurrency level which sustains the QPS at the tail latency
+	Concurrency uint32
+	// BatchSize is the number of tokens granted at once
+	BatchSize uint32
+	// MaxInFlight is the limit for the number of queries in flight which keeps a latency
+	// spike from piling up work on the target
+	MaxInFlight uint32
+}
+
+// PlanCapacity recommends the limiter options for the given workload.
+// It uses the same model as the auto-tuner (Little's law with headroom, see EstimateConcurrency).
+func PlanCapacity(o CapacityOptions) (Capacity, error) {
+	if o.QPS <= 0 {
+		return Capacity{}, errors.New("qps value must be greater than zero")
+	} else if o.Latency < 0 || o.P99Latency < 0 {
+		return Capacity{}, errors.New("latency values must be positive")
+	} else if o.ErrorRate < 0 || o.ErrorRate >= 1 {
+		return Capacity{}, errors.New("error rate value must be between 0 and 1")
+	}
+	if o.P99Latency < o.Latency {
+		o.P99Latency = o.Latency
+	}
+	if o.MaxConcurrency == 0 {
+		o.MaxConcurrency = defaultMaxConcurrency
+	}
+
+	c := Capacity{QPS: o.QPS / (1 - o.ErrorRate)}
+	c.Concurrency = EstimateConcurrency(c.QPS, o.P99Latency, o.MaxConcurrency)
+	c.MaxInFlight = 2 * EstimateConcurrency(c.QPS, o.Latency, o.MaxConcurrency)
+	if c.MaxInFlight > c.Concurrency {
+		c.MaxInFlight = c.Concurrency
+	}
+	c.BatchSize = uint32(math.Ceil(c.QPS / batchQPSThreshold))
+	return c, nil
+}
+
+// Options returns the limiter options by the recommendation and the given duration
+func (c Capacity) Options(d time.Duration) Options {
+	return Options{
+		Concurrency: c.Concurrency,
+		QPS:         uint32(math.Ceil(c.QPS)),
+		BatchSize:   c.BatchSize,
+		MaxInFlight: c.MaxInFlight,
+		Duration:    d,
+	}
+}
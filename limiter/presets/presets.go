@@ -0,0 +1,122 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package presets provides ready-made limiter configurations for popular APIs.
+// The values are the published defaults at the time of writing; accounts with raised
+// quotas should adjust them.
+package presets
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+	"github.com/devfacet/gorate/limiter/llm"
+	"github.com/devfacet/gorate/limiter/ratelimitheaders"
+)
+
+// Preset represents a limiter configuration for an API
+type Preset struct {
+	// Name is the name of the preset
+	Name string
+	// QPS is the limit for the number of requests per second
+	QPS uint32
+	// Concurrency is the concurrency level
+	Concurrency uint32
+	// MaxInFlight is the limit for the number of requests in flight (optional)
+	MaxInFlight uint32
+	// CostLimit is the limit for the total cost (e.g. GraphQL points) within the cost window (optional)
+	CostLimit float64
+	// CostWindow is the length of the cost window
+	CostWindow time.Duration
+	// TPM is the limit for the number of tokens per minute (LLM APIs only)
+	TPM int
+	// Headers reports whether the API sends rate limit headers which can be synced
+	Headers bool
+	// Resource is the quota resource reported by the headers (optional).
+	// Headers of other resources are ignored by Sync.
+	Resource string
+}
+
+var (
+	// GitHubREST is the preset for the GitHub REST API (5000 requests per hour for authenticated
+	// users and at most 100 concurrent requests as a secondary limit)
+	GitHubREST = Preset{Name: "github-rest", QPS: 1, Concurrency: 10, MaxInFlight: 100, Headers: true, Resource: "core"}
+	// GitHubGraphQL is the preset for the GitHub GraphQL API (5000 points per hour).
+	// Callbacks should report the query cost via CallbackParams.AddCost.
+	GitHubGraphQL = Preset{Name: "github-graphql", QPS: 1, Concurrency: 10, MaxInFlight: 100, CostLimit: 5000, CostWindow: time.Hour, Headers: true, Resource: "graphql"}
+	// Twitter is the preset for the X (Twitter) API v2 (900 requests per 15 minutes for the common
+	// user endpoints)
+	Twitter = Preset{Name: "twitter", QPS: 1, Concurrency: 1, Headers: true}
+	// Stripe is the preset for the Stripe API in live mode (100 requests per second)
+	Stripe = Preset{Name: "stripe", QPS: 100, Concurrency: 20}
+	// StripeTest is the preset for the Stripe API in test mode (25 requests per second)
+	StripeTest = Preset{Name: "stripe-test", QPS: 25, Concurrency: 5}
+	// Slack is the preset for posting messages via the Slack Web API (1 message per second per channel)
+	Slack = Preset{Name: "slack", QPS: 1, Concurrency: 1}
+	// OpenAI is the preset for the OpenAI API (tier 1: 500 requests and 200000 tokens per minute)
+	OpenAI = Preset{Name: "openai", QPS: 8, Concurrency: 8, TPM: 200000, Headers: true}
+)
+
+// presets holds the presets by name
+var presets = map[string]Preset{}
+
+func init() {
+	for _, p := range []Preset{GitHubREST, GitHubGraphQL, Twitter, Stripe, StripeTest, Slack, OpenAI} {
+		presets[p.Name] = p
+	}
+}
+
+// Get returns the preset by the given name
+func Get(name string) (Preset, bool) {
+	p, ok := presets[strings.ToLower(name)]
+	return p, ok
+}
+
+// Names returns the names of the presets
+func Names() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options returns the limiter options of the preset.
+// Throttling is handled adaptively so the callbacks should return errors wrapping limiter.ErrThrottled on 429s.
+func (p Preset) Options() limiter.Options {
+	return limiter.Options{
+		Name:        p.Name,
+		QPS:         p.QPS,
+		Concurrency: p.Concurrency,
+		MaxInFlight: p.MaxInFlight,
+		CostLimit:   p.CostLimit,
+		CostWindow:  p.CostWindow,
+		Adaptive:    true,
+	}
+}
+
+// LLMOptions returns the llm limiter options of the preset
+func (p Preset) LLMOptions() llm.Options {
+	return llm.Options{RPM: int(p.QPS) * 60, TPM: p.TPM}
+}
+
+// Sync adjusts the internal rate of the given running limiter by the rate limit headers of
+// the given response headers. It returns false if the preset doesn't sync headers or the
+// headers don't belong to the preset resource.
+func (p Preset) Sync(lim *limiter.Limiter, h http.Header) bool {
+	if !p.Headers {
+		return false
+	}
+	if p.Resource != "" {
+		if r := h.Get("X-RateLimit-Resource"); r != "" && r != p.Resource {
+			return false
+		}
+	}
+	return ratelimitheaders.Sync(lim, h)
+}
@@ -0,0 +1,206 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PriorityOptions represents the options that can be set when creating a new priority limiter
+type PriorityOptions struct {
+	// QPS is the limit for the number of queries per second
+	QPS float64
+	// Burst is the burst size (default 1)
+	Burst int
+}
+
+// PriorityLimiter represents a limiter whose waits have priorities. Every wait reserves the next
+// free slot by the rate. When a wait would have to wait while lower priority waits hold earlier
+// slots, it preempts the earliest of them: it takes over its slot and the preempted wait is
+// re-queued to the slot the preempting wait would have got.
+type PriorityLimiter struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	tolerance   time.Duration
+	tat         time.Time
+	pending     []*priorityWait
+	allowed     atomic.Int64
+	rejected    atomic.Int64
+	canceled    atomic.Int64
+	preemptions atomic.Int64
+}
+
+// priorityWait represents a pending wait
+type priorityWait struct {
+	priority int
+	slot     time.Time
+	// wake is signaled when the slot changes
+	wake chan struct{}
+}
+
+// NewPriority creates a new priority limiter by the given options
+func NewPriority(o PriorityOptions) (*PriorityLimiter, error) {
+	if o.QPS <= 0 {
+		return nil, errors.New("qps value must be greater than zero")
+	} else if o.Burst < 0 {
+		return nil, errors.New("burst value must be positive")
+	}
+	if o.Burst == 0 {
+		o.Burst = 1
+	}
+	interval := time.Duration(float64(time.Second) / o.QPS)
+	return &PriorityLimiter{interval: interval, tolerance: time.Duration(o.Burst-1) * interval}, nil
+}
+
+// reserve reserves the next free slot (see GCRA).
+// It must be called while holding the lock.
+func (pl *PriorityLimiter) reserve(now time.Time) time.Time {
+	if earliest := now.Add(-pl.tolerance); pl.tat.Before(earliest) {
+		pl.tat = earliest
+	}
+	slot := pl.tat
+	if slot.Before(now) {
+		slot = now
+	}
+	pl.tat = pl.tat.Add(pl.interval)
+	return slot
+}
+
+// preempt swaps the slot of the given wait with the earliest earlier slot of a lower priority wait.
+// It must be called while holding the lock.
+func (pl *PriorityLimiter) preempt(w *priorityWait) {
+	var victim *priorityWait
+	for _, p := range pl.pending {
+		if p.priority < w.priority && p.slot.Before(w.slot) && (victim == nil || p.slot.Before(victim.slot)) {
+			victim = p
+		}
+	}
+	if victim == nil {
+		return
+	}
+	w.slot, victim.slot = victim.slot, w.slot
+	notify(victim.wake)
+	pl.preemptions.Add(1)
+}
+
+// cancel removes the given wait. Its slot is handed over to the latest pending wait so it isn't
+// lost, and the last slot is released if it becomes free.
+// It must be called while holding the lock.
+func (pl *PriorityLimiter) cancel(w *priorityWait) {
+	pl.remove(w)
+	freed := w.slot
+	var latest *priorityWait
+	for _, p := range pl.pending {
+		if p.slot.After(freed) && (latest == nil || p.slot.After(latest.slot)) {
+			latest = p
+		}
+	}
+	if latest != nil {
+		freed, latest.slot = latest.slot, freed
+		notify(latest.wake)
+	}
+	if last := pl.tat.Add(-pl.interval); freed.Equal(last) && freed.After(time.Now()) {
+		pl.tat = last
+	}
+	pl.canceled.Add(1)
+}
+
+// remove removes the given wait from the pending waits.
+// It must be called while holding the lock.
+func (pl *PriorityLimiter) remove(w *priorityWait) {
+	for i, p := range pl.pending {
+		if p == w {
+			pl.pending = append(pl.pending[:i], pl.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify signals the given channel without blocking
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Allow reports whether a query may happen now.
+// It doesn't preempt the pending waits.
+func (pl *PriorityLimiter) Allow() bool {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	now := time.Now()
+	if pl.tat.After(now) {
+		pl.rejected.Add(1)
+		return false
+	}
+	pl.reserve(now)
+	pl.allowed.Add(1)
+	return true
+}
+
+// Wait blocks until a query by the given priority (higher is more important) may happen
+func (pl *PriorityLimiter) Wait(ctx context.Context, priority int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pl.mu.Lock()
+	now := time.Now()
+	w := &priorityWait{priority: priority, slot: pl.reserve(now)}
+	if !w.slot.After(now) {
+		pl.mu.Unlock()
+		pl.allowed.Add(1)
+		return nil
+	}
+	w.wake = make(chan struct{}, 1)
+	pl.preempt(w)
+	pl.pending = append(pl.pending, w)
+	pl.mu.Unlock()
+
+	// The slot may be moved by the preemptions and the cancellations
+	t := time.NewTimer(time.Hour)
+	defer t.Stop()
+	for {
+		pl.mu.Lock()
+		d := time.Until(w.slot)
+		if d <= 0 {
+			pl.remove(w)
+			pl.mu.Unlock()
+			pl.allowed.Add(1)
+			return nil
+		}
+		pl.mu.Unlock()
+		resetTimer(t, d)
+		select {
+		case <-t.C:
+		case <-w.wake:
+		case <-ctx.Done():
+			pl.mu.Lock()
+			pl.cancel(w)
+			pl.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// NumOfPreemptions returns the number of the slots taken over from lower priority waits
+func (pl *PriorityLimiter) NumOfPreemptions() int {
+	return int(pl.preemptions.Load())
+}
+
+// NumOfCanceled returns the number of the waits canceled by their contexts
+func (pl *PriorityLimiter) NumOfCanceled() int {
+	return int(pl.canceled.Load())
+}
+
+// Stats returns the statistics
+func (pl *PriorityLimiter) Stats() Stats {
+	return Stats{Allowed: int(pl.allowed.Load()), Rejected: int(pl.rejected.Load())}
+}
@@ -0,0 +1,55 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// defaultProgressInterval is the default interval of the progress hook
+const defaultProgressInterval = time.Second
+
+// ProgressInfo represents the progress of a run
+type ProgressInfo struct {
+	// Percent is the percentage complete (see Limiter.Progress)
+	Percent float64
+	// ETA is the estimated remaining time (see Limiter.ETA)
+	ETA time.Duration
+	// Since is the time since the start of the run
+	Since time.Duration
+	// Queries is the number of queries
+	Queries int
+	// Done is whether the run has ended
+	Done bool
+}
+
+// progressInfo returns the progress of the current (or last) run
+func (limiter *Limiter) progressInfo() ProgressInfo {
+	return ProgressInfo{
+		Percent: limiter.Progress(),
+		ETA:     limiter.ETA(),
+		Since:   limiter.Since(),
+		Queries: limiter.NumOfQueries(),
+		Done:    limiter.IsDone(),
+	}
+}
+
+// runProgress invokes the progress hook every interval until the given context is done.
+// The final progress is reported by Run once the run has ended.
+func (limiter *Limiter) runProgress(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			limiter.onProgress(limiter.progressInfo())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOnProgress(t *testing.T) {
+	var mu sync.Mutex
+	var got []ProgressInfo
+	limiter, err := New(Options{
+		Concurrency:      1,
+		QPS:              100,
+		Limit:            30,
+		ProgressInterval: 50 * time.Millisecond,
+		Callback:         func(cbp CallbackParams) error { return nil },
+		OnProgress: func(p ProgressInfo) {
+			mu.Lock()
+			got = append(got, p)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) < 2 {
+		t.Fatalf("got %d progress calls, want the periodic ones and the final one", len(got))
+	}
+	for i, p := range got[:len(got)-1] {
+		if p.Done {
+			t.Errorf("got %+v at call %d, want a running progress", p, i)
+		}
+	}
+	if last := got[len(got)-1]; !last.Done || last.Percent != 100 || last.ETA != 0 || last.Queries != 30 {
+		t.Errorf("got %+v at the end, want a complete progress", last)
+	}
+}
+
+func TestProgressWithQueuedWriter(t *testing.T) {
+	limiter, err := New(Options{Concurrency: 4, QPS: 1000, Duration: 300 * time.Millisecond, Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- limiter.Run() }()
+
+	// The writers queue between the read locks; a nested read lock would deadlock behind them
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = limiter.ExtendDuration(0)
+			}
+		}
+	}()
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				limiter.Progress()
+				limiter.ETA()
+			}
+		}
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't end, the progress getters deadlocked")
+	}
+	close(stop)
+	wg.Wait()
+	select {
+	case <-polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("progress getters deadlocked")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// qpsWindow is the length of the sliding window of the current QPS
+	qpsWindow = time.Second
+	// qpsBuckets is the number of buckets the window is split into
+	qpsBuckets = 10
+	// qpsBucketLen is the length of a bucket
+	qpsBucketLen = qpsWindow / qpsBuckets
+)
+
+// qpsMeter counts the dispatched queries over a sliding window.
+// The buckets are recycled without a lock so a few queries may be lost while a bucket is reset.
+type qpsMeter struct {
+	start   time.Time
+	buckets [qpsBuckets]qpsBucket
+}
+
+// qpsBucket represents a bucket of the window
+type qpsBucket struct {
+	// epoch is the index of the bucket since the start (starts from 1)
+	epoch atomic.Int64
+	count atomic.Int64
+}
+
+// newQPSMeter creates a new QPS meter by the given start time
+func newQPSMeter(start time.Time) *qpsMeter {
+	return &qpsMeter{start: start}
+}
+
+// epoch returns the bucket index of the given time
+func (m *qpsMeter) epoch(now time.Time) int64 {
+	return int64(now.Sub(m.start)/qpsBucketLen) + 1
+}
+
+// add counts a query at the given time
+func (m *qpsMeter) add(now time.Time) {
+	epoch := m.epoch(now)
+	b := &m.buckets[epoch%qpsBuckets]
+	if e := b.epoch.Load(); e != epoch && b.epoch.CompareAndSwap(e, epoch) {
+		b.count.Store(0)
+	}
+	b.count.Add(1)
+}
+
+// rate returns the queries per second of the window which ends at the given time
+func (m *qpsMeter) rate(now time.Time) float64 {
+	epoch := m.epoch(now)
+	var n int64
+	for i := range m.buckets {
+		b := &m.buckets[i]
+		if e := b.epoch.Load(); e > epoch-qpsBuckets && e <= epoch {
+			n += b.count.Load()
+		}
+	}
+	// The current bucket is partial and the window can't be longer than the run
+	elapsed := min((qpsBuckets-1)*qpsBucketLen+now.Sub(m.start)%qpsBucketLen, now.Sub(m.start))
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / elapsed.Seconds()
+}
+
+// CurrentQPS returns the queries per second dispatched within the last second.
+// Unlike the achieved QPS of the report it follows the instantaneous rate.
+func (limiter *Limiter) CurrentQPS() float64 {
+	m := limiter.qpsMeter.Load()
+	if m == nil {
+		return 0
+	}
+	return m.rate(time.Now())
+}
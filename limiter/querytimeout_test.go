@@ -0,0 +1,76 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	errQuery := errors.New("query failed")
+	tests := []struct {
+		name     string
+		callback func(ctx context.Context) error
+		timeouts int
+		wantErr  error
+	}{
+		{
+			name:     "in time",
+			callback: func(ctx context.Context) error { return nil },
+		},
+		{
+			name: "timed out",
+			callback: func(ctx context.Context) error {
+				<-ctx.Done()
+				if cause := context.Cause(ctx); cause != ErrQueryTimeout {
+					return cause
+				}
+				return ctx.Err()
+			},
+			timeouts: 2,
+			wantErr:  context.DeadlineExceeded,
+		},
+		{
+			name:     "failed before timeout",
+			callback: func(ctx context.Context) error { return errQuery },
+			wantErr:  errQuery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := New(Options{
+				Concurrency:  2,
+				Limit:        10,
+				QueryTimeout: 20 * time.Millisecond,
+				Callback:     func(cbp CallbackParams) error { return tt.callback(cbp.Context) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			if err := limiter.Run(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+
+			// The timed out queries are failures and the workers don't hang
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("got run of %s, want the queries to time out after 20ms", elapsed)
+			}
+			if got := limiter.NumOfTimeouts(); got != tt.timeouts {
+				t.Errorf("got %d timeouts, want %d", got, tt.timeouts)
+			}
+			if got := limiter.Report().NumOfTimeouts; got != tt.timeouts {
+				t.Errorf("got %d report timeouts, want %d", got, tt.timeouts)
+			}
+			if tt.timeouts > 0 && limiter.NumOfFailures() != tt.timeouts {
+				t.Errorf("got %d failures, want %d", limiter.NumOfFailures(), tt.timeouts)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// minWaitInterval is the min interval between the checks of a wait
+const minWaitInterval = time.Millisecond
+
+// RateLimiter represents a keyed rate limiter.
+// It's implemented by KeyedLimiter (local), StoreLimiter, TwoTierLimiter and PartitionedLimiter (distributed)
+// so the application code can accept any of them and the tests can substitute fakes.
+type RateLimiter interface {
+	// Allow reports whether a query for the given key may happen now
+	Allow(key string) bool
+	// Wait blocks until a query for the given key may happen
+	Wait(ctx context.Context, key string) error
+	// WaitN blocks until n queries for the given key may happen
+	WaitN(ctx context.Context, key string, n int) error
+	// Stats returns the statistics
+	Stats() Stats
+}
+
+var (
+	_ RateLimiter = (*KeyedLimiter)(nil)
+	_ RateLimiter = (*StoreLimiter)(nil)
+	_ RateLimiter = (*TwoTierLimiter)(nil)
+	_ RateLimiter = (*PartitionedLimiter)(nil)
+)
+
+// Stats represents the statistics of a rate limiter
+type Stats struct {
+	// Allowed is the number of tokens granted
+	Allowed int
+	// Rejected is the number of rejected checks
+	Rejected int
+}
+
+// waitFor calls the given check until it succeeds, sleeping for the returned retry
+// durations in between. It returns the context error if the context is done first.
+func waitFor(ctx context.Context, check func() (bool, time.Duration)) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ok, retryAfter := check()
+		if ok {
+			return nil
+		}
+		if retryAfter < minWaitInterval {
+			retryAfter = minWaitInterval
+		}
+		t := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// waitTokens takes n tokens one by one by the given check (see waitFor) and counts the granted
+// tokens and a single rejection per call, however many times the check is retried
+func waitTokens(ctx context.Context, n int, check func() (bool, time.Duration), allowed, rejected *atomic.Int64) error {
+	counted := false
+	for i := 0; i < n; i++ {
+		err := waitFor(ctx, func() (bool, time.Duration) {
+			ok, retryAfter := check()
+			if !ok && !counted {
+				counted = true
+				rejected.Add(1)
+			}
+			return ok, retryAfter
+		})
+		if err != nil {
+			return err
+		}
+		allowed.Add(1)
+	}
+	return nil
+}
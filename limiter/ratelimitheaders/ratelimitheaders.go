@@ -0,0 +1,212 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package ratelimitheaders provides a parser for the rate limit headers of common providers
+package ratelimitheaders
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+// Quota represents a normalized rate limit quota.
+// Numeric values are -1 and times are zero when the headers don't provide them.
+type Quota struct {
+	// Provider is the name of the header scheme ("github", "twitter", "openai", "ietf", "amzn" or "retry-after")
+	Provider string
+	// Limit is the number of requests allowed within the window
+	Limit int
+	// Remaining is the number of requests left within the window
+	Remaining int
+	// Reset is the time the window resets
+	Reset time.Time
+	// Window is the length of the window (if known)
+	Window time.Duration
+	// RetryAfter is the duration to wait before retrying
+	RetryAfter time.Duration
+	// Resource is the name of the quota resource (e.g. "core" or "graphql" for GitHub)
+	Resource string
+}
+
+// Parse parses the rate limit headers of the given response headers.
+// It supports the GitHub style X-RateLimit-* headers (also used by Slack and others), the Twitter/X
+// x-rate-limit-* headers, the OpenAI x-ratelimit-*-requests headers, the IETF draft RateLimit-* and RateLimit/RateLimit-Policy fields, the Amazon x-amzn-RateLimit-Limit
+// header and the Retry-After header. It returns false if none of them is present.
+func Parse(h http.Header, now time.Time) (Quota, bool) {
+	q := Quota{Limit: -1, Remaining: -1}
+	found := false
+
+	// GitHub style: X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset (unix seconds)
+	if v := h.Get("X-RateLimit-Limit"); v != "" || h.Get("X-RateLimit-Remaining") != "" {
+		found = true
+		q.Provider = "github"
+		q.Limit = parseInt(v)
+		q.Remaining = parseInt(h.Get("X-RateLimit-Remaining"))
+		if reset := parseInt(h.Get("X-RateLimit-Reset")); reset >= 0 {
+			q.Reset = resetTime(reset, now)
+		}
+		q.Resource = h.Get("X-RateLimit-Resource")
+	}
+
+	// Twitter/X: x-rate-limit-limit, x-rate-limit-remaining, x-rate-limit-reset (unix seconds)
+	if v := h.Get("X-Rate-Limit-Limit"); v != "" || h.Get("X-Rate-Limit-Remaining") != "" {
+		found = true
+		q.Provider = "twitter"
+		q.Limit = parseInt(v)
+		q.Remaining = parseInt(h.Get("X-Rate-Limit-Remaining"))
+		if reset := parseInt(h.Get("X-Rate-Limit-Reset")); reset >= 0 {
+			q.Reset = resetTime(reset, now)
+		}
+	}
+
+	// OpenAI: x-ratelimit-limit-requests, x-ratelimit-remaining-requests, x-ratelimit-reset-requests (e.g. "6m0s")
+	if v := h.Get("X-RateLimit-Limit-Requests"); v != "" || h.Get("X-RateLimit-Remaining-Requests") != "" {
+		found = true
+		q.Provider = "openai"
+		q.Limit = parseInt(v)
+		q.Remaining = parseInt(h.Get("X-RateLimit-Remaining-Requests"))
+		if d, err := time.ParseDuration(strings.TrimSpace(h.Get("X-RateLimit-Reset-Requests"))); err == nil && d >= 0 {
+			q.Reset = now.Add(d)
+		}
+	}
+
+	// IETF draft: RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset (delta seconds)
+	if v := h.Get("RateLimit-Limit"); v != "" || h.Get("RateLimit-Remaining") != "" {
+		found = true
+		q.Provider = "ietf"
+		q.Limit = parseInt(firstItem(v))
+		q.Remaining = parseInt(firstItem(h.Get("RateLimit-Remaining")))
+		if reset := parseInt(firstItem(h.Get("RateLimit-Reset"))); reset >= 0 {
+			q.Reset = now.Add(time.Duration(reset) * time.Second)
+		}
+		if w := parseParam(h.Get("RateLimit-Limit"), "w"); w > 0 {
+			q.Window = time.Duration(w) * time.Second
+		}
+	}
+
+	// IETF draft (structured fields): RateLimit: "default";r=50;t=30 and RateLimit-Policy: "default";q=100;w=60
+	if v := h.Get("RateLimit"); v != "" {
+		found = true
+		q.Provider = "ietf"
+		if r := parseParam(v, "r"); r >= 0 {
+			q.Remaining = r
+		}
+		if t := parseParam(v, "t"); t >= 0 {
+			q.Reset = now.Add(time.Duration(t) * time.Second)
+		}
+		if p := h.Get("RateLimit-Policy"); p != "" {
+			if l := parseParam(p, "q"); l >= 0 {
+				q.Limit = l
+			}
+			if w := parseParam(p, "w"); w > 0 {
+				q.Window = time.Duration(w) * time.Second
+			}
+		}
+	}
+
+	// Amazon: x-amzn-RateLimit-Limit (requests per second)
+	if v := h.Get("X-Amzn-RateLimit-Limit"); v != "" && !found {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && f > 0 {
+			found = true
+			q.Provider = "amzn"
+			q.Limit = 1
+			q.Window = time.Duration(float64(time.Second) / f)
+			if f >= 1 {
+				q.Limit = int(f)
+				q.Window = time.Duration(float64(time.Second) * float64(q.Limit) / f)
+			}
+		}
+	}
+
+	// Retry-After (seconds or HTTP date)
+	if v := h.Get("Retry-After"); v != "" {
+		if s, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && s >= 0 {
+			q.RetryAfter = time.Duration(s) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			q.RetryAfter = t.Sub(now)
+		}
+		if q.RetryAfter > 0 && !found {
+			found = true
+			q.Provider = "retry-after"
+		}
+	}
+
+	return q, found
+}
+
+// Rate returns the rate (requests per second) that spreads the remaining requests until the reset.
+// It returns false if the quota doesn't have enough information.
+func (q Quota) Rate(now time.Time) (float64, bool) {
+	if q.Remaining < 0 {
+		if q.Provider == "amzn" && q.Limit > 0 && q.Window > 0 {
+			return float64(q.Limit) / q.Window.Seconds(), true
+		}
+		return 0, false
+	}
+	if q.Reset.IsZero() {
+		return 0, false
+	}
+	d := q.Reset.Sub(now)
+	if d <= 0 {
+		return 0, false
+	}
+	return float64(q.Remaining) / d.Seconds(), true
+}
+
+// Sync parses the rate limit headers of the given response headers and adjusts the internal
+// rate of the given running limiter by them (see Limiter.SyncQuota).
+// It returns false if the headers don't provide the remaining quota and the reset time.
+func Sync(lim *limiter.Limiter, h http.Header) bool {
+	q, ok := Parse(h, time.Now())
+	if !ok || q.Remaining < 0 || q.Reset.IsZero() {
+		return false
+	}
+	lim.SyncQuota(q.Remaining, q.Reset)
+	return true
+}
+
+// resetTime returns the reset time by the given X-RateLimit-Reset value. Most providers send
+// unix seconds, but some send the delta seconds, which are told apart by the magnitude.
+func resetTime(v int, now time.Time) time.Time {
+	if v < 1e9 {
+		return now.Add(time.Duration(v) * time.Second)
+	}
+	return time.Unix(int64(v), 0)
+}
+
+// parseInt parses the given integer value and returns -1 on failure
+func parseInt(v string) int {
+	i, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || i < 0 {
+		return -1
+	}
+	return i
+}
+
+// firstItem returns the first item of the given list value (e.g. "100, 100;w=60" -> "100")
+func firstItem(v string) string {
+	if i := strings.IndexAny(v, ",;"); i >= 0 {
+		v = v[:i]
+	}
+	return v
+}
+
+// parseParam returns the integer value of the given parameter in the given structured field
+// value (e.g. w in "100;w=60"). It returns -1 if the parameter is missing.
+func parseParam(v, name string) int {
+	for _, item := range strings.Split(v, ",") {
+		for _, p := range strings.Split(item, ";") {
+			k, val, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.TrimSpace(k) == name {
+				return parseInt(val)
+			}
+		}
+	}
+	return -1
+}
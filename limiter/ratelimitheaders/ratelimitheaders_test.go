@@ -0,0 +1,202 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package ratelimitheaders
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    Quota
+		found   bool
+	}{
+		{
+			name:    "none",
+			headers: map[string]string{"Content-Type": "text/plain"},
+			want:    Quota{Limit: -1, Remaining: -1},
+		},
+		{
+			name:    "github",
+			headers: map[string]string{"X-RateLimit-Limit": "5000", "X-RateLimit-Remaining": "4999", "X-RateLimit-Reset": "1700000060", "X-RateLimit-Resource": "core"},
+			want:    Quota{Provider: "github", Limit: 5000, Remaining: 4999, Reset: now.Add(time.Minute), Resource: "core"},
+			found:   true,
+		},
+		{
+			name:    "github delta reset",
+			headers: map[string]string{"X-RateLimit-Limit": "50", "X-RateLimit-Remaining": "10", "X-RateLimit-Reset": "30"},
+			want:    Quota{Provider: "github", Limit: 50, Remaining: 10, Reset: now.Add(30 * time.Second)},
+			found:   true,
+		},
+		{
+			name:    "twitter",
+			headers: map[string]string{"X-Rate-Limit-Limit": "900", "X-Rate-Limit-Remaining": "899", "X-Rate-Limit-Reset": "1700000900"},
+			want:    Quota{Provider: "twitter", Limit: 900, Remaining: 899, Reset: now.Add(15 * time.Minute)},
+			found:   true,
+		},
+		{
+			name:    "openai",
+			headers: map[string]string{"X-RateLimit-Limit-Requests": "60", "X-RateLimit-Remaining-Requests": "59", "X-RateLimit-Reset-Requests": "1s"},
+			want:    Quota{Provider: "openai", Limit: 60, Remaining: 59, Reset: now.Add(time.Second)},
+			found:   true,
+		},
+		{
+			name:    "ietf",
+			headers: map[string]string{"RateLimit-Limit": "100, 100;w=60", "RateLimit-Remaining": "50", "RateLimit-Reset": "30"},
+			want:    Quota{Provider: "ietf", Limit: 100, Remaining: 50, Reset: now.Add(30 * time.Second), Window: time.Minute},
+			found:   true,
+		},
+		{
+			name:    "ietf structured",
+			headers: map[string]string{"RateLimit": `"default";r=50;t=30`, "RateLimit-Policy": `"default";q=100;w=60`},
+			want:    Quota{Provider: "ietf", Limit: 100, Remaining: 50, Reset: now.Add(30 * time.Second), Window: time.Minute},
+			found:   true,
+		},
+		{
+			name:    "amzn",
+			headers: map[string]string{"X-Amzn-RateLimit-Limit": "20.0"},
+			want:    Quota{Provider: "amzn", Limit: 20, Remaining: -1, Window: time.Second},
+			found:   true,
+		},
+		{
+			name:    "amzn below one",
+			headers: map[string]string{"X-Amzn-RateLimit-Limit": "0.5"},
+			want:    Quota{Provider: "amzn", Limit: 1, Remaining: -1, Window: 2 * time.Second},
+			found:   true,
+		},
+		{
+			name:    "retry after seconds",
+			headers: map[string]string{"Retry-After": "120"},
+			want:    Quota{Provider: "retry-after", Limit: -1, Remaining: -1, RetryAfter: 2 * time.Minute},
+			found:   true,
+		},
+		{
+			name:    "retry after date",
+			headers: map[string]string{"Retry-After": now.Add(time.Minute).UTC().Format(http.TimeFormat)},
+			want:    Quota{Provider: "retry-after", Limit: -1, Remaining: -1, RetryAfter: time.Minute},
+			found:   true,
+		},
+		{
+			name:    "invalid values",
+			headers: map[string]string{"X-RateLimit-Limit": "many", "X-RateLimit-Remaining": "-5", "X-RateLimit-Reset": "soon"},
+			want:    Quota{Provider: "github", Limit: -1, Remaining: -1},
+			found:   true,
+		},
+		// Precedence
+		{
+			name:    "ietf over github",
+			headers: map[string]string{"X-RateLimit-Limit": "5000", "X-RateLimit-Remaining": "4999", "RateLimit-Limit": "100", "RateLimit-Remaining": "50"},
+			want:    Quota{Provider: "ietf", Limit: 100, Remaining: 50},
+			found:   true,
+		},
+		{
+			name:    "github over amzn",
+			headers: map[string]string{"X-RateLimit-Limit": "5000", "X-RateLimit-Remaining": "4999", "X-Amzn-RateLimit-Limit": "20"},
+			want:    Quota{Provider: "github", Limit: 5000, Remaining: 4999},
+			found:   true,
+		},
+		{
+			name:    "retry after with quota",
+			headers: map[string]string{"X-RateLimit-Limit": "5000", "X-RateLimit-Remaining": "0", "Retry-After": "10"},
+			want:    Quota{Provider: "github", Limit: 5000, Remaining: 0, RetryAfter: 10 * time.Second},
+			found:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			got, found := Parse(h, now)
+			if found != tt.found {
+				t.Errorf("got found %v, want %v", found, tt.found)
+			}
+			if !got.Reset.Equal(tt.want.Reset) {
+				t.Errorf("got reset %v, want %v", got.Reset, tt.want.Reset)
+			}
+			got.Reset, tt.want.Reset = time.Time{}, time.Time{}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaRate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name  string
+		quota Quota
+		want  float64
+		ok    bool
+	}{
+		{name: "remaining until reset", quota: Quota{Limit: 100, Remaining: 50, Reset: now.Add(10 * time.Second)}, want: 5, ok: true},
+		{name: "exhausted", quota: Quota{Limit: 100, Remaining: 0, Reset: now.Add(10 * time.Second)}, want: 0, ok: true},
+		{name: "past reset", quota: Quota{Limit: 100, Remaining: 50, Reset: now.Add(-time.Second)}},
+		{name: "no reset", quota: Quota{Limit: 100, Remaining: 50}},
+		{name: "no remaining", quota: Quota{Limit: 100, Remaining: -1, Reset: now.Add(time.Second)}},
+		{name: "amzn", quota: Quota{Provider: "amzn", Limit: 20, Remaining: -1, Window: time.Second}, want: 20, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.quota.Rate(now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    float64
+		ok      bool
+	}{
+		{name: "spread", headers: map[string]string{"RateLimit-Remaining": "10", "RateLimit-Reset": "10"}, want: 1, ok: true},
+		{name: "capped by qps", headers: map[string]string{"RateLimit-Remaining": "1000", "RateLimit-Reset": "1"}, want: 100, ok: true},
+		{name: "no reset", headers: map[string]string{"RateLimit-Remaining": "10"}, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			var ok bool
+			var got float64
+			lim, err := limiter.New(limiter.Options{
+				Concurrency: 1,
+				QPS:         100,
+				Limit:       1,
+				Callback: func(cbp limiter.CallbackParams) error {
+					ok = Sync(cbp.Limiter, h)
+					got = cbp.Limiter.RateLimit()
+					return nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := lim.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok || math.Abs(got-tt.want) > 0.01*tt.want {
+				t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
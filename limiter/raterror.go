@@ -0,0 +1,75 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// RateErrorPolicy represents the behavior when waiting for a resource fails by a non-context
+// error (e.g. a batch which exceeds the burst of a limiter)
+type RateErrorPolicy int
+
+const (
+	// RateErrorStop stops the whole run and reports the error
+	RateErrorStop RateErrorPolicy = iota
+	// RateErrorRetry retries the wait after the retry delay. The run is stopped once the
+	// retries of a worker are exhausted.
+	RateErrorRetry
+	// RateErrorReduce halves the number of tokens the worker waits for and retries the wait.
+	// The run is stopped once the worker fails to wait for a single token.
+	RateErrorReduce
+)
+
+const (
+	// defaultRateErrorRetries is the default number of consecutive retries of RateErrorRetry
+	defaultRateErrorRetries = 3
+	// defaultRateErrorRetryDelay is the default delay between the retries of RateErrorRetry
+	defaultRateErrorRetryDelay = 100 * time.Millisecond
+)
+
+// rateRecovery represents the rate error recovery state of a worker
+type rateRecovery struct {
+	// batch is the number of tokens the worker waits for
+	batch uint32
+	// retries is the number of consecutive retries
+	retries uint32
+}
+
+// handleRateError handles the given non-context error returned while waiting for a resource by
+// the rate error policy. It returns true if the worker should retry the wait.
+// The recovery state is nil for the waits which can't be reduced.
+func (limiter *Limiter) handleRateError(ctx context.Context, err error, rr *rateRecovery, timer *time.Timer) bool {
+	limiter.rateErrors.Add(1)
+	switch limiter.rateErrorPolicy {
+	case RateErrorRetry:
+		if rr != nil && rr.retries < limiter.rateErrorRetries {
+			rr.retries++
+			resetTimer(timer, limiter.rateErrorDelay)
+			// The next wait returns the context error if the context is done in the meantime
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+			return true
+		}
+	case RateErrorReduce:
+		if rr != nil && rr.batch > 1 {
+			rr.batch /= 2
+			return true
+		}
+	}
+	limiter.isRateError.Store(true)
+	limiter.setError(err)
+	limiter.StopWithCause(err)
+	return false
+}
+
+// NumOfRateErrors returns the number of non-context errors returned while waiting for a resource
+func (limiter *Limiter) NumOfRateErrors() int {
+	return int(limiter.rateErrors.Load())
+}
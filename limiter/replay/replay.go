@@ -0,0 +1,212 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package replay provides a replayer which re-issues recorded HTTP requests (HAR files or
+// access logs) through a limiter at the original rate, a scaled rate or a fixed QPS
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+// defaultConcurrency is the default concurrency level of a replay
+const defaultConcurrency = 10
+
+// Request represents a recorded request
+type Request struct {
+	// Time is the time the request was made
+	Time time.Time
+	// Method is the request method
+	Method string
+	// URL is the request url
+	URL string
+	// Header is the request header
+	Header http.Header
+	// Body is the request body
+	Body []byte
+}
+
+// ReadHAR reads the requests of the given HAR file
+func ReadHAR(r io.Reader) ([]Request, error) {
+	var har struct {
+		Log struct {
+			Entries []struct {
+				StartedDateTime time.Time `json:"startedDateTime"`
+				Request         struct {
+					Method  string `json:"method"`
+					URL     string `json:"url"`
+					Headers []struct {
+						Name  string `json:"name"`
+						Value string `json:"value"`
+					} `json:"headers"`
+					PostData *struct {
+						Text string `json:"text"`
+					} `json:"postData"`
+				} `json:"request"`
+			} `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return nil, fmt.Errorf("failed to decode har: %s", err)
+	}
+	requests := make([]Request, 0, len(har.Log.Entries))
+	for _, e := range har.Log.Entries {
+		req := Request{Time: e.StartedDateTime, Method: e.Request.Method, URL: e.Request.URL, Header: http.Header{}}
+		for _, h := range e.Request.Headers {
+			// HTTP/2 pseudo headers and the hop-by-hop headers are set by the client
+			if strings.HasPrefix(h.Name, ":") || strings.EqualFold(h.Name, "Content-Length") || strings.EqualFold(h.Name, "Host") {
+				continue
+			}
+			req.Header.Add(h.Name, h.Value)
+		}
+		if e.Request.PostData != nil {
+			req.Body = []byte(e.Request.PostData.Text)
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// accessLogRegexp matches the lines of the common and combined log formats
+var accessLogRegexp = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)(?: \S+)?" \d{3} \S+(?: "([^"]*)" "([^"]*)")?`)
+
+// ReadAccessLog reads the requests of the given access log in the common or combined format.
+// The request paths are resolved against the given base url (e.g. "https://example.com").
+// Lines that don't match the format are skipped.
+func ReadAccessLog(r io.Reader, baseURL string) ([]Request, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	var requests []Request
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		m := accessLogRegexp.FindStringSubmatch(s.Text())
+		if m == nil {
+			continue
+		}
+		t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[1])
+		if err != nil {
+			continue
+		}
+		req := Request{Time: t, Method: m[2], URL: baseURL + m[3], Header: http.Header{}}
+		if m[4] != "" && m[4] != "-" {
+			req.Header.Set("Referer", m[4])
+		}
+		if m[5] != "" && m[5] != "-" {
+			req.Header.Set("User-Agent", m[5])
+		}
+		requests = append(requests, req)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// Options represents the options that can be set when creating a new replay
+type Options struct {
+	// Requests are the requests to replay
+	Requests []Request
+	// Speed scales the original rate (default 1, e.g. 2 replays twice as fast)
+	Speed float64
+	// QPS replays the requests at the given fixed rate instead of the original one (optional)
+	QPS uint32
+	// Concurrency is the concurrency level (default 10)
+	Concurrency uint32
+	// Client is the HTTP client (default http.DefaultClient)
+	Client *http.Client
+	// OnResponse is invoked with every response (or error) (optional).
+	// The response body is drained and closed after it returns.
+	OnResponse func(req Request, res *http.Response, err error)
+}
+
+// New creates a new limiter which replays the requests by the given options.
+// Request errors don't stop the replay; they're reported to OnResponse.
+func New(o Options) (*limiter.Limiter, error) {
+	if len(o.Requests) == 0 {
+		return nil, errors.New("requests are required")
+	} else if o.Speed < 0 {
+		return nil, errors.New("speed value must be positive")
+	}
+	if o.Speed == 0 {
+		o.Speed = 1
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+	requests := append([]Request(nil), o.Requests...)
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].Time.Before(requests[j].Time) })
+
+	lo := limiter.Options{
+		Name:        "replay",
+		Concurrency: o.Concurrency,
+		Callback: func(cbp limiter.CallbackParams) error {
+			// The schedule dispatches the requests by their index, the QPS mode sends them in order
+			i := cbp.ScheduleIndex
+			if i < 0 {
+				i = cbp.Sequence - 1
+			}
+			req := requests[i]
+			res, err := send(cbp, o.Client, req)
+			if o.OnResponse != nil {
+				o.OnResponse(req, res, err)
+			}
+			if res != nil {
+				n, _ := io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+				cbp.AddBytes(int(n))
+			}
+			return nil
+		},
+	}
+	if lo.Concurrency > uint32(len(requests)) {
+		lo.Concurrency = uint32(len(requests))
+	}
+	if o.QPS > 0 {
+		lo.QPS, lo.Limit = o.QPS, uint32(len(requests))
+	} else {
+		lo.Schedule = schedule(len(requests), func(i int) time.Time { return requests[i].Time }, o.Speed)
+	}
+	return limiter.New(lo)
+}
+
+// schedule returns the dispatch schedule of n sorted timestamps scaled by the given speed
+func schedule(n int, at func(i int) time.Time, speed float64) limiter.Schedule {
+	s := make(limiter.Schedule, n)
+	for i := range s {
+		s[i] = time.Duration(float64(at(i).Sub(at(0))) / speed)
+	}
+	return s
+}
+
+// send sends the given request
+func send(cbp limiter.CallbackParams, client *http.Client, req Request) (*http.Response, error) {
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewReader(req.Body)
+	}
+	r, err := http.NewRequestWithContext(cbp.Context, req.Method, req.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	return client.Do(r)
+}
@@ -0,0 +1,66 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package replay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayTiming(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	base := time.Now()
+	requests := make([]Request, 8)
+	for i := range requests {
+		// Out of order on purpose
+		j := len(requests) - 1 - i
+		requests[i] = Request{Time: base.Add(time.Duration(j) * 25 * time.Millisecond), Method: http.MethodGet, URL: srv.URL + "/" + strconv.Itoa(j)}
+	}
+
+	var mu sync.Mutex
+	var start time.Time
+	got := map[string]time.Duration{}
+	l, err := New(Options{
+		Requests:    requests,
+		Concurrency: 4,
+		OnResponse: func(req Request, res *http.Response, err error) {
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			got[res.Request.URL.Path] = time.Since(start)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if err := l.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(requests) {
+		t.Fatalf("got %d requests, want %d", len(got), len(requests))
+	}
+	for j := range requests {
+		offset, ok := got["/"+strconv.Itoa(j)]
+		if !ok {
+			t.Fatalf("request %d wasn't sent", j)
+		}
+		want := time.Duration(j) * 25 * time.Millisecond
+		if d := offset - want; d < 0 || d > 50*time.Millisecond {
+			t.Errorf("got request %d at %s, want %s", j, offset, want)
+		}
+	}
+}
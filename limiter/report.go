@@ -0,0 +1,101 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"time"
+)
+
+// Report represents the summary of a run
+type Report struct {
+	// Name is the name of the limiter
+	Name string
+	// RunID is the unique id of the run
+	RunID string
+	// Seed is the seed of the run
+	Seed int64
+	// NumOfQueries is the number of queries (attempts)
+	NumOfQueries int
+	// NumOfSuccesses is the number of queries whose callbacks succeeded
+	NumOfSuccesses int
+	// NumOfFailures is the number of queries whose callbacks failed
+	NumOfFailures int
+	// NumOfTimeouts is the number of queries that failed after reaching the query timeout
+	NumOfTimeouts int
+	// Since is the elapsed time
+	Since time.Duration
+	// QPS is the achieved queries per second
+	QPS float64
+	// SuccessQPS is the achieved succeeded queries per second
+	SuccessQPS float64
+	// BlackoutTime is the time spent in blackout windows
+	BlackoutTime time.Duration
+	// NumOfBytes is the number of bytes reported by the callbacks
+	NumOfBytes int64
+	// TotalCost is the total cost reported by the callbacks
+	TotalCost float64
+	// Bottleneck is the name of the resource that the workers waited for the most
+	Bottleneck string
+	// ResourceWaits is the cumulative time spent waiting for every configured resource
+	ResourceWaits map[string]time.Duration
+	// RateErrors is the number of non-context errors returned while waiting for a resource
+	RateErrors int
+	// TimeAccounting is the time the workers spent waiting for the limiter, executing the
+	// callbacks and idle (see TimeAccounting.Bound)
+	TimeAccounting TimeAccounting
+	// GroupTimes is the time accounting by group id
+	GroupTimes map[int]TimeAccounting
+	// Overdrafts is the number of the token waits skipped by overdrawing the QPS budget
+	Overdrafts int
+	// DroppedResults is the number of results dropped by the sink backpressure policy
+	DroppedResults int
+	// SpilledResults is the number of results spilled to disk by the sink backpressure policy
+	SpilledResults int
+	// Latency is the latency statistics
+	Latency LatencyStats
+	// Breakdown is the breakdown of the queries by status code and error class
+	Breakdown Breakdown
+	// Watchdog is the process statistics sampled by the watchdog
+	Watchdog WatchdogStats
+	// LastError is the last error
+	LastError error
+}
+
+// Report returns the report of the current (or last) run
+func (limiter *Limiter) Report() Report {
+	r := Report{
+		Name:           limiter.name,
+		RunID:          limiter.RunID(),
+		Seed:           limiter.Seed(),
+		Since:          limiter.Since(),
+		BlackoutTime:   limiter.BlackoutTime(),
+		Bottleneck:     limiter.Bottleneck(),
+		ResourceWaits:  limiter.ResourceWaits(),
+		NumOfBytes:     limiter.NumOfBytes(),
+		TotalCost:      limiter.TotalCost(),
+		Latency:        limiter.LatencyStats(),
+		Breakdown:      limiter.Breakdown(),
+		RateErrors:     limiter.NumOfRateErrors(),
+		Overdrafts:     limiter.NumOfOverdrafts(),
+		DroppedResults: limiter.NumOfDroppedResults(),
+		SpilledResults: limiter.NumOfSpilledResults(),
+		Watchdog:       limiter.WatchdogStats(),
+		LastError:      limiter.LastError(),
+	}
+	if limiter.groups() != nil {
+		r.NumOfQueries = limiter.NumOfQueries()
+		r.NumOfSuccesses = limiter.NumOfSuccesses()
+		r.NumOfFailures = limiter.NumOfFailures()
+		r.NumOfTimeouts = limiter.NumOfTimeouts()
+		r.TimeAccounting = limiter.TimeAccountingByGroupID(0)
+		r.GroupTimes = limiter.GroupTimes()
+	}
+	if r.Since > 0 {
+		r.QPS = float64(r.NumOfQueries) / r.Since.Seconds()
+		r.SuccessQPS = float64(r.NumOfSuccesses) / r.Since.Seconds()
+	}
+	return r
+}
@@ -0,0 +1,115 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Reservation represents a token reserved for a query, which can be returned by Cancel
+// when the query is abandoned (e.g. speculative work)
+type Reservation struct {
+	ok     bool
+	delay  time.Duration
+	cancel func()
+	once   sync.Once
+}
+
+// OK reports whether the token was reserved
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns the duration to wait before the query may happen.
+// If the token wasn't reserved, it's the duration until a token may be available.
+func (r *Reservation) Delay() time.Duration {
+	return r.delay
+}
+
+// Cancel returns the reserved token, as far as the limiter allows.
+// It's a no-op if the token wasn't reserved or was already returned.
+func (r *Reservation) Cancel() {
+	if !r.ok || r.cancel == nil {
+		return
+	}
+	r.once.Do(r.cancel)
+}
+
+// Refunder represents a store which can return the tokens taken by Take.
+// Stores that don't implement it keep the tokens of the canceled reservations.
+type Refunder interface {
+	// Refund returns n tokens of the given key to its bucket by the given burst
+	Refund(ctx context.Context, key string, n int, burst int) error
+}
+
+// Reserve reserves a token for the given key. Unlike Check, it reserves the token even if it's
+// only available in the future and reports the wait by Delay.
+func (kl *KeyedLimiter) Reserve(key string) *Reservation {
+	return kl.reserveN(key, 1)
+}
+
+// reserveN reserves n tokens for the given key
+func (kl *KeyedLimiter) reserveN(key string, n int) *Reservation {
+	e := kl.entry(key)
+	now := time.Now()
+	if until := e.bannedUntil.Load(); until > now.UnixNano() {
+		kl.rejected.Add(1)
+		return &Reservation{delay: time.Duration(until - now.UnixNano())}
+	}
+	rr := e.lim.ReserveN(now, n)
+	if !rr.OK() {
+		kl.rejected.Add(1)
+		return &Reservation{}
+	}
+	kl.consumed(e, now, n)
+	return &Reservation{ok: true, delay: rr.DelayFrom(now), cancel: rr.Cancel}
+}
+
+// Reserve reserves a token for the given key. Tokens aren't reserved in the future so the
+// delay of a reservation is always zero. Canceled tokens are returned to the store only if it
+// implements Refunder.
+func (sl *StoreLimiter) Reserve(ctx context.Context, key string) *Reservation {
+	ok, retryAfter := sl.Check(ctx, key)
+	if !ok {
+		return &Reservation{delay: retryAfter}
+	}
+	r := &Reservation{ok: true}
+	if refunder, ok := sl.store.(Refunder); ok {
+		r.cancel = func() {
+			ctx, cancel := context.WithTimeout(context.Background(), sl.timeout)
+			defer cancel()
+			if err := refunder.Refund(ctx, key, 1, sl.burst); err != nil {
+				sl.errors.Add(1)
+			}
+		}
+	}
+	return r
+}
+
+// Reserve reserves a token for the given key. Canceled tokens are returned to the local
+// lease of the key (unless it expired or was evicted in the meantime).
+func (tl *TwoTierLimiter) Reserve(ctx context.Context, key string) *Reservation {
+	ok, retryAfter := tl.Check(ctx, key)
+	if !ok {
+		return &Reservation{delay: retryAfter}
+	}
+	return &Reservation{ok: true, cancel: func() {
+		tl.mu.Lock()
+		l, ok := tl.leases[key]
+		tl.mu.Unlock()
+		if !ok {
+			// Evicted
+			return
+		}
+		l.mu.Lock()
+		if time.Now().Before(l.expires) {
+			l.tokens++
+		}
+		l.mu.Unlock()
+	}}
+}
@@ -0,0 +1,188 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// ResourceQPS is the name of the queries per second resource
+	ResourceQPS = "qps"
+	// ResourceInFlight is the name of the in-flight queries resource
+	ResourceInFlight = "in_flight"
+)
+
+// resource represents a token bucket budget that is acquired before the queries
+type resource struct {
+	name string
+	lim  *rate.Limiter
+	// n returns the number of tokens to acquire for the given batch size
+	n func(batch int) int
+	// wait is the cumulative time spent waiting for the resource
+	wait atomic.Int64
+}
+
+// maxResources is the number of token bucket resources whose reservations are kept on the stack
+const maxResources = 4
+
+// waitTokens acquires tokens from all the token bucket resources at once.
+// It reserves the tokens from every resource, waits for the longest delay and cancels all
+// the reservations if any of them fails so no resource is consumed partially.
+// It returns the time the tokens were due (the intended start of the queries).
+// The given timer is reused for the waits so the hot path doesn't allocate.
+func (limiter *Limiter) waitTokens(ctx context.Context, batch int, timer *time.Timer) (time.Time, error) {
+	// Check if the context is already done
+	select {
+	case <-ctx.Done():
+		return time.Time{}, ctx.Err()
+	default:
+	}
+
+	// Reserve
+	// Reservations are copied into a fixed size array so they don't escape to the heap
+	now := time.Now()
+	var delay time.Duration
+	var bottleneck *resource
+	var buf [maxResources]rate.Reservation
+	reservations := buf[:0]
+	for _, res := range limiter.resources {
+		n := res.n(batch)
+		reservations = append(reservations, *res.lim.ReserveN(now, n))
+		r := &reservations[len(reservations)-1]
+		if !r.OK() {
+			cancelReservations(reservations[:len(reservations)-1], now)
+			return time.Time{}, fmt.Errorf("rate: Wait(n=%d) exceeds %s limiter's burst %d", n, res.name, res.lim.Burst())
+		}
+		if d := r.DelayFrom(now); res.lim == limiter.lim && limiter.overdraw(now, d) {
+			// The queries go ahead by putting the QPS budget into debt
+		} else if d > delay || bottleneck == nil {
+			delay, bottleneck = d, res
+		}
+	}
+	if delay == 0 {
+		return now, nil
+	}
+
+	// Wait
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(delay)) {
+		cancelReservations(reservations, now)
+		return time.Time{}, waitDeadlineError{name: "rate", n: batch}
+	}
+	if err := limiter.sleep(ctx, delay, timer); err != nil {
+		cancelReservations(reservations, now)
+		return time.Time{}, err
+	}
+	bottleneck.wait.Add(int64(delay))
+	return now.Add(delay), nil
+}
+
+// waitDeadlineError represents the error of a wait which would exceed the context deadline.
+// It matches context.DeadlineExceeded so it can be detected by errors.Is.
+type waitDeadlineError struct {
+	name string
+	n    int
+}
+
+// Error implements the error interface
+func (e waitDeadlineError) Error() string {
+	return fmt.Sprintf("%s: Wait(n=%d) would exceed context deadline", e.name, e.n)
+}
+
+// Is reports whether the error matches the given target
+func (e waitDeadlineError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// rateWaitError returns the error returned by the WaitN method of the given rate limiter
+// wrapped so a wait which would exceed the context deadline matches context.DeadlineExceeded.
+// It inspects the context and the limiter instead of the error message.
+func rateWaitError(ctx context.Context, lim *rate.Limiter, n int, err error) error {
+	if err == nil || ctx.Err() != nil {
+		return err
+	} else if _, ok := ctx.Deadline(); !ok || (n > lim.Burst() && lim.Limit() != rate.Inf) {
+		return err
+	}
+	return waitDeadlineError{name: "rate", n: n}
+}
+
+// cancelReservations cancels the given reservations
+func cancelReservations(reservations []rate.Reservation, now time.Time) {
+	for i := range reservations {
+		reservations[i].CancelAt(now)
+	}
+}
+
+// resetTimer stops the given timer, drains its channel and resets it by the given duration
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// acquireInFlight acquires an in-flight slot
+func (limiter *Limiter) acquireInFlight(ctx context.Context) error {
+	select {
+	case limiter.inFlight <- struct{}{}:
+		return nil
+	default:
+	}
+	t := time.Now()
+	select {
+	case limiter.inFlight <- struct{}{}:
+		limiter.inFlightWait.Add(int64(time.Since(t)))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseInFlight releases an in-flight slot
+func (limiter *Limiter) releaseInFlight() {
+	if limiter.inFlight != nil {
+		<-limiter.inFlight
+	}
+}
+
+// ResourceWaits returns the cumulative time spent waiting for every configured resource
+func (limiter *Limiter) ResourceWaits() map[string]time.Duration {
+	limiter.mu.RLock()
+	resources, inFlight := limiter.resources, limiter.inFlight
+	limiter.mu.RUnlock()
+	waits := make(map[string]time.Duration, len(resources)+1)
+	for _, res := range resources {
+		waits[res.name] = time.Duration(res.wait.Load())
+	}
+	if inFlight != nil {
+		waits[ResourceInFlight] = time.Duration(limiter.inFlightWait.Load())
+	}
+	if limiter.cost != nil {
+		waits[ResourceCost] = time.Duration(limiter.cost.wait.Load())
+	}
+	return waits
+}
+
+// Bottleneck returns the name of the resource that the workers waited for the most.
+// It returns an empty string if the workers never waited for any resource.
+func (limiter *Limiter) Bottleneck() string {
+	var name string
+	var max time.Duration
+	for k, v := range limiter.ResourceWaits() {
+		if v > max || (v == max && v > 0 && k < name) {
+			name, max = k, v
+		}
+	}
+	return name
+}
@@ -0,0 +1,218 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// newResourceLimiter returns a limiter with the QPS and the bandwidth resources of a run
+func newResourceLimiter(qps rate.Limit, burst int) *Limiter {
+	limiter := &Limiter{lim: rate.NewLimiter(qps, burst), bandwidth: rate.NewLimiter(rate.Inf, 0)}
+	limiter.resources = []*resource{
+		{name: ResourceQPS, lim: limiter.lim, n: func(batch int) int { return batch }},
+		{name: ResourceBandwidth, lim: limiter.bandwidth, n: func(int) int { return 0 }},
+	}
+	return limiter
+}
+
+func TestWaitTokensAllocs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for _, tc := range []struct {
+		name  string
+		qps   rate.Limit
+		burst int
+	}{
+		{"unlimited", rate.Inf, 0},
+		{"available", 1e9, 1e9},
+		{"waiting", 1e5, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := newResourceLimiter(tc.qps, tc.burst)
+			allocs := testing.AllocsPerRun(100, func() {
+				if _, err := limiter.waitTokens(ctx, 1, timer); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if allocs != 0 {
+				t.Errorf("got %v allocs per wait, want 0", allocs)
+			}
+		})
+	}
+}
+
+func BenchmarkWaitTokens(b *testing.B) {
+	for _, tc := range []struct {
+		name  string
+		qps   rate.Limit
+		burst int
+	}{
+		{"unlimited", rate.Inf, 0},
+		{"available", 1e12, 1e9},
+		{"waiting", 1e6, 1},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			limiter := newResourceLimiter(tc.qps, tc.burst)
+			ctx := context.Background()
+			timer := time.NewTimer(time.Hour)
+			defer timer.Stop()
+			for i := 0; i < b.N; i++ {
+				if _, err := limiter.waitTokens(ctx, 1, timer); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRateWaitError(t *testing.T) {
+	// A negative timeout is a deadline in the past, and the canceled contexts are canceled after cancelAfter
+	for _, tc := range []struct {
+		name         string
+		limit        rate.Limit
+		burst, n     int
+		timeout      time.Duration
+		cancel       bool
+		cancelAfter  time.Duration
+		wantErr      bool
+		wantDeadline bool
+		wantCanceled bool
+		wantWrapped  bool
+	}{
+		{name: "allowed", limit: 100, burst: 1, n: 1, timeout: time.Second},
+		{name: "burst exceeded", limit: 1, burst: 1, n: 2, timeout: time.Second, wantErr: true},
+		{name: "burst exceeded without deadline", limit: 1, burst: 1, n: 2, wantErr: true},
+		{name: "unlimited over burst", limit: rate.Inf, n: 5, timeout: time.Second},
+		{name: "deadline would be exceeded", limit: 0.1, burst: 1, n: 1, timeout: 50 * time.Millisecond, wantErr: true, wantDeadline: true, wantWrapped: true},
+		{name: "deadline exceeded", limit: 0.1, burst: 1, n: 1, timeout: -time.Second, wantErr: true, wantDeadline: true},
+		{name: "canceled", limit: 0.1, burst: 1, n: 1, cancel: true, wantErr: true, wantCanceled: true},
+		{name: "canceled while waiting", limit: 10, burst: 1, n: 1, cancel: true, cancelAfter: 10 * time.Millisecond, wantErr: true, wantCanceled: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lim := rate.NewLimiter(tc.limit, tc.burst)
+			if tc.burst > 0 {
+				lim.Allow() // the waits have to wait for the next token
+			}
+			ctx := context.Background()
+			if tc.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			if tc.cancel {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				defer cancel()
+				if tc.cancelAfter > 0 {
+					time.AfterFunc(tc.cancelAfter, cancel)
+				} else {
+					cancel()
+				}
+			}
+			err := rateWaitError(ctx, lim, tc.n, lim.WaitN(ctx, tc.n))
+
+			// The errors are inspected as wrapped by the callers
+			wrapped := fmt.Errorf("wait: %w", err)
+			var wde waitDeadlineError
+			switch {
+			case (err != nil) != tc.wantErr:
+				t.Fatalf("got %v, want error %v", err, tc.wantErr)
+			case errors.Is(wrapped, context.DeadlineExceeded) != tc.wantDeadline:
+				t.Errorf("got %v, want deadline exceeded %v", err, tc.wantDeadline)
+			case errors.Is(wrapped, context.Canceled) != tc.wantCanceled:
+				t.Errorf("got %v, want canceled %v", err, tc.wantCanceled)
+			case errors.As(wrapped, &wde) != tc.wantWrapped:
+				t.Errorf("got %T (%v), want waitDeadlineError %v", err, err, tc.wantWrapped)
+			}
+		})
+	}
+}
+
+func TestWaitTokensDeadline(t *testing.T) {
+	limiter := newResourceLimiter(0.1, 1)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := limiter.waitTokens(ctx, 1, timer); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next token is 10s away so the wait fails right away and the reservation is returned
+	start := time.Now()
+	_, err := limiter.waitTokens(ctx, 1, timer)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 100*time.Millisecond {
+		t.Fatalf("got %v after %s, want an immediate deadline exceeded", err, time.Since(start))
+	}
+	if tokens := limiter.lim.Tokens(); tokens < -0.01 {
+		t.Errorf("got %v tokens, want the reservation to be canceled", tokens)
+	}
+	limiter.limContext = ctx
+	if limiter.handleWaitError(ctx, err, nil, timer) || !limiter.isDeadline.Load() {
+		t.Error("got a retry, want the wait error to end the run by the deadline")
+	}
+}
+
+func TestMultiResourceBottleneck(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency uint32
+		qps         uint32
+		maxInFlight uint32
+		latency     time.Duration
+		want        string
+	}{
+		{name: "qps", concurrency: 4, qps: 50, maxInFlight: 4, want: ResourceQPS},
+		{name: "in flight", concurrency: 8, qps: 1000, maxInFlight: 2, latency: 20 * time.Millisecond, want: ResourceInFlight},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inFlight, maxInFlight atomic.Int64
+			limiter, err := New(Options{
+				Concurrency: tt.concurrency,
+				QPS:         tt.qps,
+				MaxInFlight: tt.maxInFlight,
+				Limit:       20,
+				Callback: func(cbp CallbackParams) error {
+					n := inFlight.Add(1)
+					for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+					}
+					time.Sleep(tt.latency)
+					inFlight.Add(-1)
+					return nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := limiter.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Both budgets are enforced and the one waited for the most is reported
+			if got := maxInFlight.Load(); got > int64(tt.maxInFlight) {
+				t.Errorf("got %d queries in flight, want at most %d", got, tt.maxInFlight)
+			}
+			if got := limiter.Bottleneck(); got != tt.want {
+				t.Errorf("got bottleneck %q (%v), want %q", got, limiter.ResourceWaits(), tt.want)
+			}
+			if got := limiter.Report().Bottleneck; got != tt.want {
+				t.Errorf("got report bottleneck %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
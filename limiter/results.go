@@ -0,0 +1,137 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Value represents the value returned by a query
+type Value[R any] struct {
+	// GroupID is the id for the concurrency group
+	GroupID int
+	// Sequence is the sequence number of the query
+	Sequence int
+	// Value is the returned value
+	Value R
+	// Err is the returned error
+	Err error
+}
+
+// Results represents a thread-safe store of the values returned by the queries of a run
+type Results[R any] struct {
+	mu     sync.Mutex
+	values []Value[R]
+	seen   int
+}
+
+// Values returns the collected values ordered by the sequence number
+func (r *Results[R]) Values() []Value[R] {
+	r.mu.Lock()
+	values := append([]Value[R](nil), r.values...)
+	r.mu.Unlock()
+	sort.Slice(values, func(i, j int) bool { return values[i].Sequence < values[j].Sequence })
+	return values
+}
+
+// Len returns the number of collected values
+func (r *Results[R]) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.values)
+}
+
+// Seen returns the number of values returned by the queries, including the ones left out by sampling
+func (r *Results[R]) Seen() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seen
+}
+
+// SampleOptions represents the sampling options of the collected values, so long runs keep a
+// statistically valid subset in memory instead of every value
+type SampleOptions struct {
+	// Rate keeps every value with the given probability (0-1, optional)
+	Rate float64
+	// Reservoir keeps a uniform random sample of at most the given number of values (optional).
+	// When the rate is set as well, it samples the values kept by the rate.
+	Reservoir int
+}
+
+// Collect returns a callback which calls the given function and collects its values (and errors)
+// into the returned store, so a run can be used for rate-limited fan-out queries whose answers matter
+func Collect[R any](fn func(ctx context.Context, cbp CallbackParams) (R, error)) (Callback, *Results[R]) {
+	results := &Results[R]{}
+	return func(cbp CallbackParams) error {
+		v, err := fn(cbp.Context, cbp)
+		results.mu.Lock()
+		results.values = append(results.values, Value[R]{GroupID: cbp.GroupID, Sequence: cbp.Sequence, Value: v, Err: err})
+		results.seen++
+		results.mu.Unlock()
+		return err
+	}, results
+}
+
+// CollectSample is like Collect but keeps a sample of the values by the given options.
+// The sampling decisions are derived from the run seed and the sequence numbers.
+func CollectSample[R any](fn func(ctx context.Context, cbp CallbackParams) (R, error), o SampleOptions) (Callback, *Results[R]) {
+	results := &Results[R]{}
+	var kept int // the number of values kept by the rate
+	return func(cbp CallbackParams) error {
+		v, err := fn(cbp.Context, cbp)
+		x := splitmix64(splitmix64(uint64(cbp.Limiter.Seed()) + uint64(cbp.Sequence)))
+
+		results.mu.Lock()
+		defer results.mu.Unlock()
+		results.seen++
+		if o.Rate > 0 && float64(x>>11)/(1<<53) >= o.Rate {
+			return err
+		}
+		value := Value[R]{GroupID: cbp.GroupID, Sequence: cbp.Sequence, Value: v, Err: err}
+		kept++
+		switch {
+		case o.Reservoir <= 0 || len(results.values) < o.Reservoir:
+			results.values = append(results.values, value)
+		default:
+			// Algorithm R: the value replaces a random one with the probability reservoir/kept
+			if j := int(splitmix64(x) % uint64(kept)); j < o.Reservoir {
+				results.values[j] = value
+			}
+		}
+		return err
+	}, results
+}
+
+// CollectTo returns a callback which calls the given function and sends its values (and errors)
+// to the given channel. The send blocks until the channel is ready or the query context is done.
+func CollectTo[R any](fn func(ctx context.Context, cbp CallbackParams) (R, error), ch chan<- Value[R]) Callback {
+	return func(cbp CallbackParams) error {
+		v, err := fn(cbp.Context, cbp)
+		select {
+		case ch <- Value[R]{GroupID: cbp.GroupID, Sequence: cbp.Sequence, Value: v, Err: err}:
+		case <-cbp.Context.Done():
+		}
+		return err
+	}
+}
+
+// CollectToDrop is like CollectTo but drops the values the channel isn't ready for instead of
+// blocking the workers. The returned function reports the number of dropped values.
+func CollectToDrop[R any](fn func(ctx context.Context, cbp CallbackParams) (R, error), ch chan<- Value[R]) (Callback, func() int) {
+	var dropped atomic.Int64
+	return func(cbp CallbackParams) error {
+		v, err := fn(cbp.Context, cbp)
+		select {
+		case ch <- Value[R]{GroupID: cbp.GroupID, Sequence: cbp.Sequence, Value: v, Err: err}:
+		default:
+			dropped.Add(1)
+		}
+		return err
+	}, func() int { return int(dropped.Load()) }
+}
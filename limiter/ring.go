@@ -0,0 +1,122 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// defaultRingReplicas is the default number of virtual nodes per node
+const defaultRingReplicas = 128
+
+// HashRing represents a consistent hash ring that partitions the keys across nodes,
+// so every key is owned by a single node and only a small share of the keys moves
+// when a node joins or leaves.
+type HashRing struct {
+	mu       sync.RWMutex
+	replicas int
+	hashes   []uint64
+	owners   map[uint64]string
+	nodes    map[string]struct{}
+}
+
+// NewHashRing creates a new hash ring by the given number of virtual nodes per node
+// (default 128) and nodes.
+func NewHashRing(replicas int, nodes ...string) *HashRing {
+	if replicas <= 0 {
+		replicas = defaultRingReplicas
+	}
+	r := &HashRing{
+		replicas: replicas,
+		owners:   make(map[uint64]string),
+		nodes:    make(map[string]struct{}),
+	}
+	r.Add(nodes...)
+	return r
+}
+
+// Add adds the given nodes
+func (r *HashRing) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		if _, ok := r.nodes[node]; ok {
+			continue
+		}
+		r.nodes[node] = struct{}{}
+		for i := 0; i < r.replicas; i++ {
+			h := ringHash(node + "#" + strconv.Itoa(i))
+			r.owners[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Remove removes the given nodes
+func (r *HashRing) Remove(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		if _, ok := r.nodes[node]; !ok {
+			continue
+		}
+		delete(r.nodes, node)
+		for i := 0; i < r.replicas; i++ {
+			delete(r.owners, ringHash(node+"#"+strconv.Itoa(i)))
+		}
+	}
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if _, ok := r.owners[h]; ok {
+			hashes = append(hashes, h)
+		}
+	}
+	r.hashes = hashes
+}
+
+// Owner returns the node that owns the given key.
+// It returns an empty string if the ring has no nodes.
+func (r *HashRing) Owner(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := ringHash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Nodes returns the nodes in the ring (sorted)
+func (r *HashRing) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// ringHash returns the position of the given value in the ring.
+// The FNV-1a hash is finalized (as in MurmurHash3) since its high bits, which order the ring,
+// barely change between similar short values.
+func ringHash(s string) uint64 {
+	h := fnv1a(s)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,129 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultReplayConcurrency is the default concurrency level of ReplaySchedule
+const defaultReplayConcurrency = 10
+
+// errScheduleDone is returned when all the dispatches of a replayed schedule are taken
+var errScheduleDone = errors.New("schedule done")
+
+// Schedule represents the dispatch times of a run as offsets from the run start, ordered by the
+// sequence number. It can be encoded as JSON and replayed by Options.Schedule.
+type Schedule []time.Duration
+
+// Duration returns the offset of the last dispatch
+func (s Schedule) Duration() time.Duration {
+	if len(s) == 0 {
+		return 0
+	}
+	return s[len(s)-1]
+}
+
+// scheduleRecorder represents the recorder of the dispatch times
+type scheduleRecorder struct {
+	mu      sync.Mutex
+	entries []scheduleEntry
+}
+
+// scheduleEntry represents a recorded dispatch
+type scheduleEntry struct {
+	seq    uint32
+	offset time.Duration
+}
+
+// record records the dispatch of the given sequence at the given offset
+func (sr *scheduleRecorder) record(seq uint32, offset time.Duration) {
+	sr.mu.Lock()
+	sr.entries = append(sr.entries, scheduleEntry{seq: seq, offset: offset})
+	sr.mu.Unlock()
+}
+
+// Schedule returns the dispatch schedule recorded by the current (or last) run.
+// It returns nil unless Options.RecordSchedule is set.
+func (limiter *Limiter) Schedule() Schedule {
+	if limiter.recorder == nil {
+		return nil
+	}
+	limiter.recorder.mu.Lock()
+	entries := append([]scheduleEntry(nil), limiter.recorder.entries...)
+	limiter.recorder.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	s := make(Schedule, len(entries))
+	for i, e := range entries {
+		s[i] = e.offset
+	}
+	return s
+}
+
+// waitSchedule waits until the time of the given dispatch of the replayed schedule, taking the next
+// one if it's negative. It returns the index and the time of the dispatch (the intended start of the query).
+func (limiter *Limiter) waitSchedule(ctx context.Context, i int, timer *time.Timer) (int, time.Time, error) {
+	if i < 0 {
+		i = int(limiter.scheduleNext.Add(1)) - 1
+	}
+	if i >= len(limiter.schedule) {
+		return i, time.Time{}, errScheduleDone
+	}
+	at := limiter.start.Add(limiter.schedule[i])
+	d := time.Until(at)
+	if d <= 0 {
+		return i, at, nil
+	}
+	return i, at, limiter.sleep(ctx, d, timer)
+}
+
+// TimedEvent represents a timestamped event
+type TimedEvent struct {
+	// Time is the time the event happened
+	Time time.Time
+	// Payload is the event payload
+	Payload interface{}
+}
+
+// ReplaySchedule re-drives the given events (any timestamped dataset) through a limiter by their
+// original timing scaled by the given speed factor (e.g. 2 replays twice as fast) and blocks until
+// the replay is over. A callback error stops its worker; the last error is returned.
+func ReplaySchedule(ctx context.Context, events []TimedEvent, speedFactor float64, callback func(ctx context.Context, ev TimedEvent) error) error {
+	if len(events) == 0 {
+		return errors.New("events are required")
+	} else if speedFactor < 0 {
+		return errors.New("speed factor value must be positive")
+	} else if callback == nil {
+		return errors.New("callback is required")
+	}
+	if speedFactor == 0 {
+		speedFactor = 1
+	}
+	events = append([]TimedEvent(nil), events...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	s := make(Schedule, len(events))
+	for i, ev := range events {
+		s[i] = time.Duration(float64(ev.Time.Sub(events[0].Time)) / speedFactor)
+	}
+
+	limiter, err := New(Options{
+		Name:        "replay",
+		Concurrency: uint32(min(len(events), defaultReplayConcurrency)),
+		Schedule:    s,
+		Context:     ctx,
+		Callback: func(cbp CallbackParams) error {
+			return callback(cbp.Context, events[cbp.ScheduleIndex])
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return limiter.Run()
+}
@@ -0,0 +1,183 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduleIndexWithGateSkip(t *testing.T) {
+	const n = 10
+	s := make(Schedule, n)
+	for i := range s {
+		s[i] = time.Duration(i) * 20 * time.Millisecond
+	}
+
+	// The first dispatch is skipped so the sequence numbers fall behind the dispatch indexes
+	var gated atomic.Int32
+	var mu sync.Mutex
+	offsets := map[int]time.Duration{}
+	var limiter *Limiter
+	limiter, err := New(Options{
+		Concurrency: 4,
+		Schedule:    s,
+		Gate: func(ctx context.Context, gi GateInfo) (bool, error) {
+			return gated.Add(1) > 1, nil
+		},
+		Callback: func(cbp CallbackParams) error {
+			mu.Lock()
+			defer mu.Unlock()
+			offsets[cbp.ScheduleIndex] = limiter.Since()
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(offsets) != n-1 {
+		t.Fatalf("got %d dispatches, want %d", len(offsets), n-1)
+	}
+	for i, offset := range offsets {
+		if i < 0 || i >= n {
+			t.Fatalf("got schedule index %d, want [0, %d)", i, n)
+		}
+		if d := offset - s[i]; d < 0 || d > 15*time.Millisecond {
+			t.Errorf("got dispatch %d at %s, want %s", i, offset, s[i])
+		}
+	}
+}
+
+func TestScheduleIndexWithoutSchedule(t *testing.T) {
+	limiter, err := New(Options{
+		Concurrency: 1,
+		QPS:         1000,
+		Limit:       3,
+		Callback: func(cbp CallbackParams) error {
+			if cbp.ScheduleIndex != -1 {
+				t.Errorf("got schedule index %d, want -1", cbp.ScheduleIndex)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplaySchedule(t *testing.T) {
+	base := time.Now()
+	events := make([]TimedEvent, 8)
+	for i := range events {
+		// Out of order on purpose
+		j := len(events) - 1 - i
+		events[i] = TimedEvent{Time: base.Add(time.Duration(j) * 25 * time.Millisecond), Payload: j}
+	}
+
+	var mu sync.Mutex
+	start := time.Now()
+	got := map[int]time.Duration{}
+	err := ReplaySchedule(context.Background(), events, 1, func(ctx context.Context, ev TimedEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got[ev.Payload.(int)] = time.Since(start)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(events) {
+		t.Fatalf("got %d events, want %d", len(got), len(events))
+	}
+	for j, offset := range got {
+		want := time.Duration(j) * 25 * time.Millisecond
+		if d := offset - want; d < 0 || d > 20*time.Millisecond {
+			t.Errorf("got event %d at %s, want %s", j, offset, want)
+		}
+	}
+}
+
+func TestScheduleRecordAndReplay(t *testing.T) {
+	// Record
+	recorded, err := New(Options{
+		Concurrency:    2,
+		QPS:            50,
+		Limit:          10,
+		RecordSchedule: true,
+		Callback:       func(cbp CallbackParams) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recorded.Run(); err != nil {
+		t.Fatal(err)
+	}
+	s := recorded.Schedule()
+	if len(s) != 10 {
+		t.Fatalf("got %d dispatches, want 10", len(s))
+	}
+	if d := s.Duration(); d < 150*time.Millisecond || d > 250*time.Millisecond {
+		t.Errorf("got schedule duration %s, want ~180ms at 50 qps", d)
+	}
+
+	// The schedule survives a JSON round trip
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Schedule
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay
+	replayed, err := New(Options{
+		Concurrency:    2,
+		Schedule:       decoded,
+		RecordSchedule: true,
+		Callback:       func(cbp CallbackParams) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replayed.Run(); err != nil {
+		t.Fatal(err)
+	}
+	got := replayed.Schedule()
+	if len(got) != len(s) {
+		t.Fatalf("got %d replayed dispatches, want %d", len(got), len(s))
+	}
+	for i := range got {
+		if d := got[i] - s[i]; d < -time.Millisecond || d > 15*time.Millisecond {
+			t.Errorf("got dispatch %d at %s, want %s", i, got[i], s[i])
+		}
+	}
+}
+
+func TestScheduleNotRecorded(t *testing.T) {
+	limiter, err := New(Options{Concurrency: 1, Limit: 3, Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if s := limiter.Schedule(); s != nil {
+		t.Errorf("got %v, want nil without RecordSchedule", s)
+	}
+}
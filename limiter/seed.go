@@ -0,0 +1,44 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand"
+)
+
+// newSeed returns a new random seed
+func newSeed() (int64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, fmt.Errorf("failed to generate seed: %s", err)
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]) >> 1), nil
+}
+
+// Seed returns the seed of the current (or last) run
+func (limiter *Limiter) Seed() int64 {
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
+	return limiter.runSeed
+}
+
+// Rand returns a random source for the query. It's derived from the run seed and the sequence
+// number, so a run with the same seed gets the same values per query regardless of the worker
+// scheduling.
+func (cbp CallbackParams) Rand() *mrand.Rand {
+	return mrand.New(mrand.NewSource(int64(splitmix64(uint64(cbp.Limiter.Seed()) + uint64(cbp.Sequence)))))
+}
+
+// splitmix64 returns the mixed value of the given value
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,232 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package sim provides a simulation harness which runs a limiter configuration against a
+// virtual clock and a synthetic callback latency distribution, so configurations can be
+// validated before real runs
+package sim
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Options represents the options that can be set when running a simulation
+type Options struct {
+	// QPS is the limit for the number of queries per second (0 for no limit)
+	QPS float64
+	// Burst is the burst size, the same as the limiter batch size (default 1)
+	Burst int
+	// Concurrency is the number of workers
+	Concurrency int
+	// MaxInFlight is the limit for the number of queries in flight (optional)
+	MaxInFlight int
+	// Duration is the simulated duration
+	Duration time.Duration
+	// Limit is the limit for the total number of queries (optional)
+	Limit int
+	// Latency returns a callback latency by the given random source (default Constant(0))
+	Latency Distribution
+	// ErrorRate is the ratio of the failed queries (0-1)
+	ErrorRate float64
+	// Seed is the seed of the random source
+	Seed int64
+}
+
+// Distribution represents a latency distribution
+type Distribution func(r *rand.Rand) time.Duration
+
+// Constant returns a distribution which always returns the given latency
+func Constant(d time.Duration) Distribution {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+// Uniform returns a uniform distribution between the given min and max latencies
+func Uniform(min, max time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		return min + time.Duration(r.Int63n(int64(max-min)+1))
+	}
+}
+
+// Exponential returns an exponential distribution by the given mean latency
+func Exponential(mean time.Duration) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// LogNormal returns a log-normal distribution by the given median latency and sigma,
+// which is a common shape for the latencies of remote services
+func LogNormal(median time.Duration, sigma float64) Distribution {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(float64(median) * math.Exp(r.NormFloat64()*sigma))
+	}
+}
+
+// Result represents the result of a simulation
+type Result struct {
+	// NumOfQueries is the number of queries
+	NumOfQueries int
+	// NumOfErrors is the number of failed queries
+	NumOfErrors int
+	// Duration is the simulated time until the last query ended
+	Duration time.Duration
+	// QPS is the achieved queries per second
+	QPS float64
+	// AvgLatency is the average callback latency
+	AvgLatency time.Duration
+	// P50Latency is the median callback latency
+	P50Latency time.Duration
+	// P99Latency is the 99th percentile callback latency
+	P99Latency time.Duration
+	// AvgWait is the average time the workers waited for a token or an in-flight slot
+	AvgWait time.Duration
+	// Bottleneck is what limited the throughput ("qps", "concurrency" or "in_flight")
+	Bottleneck string
+}
+
+// Run runs a simulation by the given options
+func Run(o Options) (Result, error) {
+	// Check the options
+	if o.Concurrency <= 0 {
+		return Result{}, errors.New("concurrency value must be greater than zero")
+	} else if o.Duration <= 0 && o.Limit <= 0 {
+		return Result{}, errors.New("set either limit or duration value")
+	} else if o.QPS < 0 || o.Burst < 0 || o.MaxInFlight < 0 || o.ErrorRate < 0 || o.ErrorRate > 1 {
+		return Result{}, errors.New("qps, burst, max in-flight and error rate values must be positive")
+	}
+	if o.Burst == 0 {
+		o.Burst = 1
+	}
+	if o.Latency == nil {
+		o.Latency = Constant(0)
+	}
+	if o.Duration <= 0 {
+		o.Duration = time.Duration(math.MaxInt64)
+	}
+
+	r := rand.New(rand.NewSource(o.Seed))
+	bucket := bucket{qps: o.QPS, burst: float64(o.Burst), tokens: float64(o.Burst)}
+	workers := make(timeHeap, o.Concurrency) // ready times of the workers
+	heap.Init(&workers)
+	var inFlight timeHeap // end times of the queries in flight
+	var res Result
+	var latencies []time.Duration
+	var waitRate, waitSlot, totalWait time.Duration
+	var end time.Duration
+
+	for res.NumOfQueries < o.Limit || o.Limit <= 0 {
+		ready := heap.Pop(&workers).(time.Duration)
+
+		// In-flight slot (acquired before the token as the limiter does)
+		start := ready
+		for len(inFlight) > 0 && inFlight[0] <= start {
+			heap.Pop(&inFlight)
+		}
+		if o.MaxInFlight > 0 && len(inFlight) >= o.MaxInFlight {
+			t := heap.Pop(&inFlight).(time.Duration)
+			waitSlot += t - start
+			start = t
+		}
+
+		// Token
+		granted := bucket.reserve(start)
+		waitRate += granted - start
+		start = granted
+		if start >= o.Duration {
+			break
+		}
+		totalWait += start - ready
+
+		// Callback
+		latency := o.Latency(r)
+		if latency < 0 {
+			latency = 0
+		}
+		latencies = append(latencies, latency)
+		res.NumOfQueries++
+		if o.ErrorRate > 0 && r.Float64() < o.ErrorRate {
+			res.NumOfErrors++
+		}
+		done := start + latency
+		if done > end {
+			end = done
+		}
+		if o.MaxInFlight > 0 {
+			heap.Push(&inFlight, done)
+		}
+		heap.Push(&workers, done)
+	}
+
+	// Result
+	res.Duration = end
+	if res.NumOfQueries == 0 {
+		return res, nil
+	}
+	if end > 0 {
+		res.QPS = float64(res.NumOfQueries) / end.Seconds()
+	}
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	res.AvgLatency = sum / time.Duration(len(latencies))
+	res.AvgWait = totalWait / time.Duration(res.NumOfQueries)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.P50Latency = latencies[(len(latencies)-1)*50/100]
+	res.P99Latency = latencies[(len(latencies)-1)*99/100]
+	switch {
+	case waitSlot > waitRate:
+		res.Bottleneck = "in_flight"
+	case waitRate > 0 && waitRate >= sum/time.Duration(o.Concurrency):
+		res.Bottleneck = "qps"
+	default:
+		res.Bottleneck = "concurrency"
+	}
+	return res, nil
+}
+
+// bucket represents a token bucket on the virtual clock
+type bucket struct {
+	qps    float64
+	burst  float64
+	tokens float64
+	last   time.Duration
+}
+
+// reserve reserves a token at the given time and returns the time it's granted.
+// Like rate.Limiter, the tokens go into debt for the future grants.
+func (b *bucket) reserve(t time.Duration) time.Duration {
+	if b.qps <= 0 {
+		return t
+	}
+	if t > b.last {
+		b.tokens = math.Min(b.burst, b.tokens+(t-b.last).Seconds()*b.qps)
+		b.last = t
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return b.last
+	}
+	return b.last + time.Duration(-b.tokens/b.qps*float64(time.Second))
+}
+
+// timeHeap represents a min-heap of times
+type timeHeap []time.Duration
+
+func (h timeHeap) Len() int            { return len(h) }
+func (h timeHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h timeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *timeHeap) Push(x interface{}) { *h = append(*h, x.(time.Duration)) }
+func (h *timeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,370 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHTTPSinkTimeout is the default timeout of the requests of the HTTP sink
+	defaultHTTPSinkTimeout = 10 * time.Second
+	// defaultUploadTimeout is the default timeout of the uploads of the upload sink
+	defaultUploadTimeout = time.Minute
+)
+
+// Result represents the result of a query
+type Result struct {
+	// RunID is the unique id of the run
+	RunID string
+	// GroupID is the id for the concurrency group
+	GroupID int
+	// Sequence is the sequence number of the query
+	Sequence int
+	// Scheduled is the intended start time of the query by the pacing (the time its token was due
+	// or its dispatch time in the replayed schedule)
+	Scheduled time.Time
+	// Start is the start time of the callback
+	Start time.Time
+	// Duration is the duration of the callback
+	Duration time.Duration
+	// Bytes is the number of bytes reported by the callback
+	Bytes int64
+	// Cost is the cost reported by the callback
+	Cost float64
+	// StatusCode is the status code reported by the callback
+	StatusCode int
+	// ErrorClass is the class of the error (see Options.ErrorClassifier)
+	ErrorClass string
+	// Err is the error returned by the callback
+	Err error
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (r Result) MarshalJSON() ([]byte, error) {
+	v := struct {
+		RunID     string  `json:"run_id"`
+		GroupID   int     `json:"group_id"`
+		Sequence  int     `json:"sequence"`
+		Scheduled string  `json:"scheduled,omitempty"`
+		Start     string  `json:"start"`
+		Duration  int64   `json:"duration_ns"`
+		Bytes     int64   `json:"bytes,omitempty"`
+		Cost      float64 `json:"cost,omitempty"`
+		Status    int     `json:"status_code,omitempty"`
+		Class     string  `json:"error_class,omitempty"`
+		Error     string  `json:"error,omitempty"`
+	}{
+		RunID:    r.RunID,
+		GroupID:  r.GroupID,
+		Sequence: r.Sequence,
+		Start:    r.Start.Format(time.RFC3339Nano),
+		Duration: int64(r.Duration),
+		Bytes:    r.Bytes,
+		Cost:     r.Cost,
+		Status:   r.StatusCode,
+		Class:    r.ErrorClass,
+	}
+	if !r.Scheduled.IsZero() {
+		v.Scheduled = r.Scheduled.Format(time.RFC3339Nano)
+	}
+	if r.Err != nil {
+		v.Error = r.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// Sink represents a destination for query results.
+// Implementations must be safe for concurrent use since every worker writes its own results.
+type Sink interface {
+	// Write writes the given result
+	Write(r Result) error
+	// Flush flushes the buffered results
+	Flush() error
+}
+
+// ReportSink represents a sink that receives the final report of a run as well
+type ReportSink interface {
+	Sink
+	// WriteReport writes the final report of the given limiter. It's called once the run is done,
+	// after the results are flushed.
+	WriteReport(limiter *Limiter) error
+}
+
+// WriterSink represents a sink that writes results as JSON lines to a writer
+type WriterSink struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewWriterSink creates a new writer sink by the given writer
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: bufio.NewWriter(w)}
+}
+
+// NewStdoutSink creates a new writer sink for the standard output
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+// NewFileSink creates a new writer sink by the given file name.
+// The file is created or truncated, and it should be closed by calling the Close method.
+func NewFileSink(name string) (*WriterSink, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	sink := NewWriterSink(f)
+	sink.closer = f
+	return sink, nil
+}
+
+// Write writes the given result
+func (sink *WriterSink) Write(r Result) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if _, err := sink.w.Write(b); err != nil {
+		return err
+	}
+	return sink.w.WriteByte('\n')
+}
+
+// Flush flushes the buffered results
+func (sink *WriterSink) Flush() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.w.Flush()
+}
+
+// Close flushes the buffered results and closes the underlying file (if any)
+func (sink *WriterSink) Close() error {
+	if err := sink.Flush(); err != nil {
+		return err
+	}
+	if sink.closer != nil {
+		return sink.closer.Close()
+	}
+	return nil
+}
+
+// HTTPSink represents a sink that posts results as JSON arrays to a URL
+type HTTPSink struct {
+	// Client is the HTTP client (default http.DefaultClient)
+	Client *http.Client
+	// Header is the additional request headers
+	Header http.Header
+	// Timeout is the timeout of a request (default 10s), so a stalled endpoint can't hang the
+	// end of the run
+	Timeout time.Duration
+
+	url       string
+	batchSize int
+	mu        sync.Mutex
+	buf       []Result
+}
+
+// NewHTTPSink creates a new HTTP sink by the given URL and batch size.
+// Results are posted once the batch is full and when the sink is flushed.
+func NewHTTPSink(url string, batchSize int) *HTTPSink {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &HTTPSink{url: url, batchSize: batchSize}
+}
+
+// Write writes the given result
+func (sink *HTTPSink) Write(r Result) error {
+	sink.mu.Lock()
+	sink.buf = append(sink.buf, r)
+	if len(sink.buf) < sink.batchSize {
+		sink.mu.Unlock()
+		return nil
+	}
+	batch := sink.buf
+	sink.buf = nil
+	sink.mu.Unlock()
+
+	return sink.post(batch)
+}
+
+// Flush flushes the buffered results
+func (sink *HTTPSink) Flush() error {
+	sink.mu.Lock()
+	batch := sink.buf
+	sink.buf = nil
+	sink.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return sink.post(batch)
+}
+
+// post posts the given results
+func (sink *HTTPSink) post(batch []Result) error {
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	timeout := sink.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTTPSinkTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range sink.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := sink.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("failed to post results: %s", res.Status)
+	}
+	return nil
+}
+
+// UploadKind represents the kind of an uploaded object
+type UploadKind string
+
+const (
+	// UploadReport is the final report of the run (see Limiter.MarshalJSON)
+	UploadReport UploadKind = "report"
+	// UploadLog is the raw result log (JSON lines)
+	UploadLog UploadKind = "log"
+)
+
+// UploadSink represents a sink that uploads the final report of a run, and optionally the raw
+// result log, as objects. It's dependency free and works with any object store that accepts
+// HTTP PUT uploads, such as S3 or GCS presigned (signed) URLs.
+type UploadSink struct {
+	// Client is the HTTP client (default http.DefaultClient)
+	Client *http.Client
+	// Header is the additional request headers
+	Header http.Header
+	// Log enables uploading the raw result log on flush. The results are buffered in memory.
+	Log bool
+	// Timeout is the timeout of an upload (default 1m), so a stalled object store can't hang the
+	// end of the run
+	Timeout time.Duration
+
+	objectURL func(runID string, kind UploadKind) (string, error)
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	runID     string
+}
+
+// NewUploadSink creates a new upload sink by the given function that returns the object URL
+// (typically a presigned URL) for the given run id and the kind of the object.
+func NewUploadSink(objectURL func(runID string, kind UploadKind) (string, error)) *UploadSink {
+	return &UploadSink{objectURL: objectURL}
+}
+
+// Write writes the given result
+func (sink *UploadSink) Write(r Result) error {
+	if !sink.Log {
+		return nil
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.runID = r.RunID
+	sink.buf.Write(b)
+	sink.buf.WriteByte('\n')
+	return nil
+}
+
+// Flush uploads the buffered results if the raw result log is enabled
+func (sink *UploadSink) Flush() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.buf.Len() == 0 {
+		return nil
+	}
+	if err := sink.upload(sink.runID, UploadLog, "application/x-ndjson", sink.buf.Bytes()); err != nil {
+		return err
+	}
+	sink.buf.Reset()
+	return nil
+}
+
+// WriteReport uploads the final report of the given limiter
+func (sink *UploadSink) WriteReport(limiter *Limiter) error {
+	b, err := limiter.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return sink.upload(limiter.RunID(), UploadReport, "application/json", b)
+}
+
+// upload uploads the given object
+func (sink *UploadSink) upload(runID string, kind UploadKind, contentType string, b []byte) error {
+	url, err := sink.objectURL(runID, kind)
+	if err != nil {
+		return err
+	}
+	timeout := sink.Timeout
+	if timeout <= 0 {
+		timeout = defaultUploadTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range sink.Header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	client := sink.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("failed to upload %s: %s", kind, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// uploadServer represents an object store which records the uploads by path
+type uploadServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	status  int
+}
+
+func newUploadServer(status int) *uploadServer {
+	us := &uploadServer{objects: map[string][]byte{}, types: map[string]string{}, status: status}
+	us.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		us.mu.Lock()
+		us.objects[r.URL.Path], us.types[r.URL.Path] = b, r.Header.Get("Content-Type")
+		us.mu.Unlock()
+		w.WriteHeader(us.status)
+	}))
+	return us
+}
+
+// objectURL returns the object URL of the given run id and kind
+func (us *uploadServer) objectURL(runID string, kind UploadKind) (string, error) {
+	return us.URL + "/" + runID + "/" + string(kind), nil
+}
+
+func TestUploadSink(t *testing.T) {
+	for _, log := range []bool{false, true} {
+		us := newUploadServer(http.StatusOK)
+		defer us.Close()
+		sink := NewUploadSink(us.objectURL)
+		sink.Log = log
+		limiter, err := New(Options{Concurrency: 2, Limit: 10, Sink: sink, Callback: func(cbp CallbackParams) error { return nil }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := limiter.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The report is the final state of the run
+		prefix := "/" + limiter.RunID() + "/"
+		var report struct {
+			RunID    string `json:"run_id"`
+			Running  bool   `json:"running"`
+			Counters struct {
+				Queries int `json:"queries"`
+			} `json:"counters"`
+		}
+		if err := json.Unmarshal(us.objects[prefix+"report"], &report); err != nil {
+			t.Fatalf("got %v, want the report", err)
+		}
+		if report.RunID != limiter.RunID() || report.Running || report.Counters.Queries != 10 {
+			t.Errorf("got report %+v, want the final report", report)
+		}
+		if ct := us.types[prefix+"report"]; ct != "application/json" {
+			t.Errorf("got report content type %q, want application/json", ct)
+		}
+
+		// The raw log is opt-in
+		b, ok := us.objects[prefix+"log"]
+		if ok != log {
+			t.Fatalf("got log upload %v, want %v", ok, log)
+		} else if log && bytes.Count(b, []byte("\n")) != 10 {
+			t.Errorf("got %d log lines, want 10", bytes.Count(b, []byte("\n")))
+		}
+	}
+}
+
+func TestUploadSinkError(t *testing.T) {
+	us := newUploadServer(http.StatusForbidden)
+	defer us.Close()
+	limiter, err := New(Options{Concurrency: 1, Limit: 1, Sink: NewUploadSink(us.objectURL), Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err == nil || !strings.Contains(err.Error(), "failed to upload report") {
+		t.Fatalf("got %v, want the upload error", err)
+	}
+}
+
+// newStalledServer returns a server which doesn't respond until the test is done
+func newStalledServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(stop)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestHTTPSinkTimeout(t *testing.T) {
+	srv := newStalledServer(t)
+	sink := NewHTTPSink(srv.URL, 10)
+	sink.Timeout = 50 * time.Millisecond
+	if err := sink.Write(Result{Sequence: 1}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := sink.Flush(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("got a flush of %s, want ~50ms", elapsed)
+	}
+}
+
+func TestUploadSinkTimeout(t *testing.T) {
+	srv := newStalledServer(t)
+	sink := NewUploadSink(func(runID string, kind UploadKind) (string, error) { return srv.URL + "/" + runID, nil })
+	sink.Timeout = 50 * time.Millisecond
+	limiter, err := New(Options{Concurrency: 1, Limit: 1, Sink: sink, Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := limiter.Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("got a run of %s, want ~50ms", elapsed)
+	}
+}
@@ -0,0 +1,334 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backpressure represents the behavior when a result consumer can't keep up
+type Backpressure int
+
+const (
+	// BackpressureBlock blocks the worker until the consumer is ready
+	BackpressureBlock Backpressure = iota
+	// BackpressureDrop drops the result and counts it
+	BackpressureDrop
+	// BackpressureSpill spills the result to an on-disk ring buffer which is drained when the consumer
+	// catches up, so bursts of slow sink I/O never block the workers. The results that don't fit
+	// in the buffer are dropped.
+	BackpressureSpill
+)
+
+const (
+	// defaultSpillMaxSize is the default max size of the spill file
+	defaultSpillMaxSize = 256 << 20
+	// spillHeaderSize is the size of the length prefix of the spilled records
+	spillHeaderSize = 4
+)
+
+// errSpillFull is returned when the spill file doesn't have room for a result
+var errSpillFull = errors.New("spill file is full")
+
+// sinkQueue represents the queue between the workers and a sink.
+// The workers enqueue the results and a single writer goroutine writes them to the sink.
+type sinkQueue struct {
+	sink     Sink
+	ch       chan Result
+	policy   Backpressure
+	spillDir string
+	spillMax int64
+	spillMu  sync.Mutex
+	spill    *spillFile
+	dropped  atomic.Int64
+	spilled  atomic.Int64
+	done     chan struct{}
+	setError func(err error)
+}
+
+// newSinkQueue creates and starts a new sink queue
+func newSinkQueue(sink Sink, size int, policy Backpressure, spillDir string, spillMax int64, setError func(err error)) *sinkQueue {
+	q := &sinkQueue{
+		sink:     sink,
+		ch:       make(chan Result, size),
+		policy:   policy,
+		spillDir: spillDir,
+		spillMax: spillMax,
+		done:     make(chan struct{}),
+		setError: setError,
+	}
+	go q.run()
+	return q
+}
+
+// write enqueues the given result by the backpressure policy
+func (q *sinkQueue) write(r Result) {
+	select {
+	case q.ch <- r:
+		return
+	default:
+	}
+	switch q.policy {
+	case BackpressureDrop:
+		q.dropped.Add(1)
+	case BackpressureSpill:
+		if err := q.spillResult(r); err != nil {
+			q.dropped.Add(1)
+			if err != errSpillFull {
+				q.setError(err)
+			}
+		}
+	default:
+		q.ch <- r
+	}
+}
+
+// spillResult spills the given result to the spill file which is created on the first spill
+func (q *sinkQueue) spillResult(r Result) error {
+	q.spillMu.Lock()
+	if q.spill == nil {
+		s, err := newSpillFile(q.spillDir, q.spillMax)
+		if err != nil {
+			q.spillMu.Unlock()
+			return err
+		}
+		q.spill = s
+	}
+	s := q.spill
+	q.spillMu.Unlock()
+	if err := s.push(r); err != nil {
+		return err
+	}
+	q.spilled.Add(1)
+	return nil
+}
+
+// spillFile returns the spill file, or nil if nothing was spilled yet
+func (q *sinkQueue) spillFile() *spillFile {
+	q.spillMu.Lock()
+	defer q.spillMu.Unlock()
+	return q.spill
+}
+
+// run writes the queued results to the sink until the queue is closed.
+// The spilled results are drained whenever the queue is empty.
+func (q *sinkQueue) run() {
+	defer close(q.done)
+	for {
+		if len(q.ch) == 0 && q.drainSpill(false) {
+			continue
+		}
+		r, ok := <-q.ch
+		if !ok {
+			q.drainSpill(true)
+			return
+		}
+		q.writeSink(r)
+	}
+}
+
+// drainSpill writes the spilled results to the sink.
+// Unless all is set, it stops as soon as a result is queued. It returns whether any result was written.
+func (q *sinkQueue) drainSpill(all bool) bool {
+	s := q.spillFile()
+	if s == nil {
+		return false
+	}
+	written := false
+	for all || len(q.ch) == 0 {
+		r, ok, err := s.pop()
+		if err != nil {
+			q.setError(err)
+			return written
+		} else if !ok {
+			return written
+		}
+		q.writeSink(r)
+		written = true
+	}
+	return written
+}
+
+// writeSink writes the given result to the sink
+func (q *sinkQueue) writeSink(r Result) {
+	if err := q.sink.Write(r); err != nil {
+		q.setError(err)
+	}
+}
+
+// close closes the queue and waits until all the results are written
+func (q *sinkQueue) close() {
+	close(q.ch)
+	<-q.done
+	if s := q.spillFile(); s != nil {
+		if err := s.close(); err != nil {
+			q.setError(err)
+		}
+	}
+}
+
+// spillRecord represents a spilled result
+type spillRecord struct {
+	RunID      string        `json:"r"`
+	GroupID    int           `json:"g"`
+	Sequence   int           `json:"s"`
+	Scheduled  time.Time     `json:"st"`
+	Start      time.Time     `json:"t"`
+	Duration   time.Duration `json:"d"`
+	Bytes      int64         `json:"b,omitempty"`
+	Cost       float64       `json:"c,omitempty"`
+	StatusCode int           `json:"sc,omitempty"`
+	ErrorClass string        `json:"ec,omitempty"`
+	Err        string        `json:"e,omitempty"`
+}
+
+// spillFile represents a FIFO of results in an on-disk ring buffer of a fixed max size.
+// The records are length-prefixed JSON; the file grows up to the max size as needed and
+// it's truncated (compacted) whenever the buffer drains, so the disk use shrinks back after a burst.
+type spillFile struct {
+	mu      sync.Mutex
+	f       *os.File
+	maxSize int64
+	head    int64
+	used    int64
+	n       int
+	buf     []byte
+}
+
+// newSpillFile creates a new spill file in the given directory (default os.TempDir())
+func newSpillFile(dir string, maxSize int64) (*spillFile, error) {
+	f, err := os.CreateTemp(dir, "gorate-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{f: f, maxSize: maxSize}, nil
+}
+
+// push appends the given result. It returns errSpillFull if the buffer doesn't have room for it.
+func (s *spillFile) push(r Result) error {
+	rec := spillRecord{RunID: r.RunID, GroupID: r.GroupID, Sequence: r.Sequence, Scheduled: r.Scheduled, Start: r.Start, Duration: r.Duration,
+		Bytes: r.Bytes, Cost: r.Cost, StatusCode: r.StatusCode, ErrorClass: r.ErrorClass}
+	if r.Err != nil {
+		rec.Err = r.Err.Error()
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := int64(spillHeaderSize + len(b))
+	if size > s.maxSize-s.used {
+		return errSpillFull
+	}
+	s.buf = binary.LittleEndian.AppendUint32(s.buf[:0], uint32(len(b)))
+	s.buf = append(s.buf, b...)
+	if err := s.writeAt(s.buf, (s.head+s.used)%s.maxSize); err != nil {
+		return err
+	}
+	s.used += size
+	s.n++
+	return nil
+}
+
+// pop removes and returns the oldest result. The errors of the spilled results are plain errors
+// with the same messages.
+func (s *spillFile) pop() (Result, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n == 0 {
+		return Result{}, false, nil
+	}
+	var header [spillHeaderSize]byte
+	if err := s.readAt(header[:], s.head); err != nil {
+		return Result{}, false, err
+	}
+	n := int(binary.LittleEndian.Uint32(header[:]))
+	if cap(s.buf) < n {
+		s.buf = make([]byte, n)
+	}
+	b := s.buf[:n]
+	if err := s.readAt(b, (s.head+spillHeaderSize)%s.maxSize); err != nil {
+		return Result{}, false, err
+	}
+	var rec spillRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return Result{}, false, err
+	}
+	s.head = (s.head + int64(spillHeaderSize+n)) % s.maxSize
+	s.used -= int64(spillHeaderSize + n)
+	if s.n--; s.n == 0 {
+		// Compact
+		if err := s.f.Truncate(0); err != nil {
+			return Result{}, false, err
+		}
+		s.head, s.used = 0, 0
+	}
+	r := Result{RunID: rec.RunID, GroupID: rec.GroupID, Sequence: rec.Sequence, Scheduled: rec.Scheduled, Start: rec.Start, Duration: rec.Duration,
+		Bytes: rec.Bytes, Cost: rec.Cost, StatusCode: rec.StatusCode, ErrorClass: rec.ErrorClass}
+	if rec.Err != "" {
+		r.Err = errors.New(rec.Err)
+	}
+	return r, true, nil
+}
+
+// writeAt writes the given bytes at the given offset, wrapping around the end of the ring
+func (s *spillFile) writeAt(b []byte, off int64) error {
+	n := min(int64(len(b)), s.maxSize-off)
+	if _, err := s.f.WriteAt(b[:n], off); err != nil {
+		return err
+	}
+	if n < int64(len(b)) {
+		_, err := s.f.WriteAt(b[n:], 0)
+		return err
+	}
+	return nil
+}
+
+// readAt reads the given bytes at the given offset, wrapping around the end of the ring
+func (s *spillFile) readAt(b []byte, off int64) error {
+	n := min(int64(len(b)), s.maxSize-off)
+	if _, err := s.f.ReadAt(b[:n], off); err != nil {
+		return err
+	}
+	if n < int64(len(b)) {
+		_, err := s.f.ReadAt(b[n:], 0)
+		return err
+	}
+	return nil
+}
+
+// close closes and removes the file
+func (s *spillFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f.Close()
+	return os.Remove(s.f.Name())
+}
+
+// NumOfDroppedResults returns the number of results dropped by the sink backpressure policy
+func (limiter *Limiter) NumOfDroppedResults() int {
+	sq := runState(limiter, &limiter.sinkQueue)
+	if sq == nil {
+		return 0
+	}
+	return int(sq.dropped.Load())
+}
+
+// NumOfSpilledResults returns the number of results spilled to disk by the sink backpressure policy
+func (limiter *Limiter) NumOfSpilledResults() int {
+	sq := runState(limiter, &limiter.sinkQueue)
+	if sq == nil {
+		return 0
+	}
+	return int(sq.spilled.Load())
+}
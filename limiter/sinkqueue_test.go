@@ -0,0 +1,221 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowSink represents a sink which takes the given delay for every write
+type slowSink struct {
+	mu      sync.Mutex
+	delay   time.Duration
+	results []Result
+}
+
+// Write implements Sink
+func (s *slowSink) Write(r Result) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	s.results = append(s.results, r)
+	s.mu.Unlock()
+	return nil
+}
+
+// Flush implements Sink
+func (s *slowSink) Flush() error {
+	return nil
+}
+
+func TestSinkBackpressure(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Backpressure
+	}{
+		{name: "block", policy: BackpressureBlock},
+		{name: "drop", policy: BackpressureDrop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &slowSink{delay: 5 * time.Millisecond}
+			limiter, err := New(Options{
+				Concurrency:      4,
+				Limit:            40,
+				Sink:             sink,
+				SinkBuffer:       2,
+				SinkBackpressure: tt.policy,
+				Callback:         func(cbp CallbackParams) error { return nil },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			if err := limiter.Run(); err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+
+			// Every result is either written or dropped
+			written, dropped := len(sink.results), limiter.NumOfDroppedResults()
+			if written+dropped != 40 {
+				t.Errorf("got %d written and %d dropped results, want 40 in total", written, dropped)
+			}
+			if got := limiter.Report().DroppedResults; got != dropped {
+				t.Errorf("got %d dropped results in the report, want %d", got, dropped)
+			}
+			switch tt.policy {
+			case BackpressureBlock:
+				// The workers wait for the sink
+				if dropped != 0 || elapsed < 150*time.Millisecond {
+					t.Errorf("got %d dropped results in %s, want none in ~200ms", dropped, elapsed)
+				}
+			case BackpressureDrop:
+				if dropped == 0 {
+					t.Error("got no dropped results, want the slow sink to drop some")
+				}
+			}
+		})
+	}
+}
+
+func TestCollectToDrop(t *testing.T) {
+	ch := make(chan Value[int], 3)
+	callback, dropped := CollectToDrop(func(ctx context.Context, cbp CallbackParams) (int, error) {
+		return cbp.Sequence, nil
+	}, ch)
+	limiter, err := New(Options{Concurrency: 1, Limit: 10, Callback: callback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The channel is never read so the values beyond its buffer are dropped
+	if len(ch) != 3 || dropped() != 7 {
+		t.Errorf("got %d sent and %d dropped values, want 3 and 7", len(ch), dropped())
+	}
+	if v := <-ch; v.Sequence != 1 || v.Value != 1 {
+		t.Errorf("got %+v, want the first value", v)
+	}
+}
+
+func TestSpillFile(t *testing.T) {
+	// The file fits ~3 records so the ring wraps around the end
+	s, err := newSpillFile(t.TempDir(), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	start := time.Unix(1700000000, 0).UTC()
+	seq, next := 0, 0
+	for round := 0; round < 5; round++ {
+		for {
+			r := Result{RunID: "run", GroupID: 1, Sequence: seq, Start: start, Duration: time.Millisecond, Err: errors.New("boom")}
+			if err := s.push(r); err == errSpillFull {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			seq++
+		}
+		if s.n < 2 {
+			t.Fatalf("got %d records in the spill file, want at least 2", s.n)
+		}
+
+		// Pop one record so the next round writes across the end of the file
+		r, ok, err := s.pop()
+		if err != nil || !ok {
+			t.Fatalf("got %v, %v, want a record", ok, err)
+		}
+		if r.Sequence != next || !r.Start.Equal(start) || r.Err == nil || r.Err.Error() != "boom" {
+			t.Fatalf("got %+v, want the record %d", r, next)
+		}
+		next++
+	}
+
+	// The records come back in order and the file is compacted once it's drained
+	for {
+		r, ok, err := s.pop()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			break
+		}
+		if r.Sequence != next {
+			t.Fatalf("got record %d, want %d", r.Sequence, next)
+		}
+		next++
+	}
+	if next != seq {
+		t.Errorf("got %d records, want %d", next, seq)
+	}
+	if fi, err := s.f.Stat(); err != nil || fi.Size() != 0 {
+		t.Errorf("got %v, %v, want an empty file", fi.Size(), err)
+	}
+}
+
+func TestSinkSpill(t *testing.T) {
+	dir := t.TempDir()
+	sink := &slowSink{delay: 2 * time.Millisecond}
+	limiter, err := New(Options{
+		Concurrency:      4,
+		Limit:            100,
+		Sink:             sink,
+		SinkBuffer:       1,
+		SinkBackpressure: BackpressureSpill,
+		SinkSpillDir:     dir,
+		Callback:         func(cbp CallbackParams) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The spilled results are drained by the end of the run and the spill file is removed
+	if got := len(sink.results); got != 100 {
+		t.Errorf("got %d written results, want 100", got)
+	}
+	if limiter.NumOfSpilledResults() == 0 || limiter.NumOfDroppedResults() != 0 {
+		t.Errorf("got %d spilled and %d dropped results, want some spilled and none dropped", limiter.NumOfSpilledResults(), limiter.NumOfDroppedResults())
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("got %d files in the spill dir (%v), want none", len(entries), err)
+	}
+}
+
+func TestSinkSpillFull(t *testing.T) {
+	sink := &slowSink{delay: 5 * time.Millisecond}
+	limiter, err := New(Options{
+		Concurrency:      4,
+		Limit:            100,
+		Sink:             sink,
+		SinkBuffer:       1,
+		SinkBackpressure: BackpressureSpill,
+		SinkSpillDir:     t.TempDir(),
+		SinkSpillMaxSize: 512,
+		Callback:         func(cbp CallbackParams) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The results that don't fit in the spill file are dropped without failing the run
+	written, dropped := len(sink.results), limiter.NumOfDroppedResults()
+	if dropped == 0 || written+dropped != 100 {
+		t.Errorf("got %d written and %d dropped results, want some dropped and 100 in total", written, dropped)
+	}
+}
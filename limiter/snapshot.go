@@ -0,0 +1,103 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// keyedSnapshot represents a snapshot of a keyed limiter
+type keyedSnapshot struct {
+	Version int            `json:"version"`
+	Time    time.Time      `json:"time"`
+	Keys    []keyedStateV1 `json:"keys"`
+}
+
+// keyedStateV1 represents the state of a key in a snapshot
+type keyedStateV1 struct {
+	Key         string     `json:"key"`
+	Tokens      float64    `json:"tokens"`
+	Plan        Plan       `json:"plan"`
+	GrantTokens int        `json:"grant_tokens,omitempty"`
+	GrantExpiry *time.Time `json:"grant_expiry,omitempty"`
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+	Bans        int        `json:"bans,omitempty"`
+}
+
+// Snapshot returns a snapshot of the per key state (tokens, plans, grants and bans) as JSON.
+// It can be restored by Restore, e.g. after a restart, so consumed budgets aren't reset.
+func (kl *KeyedLimiter) Snapshot() ([]byte, error) {
+	now := time.Now()
+	s := keyedSnapshot{Version: 1, Time: now}
+	for _, e := range kl.snapshot() {
+		e.mu.Lock()
+		ks := keyedStateV1{
+			Key:    e.key,
+			Tokens: e.lim.TokensAt(now),
+			Plan:   e.plan,
+			Bans:   e.bans,
+		}
+		if e.grantTokens > 0 && now.Before(e.grantExpiry) {
+			expiry := e.grantExpiry
+			ks.GrantTokens, ks.GrantExpiry = e.grantTokens, &expiry
+		}
+		if until := e.bannedUntil.Load(); until > now.UnixNano() {
+			t := time.Unix(0, until)
+			ks.BannedUntil = &t
+		}
+		e.mu.Unlock()
+		s.Keys = append(s.Keys, ks)
+	}
+	return json.Marshal(s)
+}
+
+// Restore restores the per key state from the given snapshot.
+// Tokens are refilled for the time elapsed since the snapshot was taken.
+// It's meant to be called before the limiter is used since the restored keys are replaced.
+func (kl *KeyedLimiter) Restore(data []byte) error {
+	var s keyedSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	} else if s.Version != 1 {
+		return fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+
+	for _, ks := range s.Keys {
+		plan := kl.withDefaults(ks.Plan)
+		lim := rate.NewLimiter(rate.Limit(plan.QPS), plan.Burst)
+		// Consume the tokens used up to the snapshot time so the limiter refills from there
+		if used := float64(plan.Burst) - ks.Tokens; used > 0 {
+			burst := plan.Burst
+			for n := int(used + 0.5); n > 0; {
+				c := n
+				if c > burst {
+					c = burst
+				}
+				lim.ReserveN(s.Time, c)
+				n -= c
+			}
+		}
+
+		// Replace the entry (if any) with a restored one
+		e := &keyedEntry{key: ks.Key, lim: lim, plan: plan, bans: ks.Bans}
+		if ks.GrantExpiry != nil {
+			e.grantTokens, e.grantExpiry = ks.GrantTokens, *ks.GrantExpiry
+		}
+		if ks.BannedUntil != nil {
+			e.bannedUntil.Store(ks.BannedUntil.UnixNano())
+		}
+		e.touch(time.Now())
+		sh := kl.shard(ks.Key)
+		sh.mu.Lock()
+		sh.entries[ks.Key] = e
+		sh.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+// stateVersion is the version of the state snapshot format
+const stateVersion = 1
+
+// stateClassStats represents the latency statistics of a class in the state snapshot
+type stateClassStats struct {
+	Count int   `json:"count"`
+	P50   int64 `json:"p50_ns"`
+	P90   int64 `json:"p90_ns"`
+	P99   int64 `json:"p99_ns"`
+}
+
+// newStateClassStats returns the state of the given class statistics
+func newStateClassStats(cs ClassStats) stateClassStats {
+	return stateClassStats{Count: cs.Count, P50: int64(cs.P50), P90: int64(cs.P90), P99: int64(cs.P99)}
+}
+
+// stateTimes represents the time accounting in the state snapshot
+type stateTimes struct {
+	Throttled int64  `json:"throttled_ns"`
+	Executing int64  `json:"executing_ns"`
+	Idle      int64  `json:"idle_ns"`
+	Bound     string `json:"bound,omitempty"`
+}
+
+// newStateTimes returns the state of the given time accounting
+func newStateTimes(ta TimeAccounting) stateTimes {
+	return stateTimes{Throttled: int64(ta.Throttled), Executing: int64(ta.Executing), Idle: int64(ta.Idle), Bound: ta.Bound()}
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+// It produces a snapshot of the state of the limiter (the options, the counters, the statistics
+// and the end reason) so the supervising processes can poll and persist it uniformly.
+// The durations are in nanoseconds.
+func (limiter *Limiter) MarshalJSON() ([]byte, error) {
+	type options struct {
+		Concurrency    uint32 `json:"concurrency"`
+		AutoTune       bool   `json:"auto_tune,omitempty"`
+		MaxConcurrency uint32 `json:"max_concurrency,omitempty"`
+		QPS            uint32 `json:"qps,omitempty"`
+		Limit          uint32 `json:"limit,omitempty"`
+		Duration       int64  `json:"duration_ns,omitempty"`
+		BatchSize      uint32 `json:"batch_size,omitempty"`
+		Overdraft      uint32 `json:"overdraft,omitempty"`
+		MaxInFlight    uint32 `json:"max_in_flight,omitempty"`
+		BytesPerSecond uint64 `json:"bytes_per_second,omitempty"`
+		QueryTimeout   int64  `json:"query_timeout_ns,omitempty"`
+	}
+	type counters struct {
+		Queries        int     `json:"queries"`
+		Successes      int     `json:"successes"`
+		Failures       int     `json:"failures"`
+		Timeouts       int     `json:"timeouts"`
+		Skips          int     `json:"skips"`
+		RateErrors     int     `json:"rate_errors"`
+		Overdrafts     int     `json:"overdrafts"`
+		DroppedResults int     `json:"dropped_results"`
+		SpilledResults int     `json:"spilled_results"`
+		Bytes          int64   `json:"bytes"`
+		Cost           float64 `json:"cost"`
+	}
+	type latency struct {
+		Service   stateClassStats `json:"service"`
+		QueueWait stateClassStats `json:"queue_wait"`
+		Response  stateClassStats `json:"response"`
+		Corrected stateClassStats `json:"corrected"`
+	}
+	type stats struct {
+		QPS               float64                 `json:"qps"`
+		SuccessQPS        float64                 `json:"success_qps"`
+		CurrentQPS        float64                 `json:"current_qps"`
+		TargetConcurrency int                     `json:"target_concurrency"`
+		TokensAvailable   *float64                `json:"tokens_available,omitempty"`
+		Remaining         int                     `json:"remaining"`
+		BlackoutTime      int64                   `json:"blackout_time_ns"`
+		Bottleneck        string                  `json:"bottleneck,omitempty"`
+		ResourceWaits     map[string]int64        `json:"resource_waits_ns,omitempty"`
+		Times             stateTimes              `json:"times"`
+		GroupTimes        map[int]stateTimes      `json:"group_times,omitempty"`
+		Latency           *latency                `json:"latency,omitempty"`
+		StatusCodes       map[int]stateClassStats `json:"status_codes,omitempty"`
+	}
+
+	r := limiter.Report()
+	limiter.mu.RLock()
+	start, running := limiter.start, limiter.runID != "" && !limiter.done
+	limiter.mu.RUnlock()
+	v := struct {
+		Version   int      `json:"version"`
+		Time      string   `json:"time"`
+		Name      string   `json:"name,omitempty"`
+		RunID     string   `json:"run_id,omitempty"`
+		Seed      int64    `json:"seed,omitempty"`
+		Running   bool     `json:"running"`
+		Start     string   `json:"start,omitempty"`
+		Since     int64    `json:"since_ns"`
+		Options   options  `json:"options"`
+		Counters  counters `json:"counters"`
+		Stats     stats    `json:"stats"`
+		EndReason string   `json:"end_reason,omitempty"`
+		EndCause  string   `json:"end_cause,omitempty"`
+		LastError string   `json:"last_error,omitempty"`
+	}{
+		Version: stateVersion,
+		Time:    time.Now().Format(time.RFC3339Nano),
+		Name:    r.Name,
+		RunID:   r.RunID,
+		Seed:    r.Seed,
+		Running: running,
+		Options: options{
+			Concurrency:    limiter.concurrency,
+			AutoTune:       limiter.autoTune,
+			MaxConcurrency: limiter.maxConcurrency,
+			QPS:            limiter.qps,
+			Limit:          limiter.limit,
+			Duration:       int64(limiter.duration),
+			BatchSize:      limiter.batchSize,
+			Overdraft:      limiter.overdraft,
+			MaxInFlight:    limiter.maxInFlight,
+			BytesPerSecond: limiter.bytesPerSecond,
+			QueryTimeout:   int64(limiter.queryTimeout),
+		},
+		Counters: counters{
+			Queries:        r.NumOfQueries,
+			Successes:      r.NumOfSuccesses,
+			Failures:       r.NumOfFailures,
+			Timeouts:       r.NumOfTimeouts,
+			Skips:          limiter.NumOfSkips(),
+			RateErrors:     r.RateErrors,
+			Overdrafts:     r.Overdrafts,
+			DroppedResults: r.DroppedResults,
+			SpilledResults: r.SpilledResults,
+			Bytes:          r.NumOfBytes,
+			Cost:           r.TotalCost,
+		},
+		Stats: stats{
+			QPS:               r.QPS,
+			SuccessQPS:        r.SuccessQPS,
+			CurrentQPS:        limiter.CurrentQPS(),
+			TargetConcurrency: int(limiter.targetConcurrency.Load()),
+			Remaining:         limiter.Remaining(),
+			BlackoutTime:      int64(r.BlackoutTime),
+			Bottleneck:        r.Bottleneck,
+			Times:             newStateTimes(r.TimeAccounting),
+		},
+	}
+	if !start.IsZero() {
+		v.Start, v.Since = start.Format(time.RFC3339Nano), int64(r.Since)
+	}
+
+	// Stats
+	// The unlimited tokens are omitted since JSON can't represent the infinity
+	if tokens := limiter.TokensAvailable(); !math.IsInf(tokens, 0) && runState(limiter, &limiter.lim) != nil {
+		v.Stats.TokensAvailable = &tokens
+	}
+	if len(r.ResourceWaits) > 0 {
+		v.Stats.ResourceWaits = make(map[string]int64, len(r.ResourceWaits))
+		for name, d := range r.ResourceWaits {
+			v.Stats.ResourceWaits[name] = int64(d)
+		}
+	}
+	if len(r.GroupTimes) > 0 {
+		v.Stats.GroupTimes = make(map[int]stateTimes, len(r.GroupTimes))
+		for id, ta := range r.GroupTimes {
+			v.Stats.GroupTimes[id] = newStateTimes(ta)
+		}
+	}
+	if runState(limiter, &limiter.latencyStats) != nil {
+		v.Stats.Latency = &latency{
+			Service:   newStateClassStats(r.Latency.Service),
+			QueueWait: newStateClassStats(r.Latency.QueueWait),
+			Response:  newStateClassStats(r.Latency.Response),
+			Corrected: newStateClassStats(r.Latency.Corrected),
+		}
+	}
+	if len(r.Breakdown.StatusCodes) > 0 {
+		v.Stats.StatusCodes = make(map[int]stateClassStats, len(r.Breakdown.StatusCodes))
+		for code, cs := range r.Breakdown.StatusCodes {
+			v.Stats.StatusCodes[code] = newStateClassStats(cs)
+		}
+	}
+
+	// End reason
+	if !running {
+		if cause := limiter.Cause(); cause != nil {
+			v.EndReason, v.EndCause = limiter.endReason(cause), cause.Error()
+		}
+	}
+	if r.LastError != nil {
+		v.LastError = r.LastError.Error()
+	}
+	return json.Marshal(v)
+}
+
+// endReason returns the machine-readable reason of the given end cause
+func (limiter *Limiter) endReason(cause error) string {
+	switch {
+	case errors.Is(cause, ErrQueryLimit):
+		return "query_limit"
+	case errors.Is(cause, ErrDurationLimit):
+		return "duration_limit"
+	case errors.Is(cause, ErrSignal):
+		return "signal"
+	case errors.Is(cause, ErrStopped):
+		return "stopped"
+	case errors.Is(cause, ErrWatchdog):
+		return "watchdog"
+	case limiter.IsRateError():
+		return "rate_error"
+	case limiter.IsCallbackError():
+		return "callback_error"
+	case errors.Is(cause, context.Canceled):
+		return "canceled"
+	}
+	return "error"
+}
@@ -0,0 +1,158 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// state represents the decoded fields of a state snapshot
+type state struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	RunID   string `json:"run_id"`
+	Running bool   `json:"running"`
+	Start   string `json:"start"`
+	Since   int64  `json:"since_ns"`
+	Options struct {
+		Concurrency uint32 `json:"concurrency"`
+		QPS         uint32 `json:"qps"`
+		Limit       uint32 `json:"limit"`
+		Duration    int64  `json:"duration_ns"`
+	} `json:"options"`
+	Counters struct {
+		Queries   int `json:"queries"`
+		Successes int `json:"successes"`
+		Failures  int `json:"failures"`
+	} `json:"counters"`
+	Stats struct {
+		TokensAvailable *float64 `json:"tokens_available"`
+	} `json:"stats"`
+	EndReason string `json:"end_reason"`
+	EndCause  string `json:"end_cause"`
+	LastError string `json:"last_error"`
+}
+
+// decodeState returns the decoded state snapshot of the given limiter
+func decodeState(t *testing.T, limiter *Limiter) state {
+	t.Helper()
+	b, err := json.Marshal(limiter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s state
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMarshalJSONBeforeRun(t *testing.T) {
+	limiter, err := New(Options{Name: "test", Concurrency: 2, QPS: 10, Limit: 5, Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := decodeState(t, limiter)
+	if s.Version != stateVersion || s.Name != "test" || s.Running || s.Start != "" || s.Since != 0 || s.EndReason != "" {
+		t.Errorf("got %+v, want a snapshot of a limiter which never ran", s)
+	}
+	if s.Options.Concurrency != 2 || s.Options.QPS != 10 || s.Options.Limit != 5 {
+		t.Errorf("got options %+v, want the limiter options", s.Options)
+	}
+	if s.Stats.TokensAvailable != nil {
+		t.Errorf("got %v tokens available, want none before the run", *s.Stats.TokensAvailable)
+	}
+}
+
+func TestMarshalJSONDuringRun(t *testing.T) {
+	var once sync.Once
+	var s state
+	limiter, err := New(Options{
+		Concurrency: 1,
+		QPS:         100,
+		Limit:       5,
+		Callback: func(cbp CallbackParams) error {
+			once.Do(func() { s = decodeState(t, cbp.Limiter) })
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Running || s.RunID == "" || s.Start == "" || s.EndReason != "" {
+		t.Errorf("got %+v, want a snapshot of a running limiter", s)
+	}
+	if s.Stats.TokensAvailable == nil {
+		t.Error("got no tokens available, want the tokens of the QPS budget")
+	}
+}
+
+func TestMarshalJSONEndReason(t *testing.T) {
+	errQuery := errors.New("query failed")
+	tests := []struct {
+		name      string
+		options   Options
+		queries   int
+		reason    string
+		lastError string
+	}{
+		{
+			name:    "query limit",
+			options: Options{Concurrency: 1, Limit: 5, Callback: func(cbp CallbackParams) error { return nil }},
+			queries: 5,
+			reason:  "query_limit",
+		},
+		{
+			name:    "duration limit",
+			options: Options{Concurrency: 1, QPS: 100, Duration: 50 * time.Millisecond, Callback: func(cbp CallbackParams) error { return nil }},
+			reason:  "duration_limit",
+		},
+		{
+			name: "stopped",
+			options: Options{Concurrency: 1, QPS: 100, Duration: time.Minute, Callback: func(cbp CallbackParams) error {
+				cbp.Limiter.Stop()
+				return nil
+			}},
+			reason: "stopped",
+		},
+		{
+			name:      "callback error",
+			options:   Options{Concurrency: 1, Limit: 5, Callback: func(cbp CallbackParams) error { return errQuery }},
+			queries:   1,
+			reason:    "callback_error",
+			lastError: errQuery.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := New(tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = limiter.Run()
+			s := decodeState(t, limiter)
+			if s.Running || s.Start == "" || s.Since <= 0 {
+				t.Errorf("got %+v, want a snapshot of a finished run", s)
+			}
+			if s.EndReason != tt.reason || s.EndCause == "" || s.LastError != tt.lastError {
+				t.Errorf("got end reason %q (%s) and last error %q, want %q and %q", s.EndReason, s.EndCause, s.LastError, tt.reason, tt.lastError)
+			}
+			if tt.queries > 0 && s.Counters.Queries != tt.queries {
+				t.Errorf("got %d queries, want %d", s.Counters.Queries, tt.queries)
+			}
+			if s.Counters.Queries != s.Counters.Successes+s.Counters.Failures {
+				t.Errorf("got counters %+v, want the queries to add up", s.Counters)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Store represents a (typically remote) store that holds the token buckets of the keys,
+// so several processes can share the same budgets.
+type Store interface {
+	// Take takes n tokens of the given key from a bucket by the given rate and burst.
+	// It returns whether the tokens were taken and, if not, the duration until they're available.
+	Take(ctx context.Context, key string, n int, qps float64, burst int) (bool, time.Duration, error)
+	// Ping checks the health of the store
+	Ping(ctx context.Context) error
+}
+
+// FailPolicy represents the behavior of a store limiter when its store is slow or down
+type FailPolicy int
+
+const (
+	// FailLocal serves the queries from a local fallback limiter by the same limits
+	FailLocal FailPolicy = iota
+	// FailClosed rejects all the queries
+	FailClosed
+	// FailOpen allows all the queries
+	FailOpen
+)
+
+const (
+	// defaultStoreTimeout is the default timeout of a store call
+	defaultStoreTimeout = 50 * time.Millisecond
+	// defaultHealthInterval is the default interval of the health probes in degraded mode
+	defaultHealthInterval = time.Second
+	// defaultUnhealthyThreshold is the default number of consecutive failures for degraded mode
+	defaultUnhealthyThreshold = 3
+)
+
+// StoreOptions represents the options that can be set when creating a new store limiter
+type StoreOptions struct {
+	// Store is the store
+	Store Store
+	// QPS is the limit for the number of queries per second per key
+	QPS float64
+	// Burst is the burst size per key (default 1)
+	Burst int
+	// FailPolicy is the behavior when the store is slow or down (default FailLocal)
+	FailPolicy FailPolicy
+	// Timeout is the timeout of a store call (default 50ms)
+	Timeout time.Duration
+	// UnhealthyThreshold is the number of consecutive store failures that switch the limiter
+	// to degraded mode, where the store isn't called until a health probe succeeds (default 3)
+	UnhealthyThreshold int
+	// HealthInterval is the interval of the health probes in degraded mode (default 1s)
+	HealthInterval time.Duration
+}
+
+// StoreStats represents the statistics of a store limiter
+type StoreStats struct {
+	// Degraded reports whether the limiter is in degraded mode
+	Degraded bool
+	// DegradedSince is the time the limiter switched to degraded mode
+	DegradedSince time.Time
+	// Errors is the number of store errors (including timeouts)
+	Errors int
+	// Degradations is the number of times the limiter switched to degraded mode
+	Degradations int
+	// Fallbacks is the number of decisions made by the fail policy
+	Fallbacks int
+}
+
+// NewStoreLimiter creates a new store limiter by the given options
+func NewStoreLimiter(o StoreOptions) (*StoreLimiter, error) {
+	if o.Store == nil {
+		return nil, errors.New("store is required")
+	} else if o.Timeout < 0 || o.UnhealthyThreshold < 0 || o.HealthInterval < 0 {
+		return nil, errors.New("timeout, unhealthy threshold and health interval values must be positive")
+	}
+	if o.Burst == 0 {
+		o.Burst = 1
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaultStoreTimeout
+	}
+	if o.UnhealthyThreshold == 0 {
+		o.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+	if o.HealthInterval == 0 {
+		o.HealthInterval = defaultHealthInterval
+	}
+	fallback, err := NewKeyed(KeyedOptions{QPS: o.QPS, Burst: o.Burst})
+	if err != nil {
+		return nil, err
+	}
+	return &StoreLimiter{
+		store:              o.Store,
+		qps:                o.QPS,
+		burst:              o.Burst,
+		failPolicy:         o.FailPolicy,
+		timeout:            o.Timeout,
+		unhealthyThreshold: int64(o.UnhealthyThreshold),
+		healthInterval:     o.HealthInterval,
+		fallback:           fallback,
+		closed:             make(chan struct{}),
+	}, nil
+}
+
+// StoreLimiter represents a keyed limiter backed by a store
+type StoreLimiter struct {
+	store              Store
+	qps                float64
+	burst              int
+	failPolicy         FailPolicy
+	timeout            time.Duration
+	unhealthyThreshold int64
+	healthInterval     time.Duration
+	fallback           *KeyedLimiter
+	failures           atomic.Int64
+	mu                 sync.Mutex
+	degraded           bool
+	degradedSince      time.Time
+	errors             atomic.Int64
+	degradations       atomic.Int64
+	fallbacks          atomic.Int64
+	allowed            atomic.Int64
+	rejected           atomic.Int64
+	closed             chan struct{}
+	closeOnce          sync.Once
+}
+
+// Check reports whether a query for the given key may happen now.
+// If not, it returns the duration until the next token is available.
+func (sl *StoreLimiter) Check(ctx context.Context, key string) (bool, time.Duration) {
+	return sl.CheckN(ctx, key, 1)
+}
+
+// CheckN reports whether n queries for the given key may happen now.
+// If not, it returns the duration until the tokens are available.
+func (sl *StoreLimiter) CheckN(ctx context.Context, key string, n int) (bool, time.Duration) {
+	ok, retryAfter := sl.checkN(ctx, key, n)
+	if ok {
+		sl.allowed.Add(int64(n))
+	} else {
+		sl.rejected.Add(1)
+	}
+	return ok, retryAfter
+}
+
+// checkN makes the decision for CheckN
+func (sl *StoreLimiter) checkN(ctx context.Context, key string, n int) (bool, time.Duration) {
+	if sl.IsDegraded() {
+		return sl.fail(key, n)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sl.timeout)
+	ok, retryAfter, err := sl.store.Take(ctx, key, n, sl.qps, sl.burst)
+	cancel()
+	if err != nil {
+		sl.errors.Add(1)
+		if sl.failures.Add(1) >= sl.unhealthyThreshold {
+			sl.degrade()
+		}
+		return sl.fail(key, n)
+	}
+	sl.failures.Store(0)
+	return ok, retryAfter
+}
+
+// fail makes the decision by the fail policy
+func (sl *StoreLimiter) fail(key string, n int) (bool, time.Duration) {
+	sl.fallbacks.Add(1)
+	switch sl.failPolicy {
+	case FailOpen:
+		return true, 0
+	case FailClosed:
+		return false, sl.healthInterval
+	default:
+		e := sl.fallback.entry(key)
+		now := time.Now()
+		if e.lim.AllowN(now, n) {
+			return true, 0
+		}
+		return false, retryAfter(e.lim, now)
+	}
+}
+
+// degrade switches the limiter to degraded mode and starts the health probes
+func (sl *StoreLimiter) degrade() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.degraded {
+		return
+	}
+	sl.degraded, sl.degradedSince = true, time.Now()
+	sl.degradations.Add(1)
+	go sl.probe()
+}
+
+// probe pings the store every health interval until it succeeds or the limiter is closed
+func (sl *StoreLimiter) probe() {
+	ticker := time.NewTicker(sl.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sl.closed:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), sl.timeout)
+			err := sl.store.Ping(ctx)
+			cancel()
+			if err != nil {
+				sl.errors.Add(1)
+				continue
+			}
+			sl.mu.Lock()
+			sl.degraded = false
+			sl.mu.Unlock()
+			sl.failures.Store(0)
+			return
+		}
+	}
+}
+
+// IsDegraded returns whether the limiter is in degraded mode
+func (sl *StoreLimiter) IsDegraded() bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.degraded
+}
+
+// Allow reports whether a query for the given key may happen now
+func (sl *StoreLimiter) Allow(key string) bool {
+	ok, _ := sl.Check(context.Background(), key)
+	return ok
+}
+
+// Wait blocks until a query for the given key may happen
+func (sl *StoreLimiter) Wait(ctx context.Context, key string) error {
+	return sl.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until n queries for the given key may happen
+func (sl *StoreLimiter) WaitN(ctx context.Context, key string, n int) error {
+	if n > sl.burst {
+		return fmt.Errorf("n %d exceeds burst %d", n, sl.burst)
+	}
+	return waitFor(ctx, func() (bool, time.Duration) { return sl.CheckN(ctx, key, n) })
+}
+
+// Stats returns the statistics
+func (sl *StoreLimiter) Stats() Stats {
+	return Stats{Allowed: int(sl.allowed.Load()), Rejected: int(sl.rejected.Load())}
+}
+
+// StoreStats returns the store statistics
+func (sl *StoreLimiter) StoreStats() StoreStats {
+	sl.mu.Lock()
+	s := StoreStats{Degraded: sl.degraded}
+	if sl.degraded {
+		s.DegradedSince = sl.degradedSince
+	}
+	sl.mu.Unlock()
+	s.Errors = int(sl.errors.Load())
+	s.Degradations = int(sl.degradations.Load())
+	s.Fallbacks = int(sl.fallbacks.Load())
+	return s
+}
+
+// Close stops the health probes
+func (sl *StoreLimiter) Close() {
+	sl.closeOnce.Do(func() { close(sl.closed) })
+}
+
+// MemoryStore represents an in-process store.
+// It's useful for development and tests, and as a reference for store implementations.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+// memoryBucket represents a bucket of a memory store
+type memoryBucket struct {
+	lim    *rate.Limiter
+	refund int
+}
+
+// NewMemoryStore creates a new memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take implements the Store interface
+func (ms *MemoryStore) Take(ctx context.Context, key string, n int, qps float64, burst int) (bool, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return false, 0, err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	b, ok := ms.buckets[key]
+	if !ok {
+		b = &memoryBucket{lim: rate.NewLimiter(rate.Limit(qps), burst)}
+		ms.buckets[key] = b
+	}
+
+	// Refunded tokens are used first
+	if b.refund >= n {
+		b.refund -= n
+		return true, 0, nil
+	}
+	now := time.Now()
+	if b.lim.AllowN(now, n-b.refund) {
+		b.refund = 0
+		return true, 0, nil
+	}
+	return false, retryAfter(b.lim, now), nil
+}
+
+// Refund implements the Refunder interface
+func (ms *MemoryStore) Refund(ctx context.Context, key string, n int, burst int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if b, ok := ms.buckets[key]; ok {
+		if b.refund += n; b.refund > burst {
+			b.refund = burst
+		}
+	}
+	return nil
+}
+
+// Ping implements the Store interface
+func (ms *MemoryStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
@@ -0,0 +1,115 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyStore represents a store which can be taken down
+type flakyStore struct {
+	Store
+	down  atomic.Bool
+	takes atomic.Int64
+}
+
+// Take implements Store
+func (fs *flakyStore) Take(ctx context.Context, key string, n int, qps float64, burst int) (bool, time.Duration, error) {
+	fs.takes.Add(1)
+	if fs.down.Load() {
+		return false, 0, errors.New("store is down")
+	}
+	return fs.Store.Take(ctx, key, n, qps, burst)
+}
+
+// Ping implements Store
+func (fs *flakyStore) Ping(ctx context.Context) error {
+	if fs.down.Load() {
+		return errors.New("store is down")
+	}
+	return fs.Store.Ping(ctx)
+}
+
+func TestStoreFailPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy FailPolicy
+		want   []bool
+	}{
+		{name: "local", policy: FailLocal, want: []bool{true, true, false, false}},
+		{name: "closed", policy: FailClosed, want: []bool{false, false, false, false}},
+		{name: "open", policy: FailOpen, want: []bool{true, true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &flakyStore{Store: NewMemoryStore()}
+			store.down.Store(true)
+			sl, err := NewStoreLimiter(StoreOptions{Store: store, QPS: 0.1, Burst: 2, FailPolicy: tt.policy, HealthInterval: time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(sl.Close)
+
+			// The local fallback enforces the same limits as the store
+			for i, want := range tt.want {
+				if got := sl.Allow("key"); got != want {
+					t.Errorf("query %d: got %v, want %v", i, got, want)
+				}
+			}
+			if s := sl.StoreStats(); s.Fallbacks != len(tt.want) {
+				t.Errorf("got %d fallbacks, want %d", s.Fallbacks, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestStoreDegradedMode(t *testing.T) {
+	store := &flakyStore{Store: NewMemoryStore()}
+	sl, err := NewStoreLimiter(StoreOptions{Store: store, QPS: 1000, Burst: 10, UnhealthyThreshold: 2, HealthInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sl.Close)
+
+	// Consecutive failures switch to degraded mode where the store isn't called
+	store.down.Store(true)
+	sl.Allow("key")
+	if sl.IsDegraded() {
+		t.Fatal("got degraded after a single failure, want the threshold of 2")
+	}
+	sl.Allow("key")
+	if !sl.IsDegraded() {
+		t.Fatal("got healthy after 2 failures, want degraded")
+	}
+	takes := store.takes.Load()
+	for i := 0; i < 5; i++ {
+		sl.Allow("key")
+	}
+	if got := store.takes.Load(); got != takes {
+		t.Errorf("got %d store calls in degraded mode, want 0", got-takes)
+	}
+	s := sl.StoreStats()
+	if s.Errors != 2 || s.Degradations != 1 || s.DegradedSince.IsZero() {
+		t.Errorf("got %+v, want 2 errors and a degradation", s)
+	}
+
+	// A successful health probe restores the store
+	store.down.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for sl.IsDegraded() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sl.IsDegraded() {
+		t.Fatal("got degraded after the store recovered, want healthy")
+	}
+	if !sl.Allow("key") || store.takes.Load() != takes+1 {
+		t.Error("got no store call after the recovery, want the store to be used again")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/time/rate"
+)
+
+// TandemOptions represents the options that can be set when creating a new tandem
+type TandemOptions struct {
+	// QPS is the limit for the number of calls per second
+	QPS float64
+	// Burst is the burst size (default 1)
+	Burst int
+	// MaxInFlight is the limit for the number of calls in flight
+	MaxInFlight int
+}
+
+// NewTandem creates a new tandem by the given options
+func NewTandem(o TandemOptions) (*Tandem, error) {
+	if o.QPS <= 0 || o.MaxInFlight <= 0 {
+		return nil, errors.New("qps and max in-flight values must be greater than zero")
+	} else if o.Burst < 0 {
+		return nil, errors.New("burst value must be positive")
+	}
+	if o.Burst == 0 {
+		o.Burst = 1
+	}
+	return &Tandem{
+		lim:   rate.NewLimiter(rate.Limit(o.QPS), o.Burst),
+		slots: make(chan struct{}, o.MaxInFlight),
+	}, nil
+}
+
+// Tandem represents a primitive which dispatches a call only when both a rate token and a
+// concurrency slot are available, so a slow downstream can't pile up in-flight calls.
+type Tandem struct {
+	lim   *rate.Limiter
+	slots chan struct{}
+}
+
+// Acquire blocks until a concurrency slot and a rate token are available.
+// The slot is taken first so tokens aren't spent while waiting for a slot.
+// The returned function releases the slot and must be called once the call returns.
+func (t *Tandem) Acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := t.lim.Wait(ctx); err != nil {
+		<-t.slots
+		return nil, rateWaitError(ctx, t.lim, 1, err)
+	}
+	released := false
+	return func() {
+		if !released {
+			released = true
+			<-t.slots
+		}
+	}, nil
+}
+
+// Do calls the given function once a concurrency slot and a rate token are available,
+// and releases the slot when it returns
+func (t *Tandem) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	release, err := t.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(ctx)
+}
+
+// InFlight returns the number of calls in flight
+func (t *Tandem) InFlight() int {
+	return len(t.slots)
+}
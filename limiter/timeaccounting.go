@@ -0,0 +1,83 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"time"
+)
+
+// TimeAccounting represents the cumulative time the workers spent by activity.
+// It's updated at every query, so the time of the queries in flight isn't included yet.
+type TimeAccounting struct {
+	// Throttled is the time spent waiting for the limiter (the tokens, the in-flight slots, the
+	// cost budget, the schedule, the blackout windows and the staggered start)
+	Throttled time.Duration
+	// Executing is the time spent executing the callbacks
+	Executing time.Duration
+	// Idle is the rest of the time of the workers (e.g. the gate, the sink and the bookkeeping)
+	Idle time.Duration
+}
+
+// Bound returns what the throughput is bound by: "limiter" when the workers mostly waited for the
+// limiter, "target" when they mostly executed the callbacks and "idle" otherwise.
+// It's empty if no time is accounted.
+func (ta TimeAccounting) Bound() string {
+	switch {
+	case ta.Throttled == 0 && ta.Executing == 0 && ta.Idle == 0:
+		return ""
+	case ta.Throttled >= ta.Executing && ta.Throttled >= ta.Idle:
+		return "limiter"
+	case ta.Executing >= ta.Idle:
+		return "target"
+	}
+	return "idle"
+}
+
+// account accounts the time since the given mark as active for the given group id and returns the new mark
+func (limiter *Limiter) account(i int, mark time.Time) time.Time {
+	now := time.Now()
+	d := int64(now.Sub(mark))
+	limiter.counters[i].active.Add(d)
+	return now
+}
+
+// accountThrottled accounts the time since the given start as throttled for the given group id.
+// The zero start is ignored.
+func (limiter *Limiter) accountThrottled(i int, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	d := int64(time.Since(start))
+	limiter.counters[i].throttled.Add(d)
+}
+
+// accountExecuting accounts the given callback duration for the given group id
+func (limiter *Limiter) accountExecuting(i int, d time.Duration) {
+	limiter.counters[i].executing.Add(int64(d))
+}
+
+// TimeAccountingByGroupID returns the time accounting by the given group id (0 for the total)
+func (limiter *Limiter) TimeAccountingByGroupID(id int) TimeAccounting {
+	ta := TimeAccounting{
+		Throttled: time.Duration(limiter.counter(id, func(c *groupCounters) int64 { return c.throttled.Load() })),
+		Executing: time.Duration(limiter.counter(id, func(c *groupCounters) int64 { return c.executing.Load() })),
+	}
+	active := time.Duration(limiter.counter(id, func(c *groupCounters) int64 { return c.active.Load() }))
+	ta.Idle = max(active-ta.Throttled-ta.Executing, 0)
+	return ta
+}
+
+// GroupTimes returns the time accounting of the groups which ran by group id
+func (limiter *Limiter) GroupTimes() map[int]TimeAccounting {
+	times := make(map[int]TimeAccounting)
+	counters := limiter.groups()
+	for id := 1; id < len(counters); id++ {
+		if counters[id].active.Load() > 0 {
+			times[id] = limiter.TimeAccountingByGroupID(id)
+		}
+	}
+	return times
+}
@@ -0,0 +1,180 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// timelineMagic is the magic number of the timeline files
+	timelineMagic = "GRTL"
+	// timelineVersion is the version of the timeline format
+	timelineVersion = 1
+	// timelineRecordSize is the size of a timeline record
+	timelineRecordSize = 36
+	// timelineFailed is the flag of the failed queries
+	timelineFailed = 1 << 0
+)
+
+// errInvalidTimeline is returned when the timeline header doesn't match
+var errInvalidTimeline = errors.New("invalid timeline")
+
+// TimelineEntry represents the timeline of a query
+type TimelineEntry struct {
+	// Sequence is the sequence number of the query
+	Sequence int
+	// GroupID is the id for the concurrency group
+	GroupID int
+	// Scheduled is the intended start time of the query (zero if it's unknown)
+	Scheduled time.Time
+	// Start is the start time of the callback
+	Start time.Time
+	// Finish is the finish time of the callback
+	Finish time.Time
+	// StatusCode is the status code reported by the callback
+	StatusCode int
+	// Failed is whether the callback failed
+	Failed bool
+}
+
+// TimelineSink represents a sink that writes the timeline of the queries in a compact binary
+// format for offline visualization (e.g. flame or scatter charts). Every query is a fixed size
+// little-endian record after the "GRTL" magic and the version byte (see ReadTimeline).
+type TimelineSink struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	header bool
+	buf    [timelineRecordSize]byte
+}
+
+// NewTimelineSink creates a new timeline sink by the given writer
+func NewTimelineSink(w io.Writer) *TimelineSink {
+	return &TimelineSink{w: bufio.NewWriter(w)}
+}
+
+// NewTimelineFileSink creates a new timeline sink by the given file name.
+// The file is created or truncated, and it should be closed by calling the Close method.
+func NewTimelineFileSink(name string) (*TimelineSink, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	sink := NewTimelineSink(f)
+	sink.closer = f
+	return sink, nil
+}
+
+// Write writes the given result
+func (sink *TimelineSink) Write(r Result) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if err := sink.writeHeader(); err != nil {
+		return err
+	}
+	b := sink.buf[:]
+	binary.LittleEndian.PutUint32(b[0:], uint32(r.Sequence))
+	binary.LittleEndian.PutUint32(b[4:], uint32(r.GroupID))
+	binary.LittleEndian.PutUint64(b[8:], uint64(unixNano(r.Scheduled)))
+	binary.LittleEndian.PutUint64(b[16:], uint64(unixNano(r.Start)))
+	binary.LittleEndian.PutUint64(b[24:], uint64(unixNano(r.Start.Add(r.Duration))))
+	binary.LittleEndian.PutUint16(b[32:], uint16(r.StatusCode))
+	var flags uint16
+	if r.Err != nil {
+		flags |= timelineFailed
+	}
+	binary.LittleEndian.PutUint16(b[34:], flags)
+	_, err := sink.w.Write(b)
+	return err
+}
+
+// writeHeader writes the header unless it's written before
+func (sink *TimelineSink) writeHeader() error {
+	if sink.header {
+		return nil
+	}
+	sink.header = true
+	if _, err := sink.w.WriteString(timelineMagic); err != nil {
+		return err
+	}
+	return sink.w.WriteByte(timelineVersion)
+}
+
+// Flush flushes the buffered results
+func (sink *TimelineSink) Flush() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if err := sink.writeHeader(); err != nil {
+		return err
+	}
+	return sink.w.Flush()
+}
+
+// Close flushes the buffered results and closes the underlying file (if any)
+func (sink *TimelineSink) Close() error {
+	if err := sink.Flush(); err != nil {
+		return err
+	}
+	if sink.closer != nil {
+		return sink.closer.Close()
+	}
+	return nil
+}
+
+// ReadTimeline reads the timeline written by a timeline sink and invokes the given function
+// for every entry in the written order
+func ReadTimeline(r io.Reader, fn func(e TimelineEntry) error) error {
+	br := bufio.NewReader(r)
+	var header [len(timelineMagic) + 1]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return errInvalidTimeline
+	} else if string(header[:len(timelineMagic)]) != timelineMagic || header[len(timelineMagic)] != timelineVersion {
+		return errInvalidTimeline
+	}
+	var b [timelineRecordSize]byte
+	for {
+		if _, err := io.ReadFull(br, b[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		e := TimelineEntry{
+			Sequence:   int(binary.LittleEndian.Uint32(b[0:])),
+			GroupID:    int(binary.LittleEndian.Uint32(b[4:])),
+			Scheduled:  fromUnixNano(int64(binary.LittleEndian.Uint64(b[8:]))),
+			Start:      fromUnixNano(int64(binary.LittleEndian.Uint64(b[16:]))),
+			Finish:     fromUnixNano(int64(binary.LittleEndian.Uint64(b[24:]))),
+			StatusCode: int(binary.LittleEndian.Uint16(b[32:])),
+			Failed:     binary.LittleEndian.Uint16(b[34:])&timelineFailed != 0,
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// unixNano returns the given time as unix nanoseconds (zero for the zero time)
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano returns the time of the given unix nanoseconds (the zero time for zero)
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,216 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultNTPServer is the default NTP server
+	defaultNTPServer = "pool.ntp.org"
+	// defaultNTPSamples is the default number of the offset samples of a sync
+	defaultNTPSamples = 4
+	// defaultNTPTimeout is the default timeout of an NTP query
+	defaultNTPTimeout = 2 * time.Second
+	// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the unix epoch (1970)
+	ntpEpochOffset = 2208988800
+	// ntpPacketSize is the size of an NTP packet
+	ntpPacketSize = 48
+)
+
+// TimeSource represents a source of the wall clock time
+type TimeSource interface {
+	// Now returns the current time
+	Now() time.Time
+}
+
+// NTPOptions represents the options that can be set when creating a new NTP time source
+type NTPOptions struct {
+	// Server is the address of the NTP server (default "pool.ntp.org", the port defaults to 123)
+	Server string
+	// Samples is the number of the queries of a sync (default 4).
+	// The offset of the query with the shortest round trip is used since it's the least skewed.
+	Samples int
+	// Timeout is the timeout of a query (default 2s)
+	Timeout time.Duration
+}
+
+// NTPTimeSource represents a time source which corrects the local clock by its offset measured
+// against an NTP server (SNTP), so the start times of the runs on multiple hosts line up within
+// a few milliseconds regardless of how well their clocks are synchronized
+type NTPTimeSource struct {
+	server  string
+	samples int
+	timeout time.Duration
+	mu      sync.RWMutex
+	offset  time.Duration
+	delay   time.Duration
+	synced  time.Time
+}
+
+// NewNTPTimeSource creates a new NTP time source by the given options and syncs it
+func NewNTPTimeSource(ctx context.Context, o NTPOptions) (*NTPTimeSource, error) {
+	if o.Samples < 0 {
+		return nil, errors.New("samples value must be positive")
+	} else if o.Timeout < 0 {
+		return nil, errors.New("timeout value must be positive")
+	}
+	ts := &NTPTimeSource{server: o.Server, samples: o.Samples, timeout: o.Timeout}
+	if ts.server == "" {
+		ts.server = defaultNTPServer
+	}
+	if _, _, err := net.SplitHostPort(ts.server); err != nil {
+		ts.server = net.JoinHostPort(ts.server, "123")
+	}
+	if ts.samples == 0 {
+		ts.samples = defaultNTPSamples
+	}
+	if ts.timeout == 0 {
+		ts.timeout = defaultNTPTimeout
+	}
+	if err := ts.Sync(ctx); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Sync measures the offset of the local clock again.
+// The previous offset is kept if all the queries fail.
+func (ts *NTPTimeSource) Sync(ctx context.Context) error {
+	var offset, delay time.Duration
+	var err error
+	ok := false
+	for i := 0; i < ts.samples; i++ {
+		o, d, qerr := ts.query(ctx)
+		if qerr != nil {
+			err = qerr
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if !ok || d < delay {
+			offset, delay, ok = o, d, true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("failed to sync with ntp server %s: %w", ts.server, err)
+	}
+	ts.mu.Lock()
+	ts.offset, ts.delay, ts.synced = offset, delay, time.Now()
+	ts.mu.Unlock()
+	return nil
+}
+
+// query queries the server and returns the offset of the local clock and the round trip delay
+func (ts *NTPTimeSource) query(ctx context.Context) (offset, delay time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, ts.timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", ts.server)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Request
+	// The transmit timestamp is echoed as the origin timestamp so the response can be matched
+	var req, res [ntpPacketSize]byte
+	req[0] = 0x23 // LI 0, version 4, mode 3 (client)
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(t1))
+	if _, err := conn.Write(req[:]); err != nil {
+		return 0, 0, err
+	}
+	n, err := conn.Read(res[:])
+	if err != nil {
+		return 0, 0, err
+	}
+	t4 := t1.Add(time.Since(t1)) // monotonic
+
+	// Response
+	switch {
+	case n < ntpPacketSize:
+		return 0, 0, errors.New("short ntp response")
+	case res[0]&0x07 != 4:
+		return 0, 0, errors.New("invalid ntp response mode")
+	case res[1] == 0:
+		return 0, 0, errors.New("ntp server sent kiss-of-death")
+	case binary.BigEndian.Uint64(res[24:]) != binary.BigEndian.Uint64(req[40:]):
+		return 0, 0, errors.New("ntp response doesn't match the request")
+	}
+	t2 := fromNTPTime(binary.BigEndian.Uint64(res[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(res[40:]))
+	offset = (t2.Sub(t1) + t3.Sub(t4)) / 2
+	delay = t4.Sub(t1) - t3.Sub(t2)
+	return offset, max(delay, 0), nil
+}
+
+// toNTPTime returns the given time as an NTP timestamp
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+// fromNTPTime returns the time of the given NTP timestamp
+func fromNTPTime(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nanos := (v & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(secs, int64(nanos))
+}
+
+// Now returns the current time corrected by the offset
+func (ts *NTPTimeSource) Now() time.Time {
+	return time.Now().Add(ts.Offset())
+}
+
+// Offset returns the measured offset of the local clock (positive when it's behind the server)
+func (ts *NTPTimeSource) Offset() time.Duration {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.offset
+}
+
+// Delay returns the round trip delay of the query the offset was measured by.
+// The offset is accurate within half of it.
+func (ts *NTPTimeSource) Delay() time.Duration {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.delay
+}
+
+// Synced returns the time of the last successful sync
+func (ts *NTPTimeSource) Synced() time.Time {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.synced
+}
+
+// startDelay returns the time until the start time by the time source (default the local clock),
+// or zero if the start time isn't set or it's passed
+func (limiter *Limiter) startDelay() time.Duration {
+	if limiter.startAt.IsZero() {
+		return 0
+	}
+	now := time.Now
+	if limiter.timeSource != nil {
+		now = limiter.timeSource.Now
+	}
+	return max(limiter.startAt.Sub(now()), 0)
+}
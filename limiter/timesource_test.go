@@ -0,0 +1,127 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartAtGetters(t *testing.T) {
+	startAt := time.Now().Add(300 * time.Millisecond)
+	var first atomic.Int64
+	limiter, err := New(Options{Concurrency: 2, QPS: 100, Duration: 100 * time.Millisecond, StartAt: startAt, Callback: func(cbp CallbackParams) error {
+		first.CompareAndSwap(0, time.Now().UnixNano())
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- limiter.Run() }()
+
+	// The getters are called while the run waits for the start time
+	time.Sleep(100 * time.Millisecond)
+	if since := limiter.Since(); since != 0 {
+		t.Errorf("got since %s while waiting, want zero", since)
+	}
+	if n := limiter.NumOfQueries() + limiter.NumOfQueriesByGroupID(1) + limiter.NumOfSuccesses() + limiter.NumOfFailures(); n != 0 {
+		t.Errorf("got %d queries while waiting, want zero", n)
+	}
+	if p := limiter.Progress(); p != 0 {
+		t.Errorf("got progress %.1f while waiting, want zero", p)
+	}
+	limiter.ETA()
+	limiter.Remaining()
+	limiter.TimeAccountingByGroupID(0)
+	if r := limiter.Report(); r.Since != 0 || r.QPS != 0 {
+		t.Errorf("got since %s and qps %.1f while waiting, want zero", r.Since, r.QPS)
+	}
+	if _, err := json.Marshal(limiter); err != nil {
+		t.Error(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if f := time.Unix(0, first.Load()); f.Before(startAt) {
+		t.Errorf("got the first query %s before the start time", startAt.Sub(f))
+	}
+	if n := limiter.NumOfQueries(); n == 0 {
+		t.Error("got no queries after the start time")
+	}
+}
+
+// skewedTimeSource represents a time source which is ahead of the local clock
+type skewedTimeSource time.Duration
+
+// Now implements the TimeSource interface
+func (ts skewedTimeSource) Now() time.Time {
+	return time.Now().Add(time.Duration(ts))
+}
+
+func TestStartAtTimeSource(t *testing.T) {
+	// The start time is reached 200ms earlier by the time source
+	start := time.Now()
+	limiter, err := New(Options{Concurrency: 1, Limit: 1, StartAt: start.Add(300 * time.Millisecond), TimeSource: skewedTimeSource(200 * time.Millisecond), Callback: func(cbp CallbackParams) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > 250*time.Millisecond {
+		t.Errorf("got the run done in %s, want about 100ms", d)
+	}
+}
+
+func TestNTPTimeSource(t *testing.T) {
+	// The fake server is 1.5s ahead of the local clock
+	const skew = 1500 * time.Millisecond
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	go func() {
+		var req, res [ntpPacketSize]byte
+		for {
+			_, addr, err := conn.ReadFrom(req[:])
+			if err != nil {
+				return
+			}
+			res[0], res[1] = 0x24, 2 // version 4, mode 4 (server), stratum 2
+			copy(res[24:32], req[40:48])
+			now := toNTPTime(time.Now().Add(skew))
+			binary.BigEndian.PutUint64(res[32:], now)
+			binary.BigEndian.PutUint64(res[40:], now)
+			conn.WriteTo(res[:], addr)
+		}
+	}()
+
+	ts, err := NewNTPTimeSource(context.Background(), NTPOptions{Server: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := ts.Offset() - skew; d < -10*time.Millisecond || d > 10*time.Millisecond {
+		t.Errorf("got offset %s, want %s", ts.Offset(), skew)
+	}
+	if ts.Synced().IsZero() {
+		t.Error("got zero sync time")
+	}
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Now()
+	if d := fromNTPTime(toNTPTime(now)).Sub(now); d < -time.Microsecond || d > time.Microsecond {
+		t.Errorf("got %s difference after the round trip, want less than 1µs", d)
+	}
+}
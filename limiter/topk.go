@@ -0,0 +1,182 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTopKWindow is the default window of the top-K tracking
+	defaultTopKWindow = 5 * time.Minute
+	// topKBuckets is the number of buckets the top-K window is split into
+	topKBuckets = 5
+)
+
+// HeavyHitter represents a key that consumed the most tokens
+type HeavyHitter struct {
+	// Key is the key
+	Key string
+	// Count is the estimated number of tokens consumed (an upper bound)
+	Count int64
+	// Error is the max overestimation of the count
+	Error int64
+}
+
+// topK tracks the heavy hitters over a sliding window by keeping a Space-Saving
+// summary of a fixed capacity per bucket, so its memory doesn't grow with the number of keys.
+type topK struct {
+	mu        sync.Mutex
+	capacity  int
+	bucketLen time.Duration
+	window    time.Duration
+	buckets   []*topKBucket
+}
+
+// topKBucket represents a bucket of the window
+type topKBucket struct {
+	start time.Time
+	ss    *spaceSaving
+}
+
+// newTopK creates a new top-K tracker by the given capacity and window
+func newTopK(capacity int, window time.Duration) *topK {
+	return &topK{capacity: capacity, window: window, bucketLen: window / topKBuckets}
+}
+
+// add adds the given count for the given key
+func (t *topK) add(key string, n int64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	start := now.Truncate(t.bucketLen)
+	if l := len(t.buckets); l == 0 || !t.buckets[l-1].start.Equal(start) {
+		t.buckets = append(t.buckets, &topKBucket{start: start, ss: newSpaceSaving(t.capacity)})
+		t.expire(now)
+	}
+	t.buckets[len(t.buckets)-1].ss.add(key, n)
+}
+
+// expire drops the buckets out of the window
+func (t *topK) expire(now time.Time) {
+	i := 0
+	for i < len(t.buckets) && now.Sub(t.buckets[i].start) >= t.window+t.bucketLen {
+		i++
+	}
+	t.buckets = t.buckets[i:]
+}
+
+// top returns the top n heavy hitters of the window
+func (t *topK) top(n int, now time.Time) []HeavyHitter {
+	t.mu.Lock()
+	t.expire(now)
+	merged := make(map[string]*HeavyHitter)
+	for _, b := range t.buckets {
+		for _, item := range b.ss.items {
+			hh, ok := merged[item.key]
+			if !ok {
+				hh = &HeavyHitter{Key: item.key}
+				merged[item.key] = hh
+			}
+			hh.Count += item.count
+			hh.Error += item.err
+		}
+	}
+	t.mu.Unlock()
+
+	hhs := make([]HeavyHitter, 0, len(merged))
+	for _, hh := range merged {
+		hhs = append(hhs, *hh)
+	}
+	sort.Slice(hhs, func(i, j int) bool {
+		if hhs[i].Count != hhs[j].Count {
+			return hhs[i].Count > hhs[j].Count
+		}
+		return hhs[i].Key < hhs[j].Key
+	})
+	if n > 0 && len(hhs) > n {
+		hhs = hhs[:n]
+	}
+	return hhs
+}
+
+// spaceSaving represents a Space-Saving summary (Metwally et al.).
+// When it's full, a new key replaces the key with the min count and inherits that count as its error.
+type spaceSaving struct {
+	capacity int
+	items    map[string]*ssItem
+	heap     ssHeap
+}
+
+// ssItem represents a counter of the summary
+type ssItem struct {
+	key   string
+	count int64
+	err   int64
+	index int
+}
+
+// newSpaceSaving creates a new summary by the given capacity
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, items: make(map[string]*ssItem, capacity)}
+}
+
+// add adds the given count for the given key
+func (ss *spaceSaving) add(key string, n int64) {
+	if item, ok := ss.items[key]; ok {
+		item.count += n
+		heap.Fix(&ss.heap, item.index)
+		return
+	}
+	if len(ss.items) < ss.capacity {
+		item := &ssItem{key: key, count: n}
+		ss.items[key] = item
+		heap.Push(&ss.heap, item)
+		return
+	}
+	// Replace the min
+	item := ss.heap[0]
+	delete(ss.items, item.key)
+	item.key, item.err = key, item.count
+	item.count += n
+	ss.items[key] = item
+	heap.Fix(&ss.heap, 0)
+}
+
+// ssHeap represents a min-heap of the summary counters
+type ssHeap []*ssItem
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ssHeap) Push(x interface{}) {
+	item := x.(*ssItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *ssHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// TopK returns the top n keys that consumed the most tokens within the top-K window.
+// It returns nil if the top-K tracking is disabled.
+func (kl *KeyedLimiter) TopK(n int) []HeavyHitter {
+	if kl.topK == nil {
+		return nil
+	}
+	return kl.topK.top(n, time.Now())
+}
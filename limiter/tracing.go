@@ -0,0 +1,29 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+)
+
+// Tracer represents a tracer that creates spans for the limiter runs and queries.
+// It can be implemented as a thin adapter over any distributed tracing library.
+type Tracer interface {
+	// StartSpan starts a span by the given name as a child of the span in the given context
+	// and returns the context that carries the new span and the function that ends it.
+	StartSpan(ctx context.Context, name string, attrs ...SpanAttribute) (context.Context, EndSpanFunc)
+}
+
+// EndSpanFunc represents the function that ends a span with the given error (nil on success)
+type EndSpanFunc func(err error)
+
+// SpanAttribute represents a span attribute
+type SpanAttribute struct {
+	// Key is the attribute key
+	Key string
+	// Value is the attribute value
+	Value interface{}
+}
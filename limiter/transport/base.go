@@ -0,0 +1,119 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Protocol represents the HTTP protocol of a base transport
+type Protocol string
+
+const (
+	// ProtocolAuto negotiates HTTP/2 over TLS and falls back to HTTP/1.1
+	ProtocolAuto Protocol = ""
+	// ProtocolHTTP1 forces HTTP/1.1
+	ProtocolHTTP1 Protocol = "http1"
+	// ProtocolHTTP2 forces HTTP/2 over TLS
+	ProtocolHTTP2 Protocol = "http2"
+	// ProtocolH2C forces HTTP/2 over cleartext TCP with prior knowledge (h2c)
+	ProtocolH2C Protocol = "h2c"
+)
+
+// BaseOptions represents the options that can be set when creating a new base transport
+type BaseOptions struct {
+	// UnixSocket is the path of the unix domain socket all the connections are made to (optional).
+	// The host of the request url is still used for the Host header.
+	UnixSocket string
+	// Protocol is the HTTP protocol (default ProtocolAuto)
+	Protocol Protocol
+	// TLS is the TLS options
+	TLS TLSOptions
+	// Proxy is the url of the proxy (default the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables)
+	Proxy string
+	// MaxIdleConns is the max number of idle connections across all hosts (default 100)
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the max number of idle connections per host (default 2).
+	// It should be at least the concurrency level, otherwise connections are closed and
+	// reopened between the requests and the connection setup distorts the results.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost is the max number of connections per host, including the ones in use (optional)
+	MaxConnsPerHost int
+	// IdleConnTimeout is the max time an idle connection is kept (default 90s)
+	IdleConnTimeout time.Duration
+	// DisableKeepAlives disables the connection reuse
+	DisableKeepAlives bool
+	// DisableCompression disables the transparent gzip compression
+	DisableCompression bool
+}
+
+// NewBase creates a new base transport by the given options.
+// It starts from the settings of http.DefaultTransport.
+func NewBase(o BaseOptions) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	tc, err := o.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tc
+
+	// Proxy
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %s", err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	// Connection pool
+	if o.MaxIdleConns < 0 || o.MaxIdleConnsPerHost < 0 || o.MaxConnsPerHost < 0 || o.IdleConnTimeout < 0 {
+		return nil, errors.New("connection pool values must be positive")
+	}
+	if o.MaxIdleConns > 0 {
+		t.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	if o.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = o.IdleConnTimeout
+	}
+	t.MaxConnsPerHost = o.MaxConnsPerHost
+	t.DisableKeepAlives = o.DisableKeepAlives
+	t.DisableCompression = o.DisableCompression
+
+	// Connections
+	if o.UnixSocket != "" {
+		path := o.UnixSocket
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+	}
+
+	var p http.Protocols
+	switch o.Protocol {
+	case ProtocolAuto:
+		return t, nil
+	case ProtocolHTTP1:
+		p.SetHTTP1(true)
+	case ProtocolHTTP2:
+		p.SetHTTP2(true)
+	case ProtocolH2C:
+		p.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("invalid protocol %q", o.Protocol)
+	}
+	t.Protocols = &p
+	return t, nil
+}
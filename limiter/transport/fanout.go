@@ -0,0 +1,221 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFanOutTTL is the default time the resolved addresses of a host are used for
+	defaultFanOutTTL = time.Minute
+	// fanOutResolveTimeout is the timeout of resolving the addresses of a host
+	fanOutResolveTimeout = 5 * time.Second
+)
+
+// FanOutOptions represents the options that can be set when creating a new fan-out transport
+type FanOutOptions struct {
+	// Base is the options of the per address transports (UnixSocket and Proxy aren't supported)
+	Base BaseOptions
+	// Pin pins all the requests to the given IP address instead of the resolved ones (optional)
+	Pin string
+	// Resolver is the resolver (default net.DefaultResolver)
+	Resolver *net.Resolver
+	// TTL is the time the resolved addresses of a host are used before they're resolved again (default 1m)
+	TTL time.Duration
+}
+
+// IPStats represents the statistics of an address
+type IPStats struct {
+	// Requests is the number of requests
+	Requests int
+	// Errors is the number of requests that failed without a response
+	Errors int
+	// Statuses is the number of responses by status code
+	Statuses map[int]int
+}
+
+// NewFanOut creates a new fan-out transport by the given options
+func NewFanOut(o FanOutOptions) (*FanOut, error) {
+	if o.Base.UnixSocket != "" || o.Base.Proxy != "" {
+		return nil, errors.New("fan-out can't be used with a unix socket or a proxy")
+	}
+	if o.Pin != "" && net.ParseIP(o.Pin) == nil {
+		return nil, fmt.Errorf("invalid pin address %q", o.Pin)
+	}
+	// Check the base options once so the per address transports can't fail
+	if _, err := NewBase(o.Base); err != nil {
+		return nil, err
+	}
+	if o.TTL < 0 {
+		return nil, errors.New("ttl value must not be negative")
+	}
+	if o.Resolver == nil {
+		o.Resolver = net.DefaultResolver
+	}
+	if o.TTL == 0 {
+		o.TTL = defaultFanOutTTL
+	}
+	return &FanOut{
+		base:       o.Base,
+		pin:        o.Pin,
+		lookup:     o.Resolver.LookupIPAddr,
+		ttl:        o.TTL,
+		hosts:      make(map[string]*fanOutHost),
+		transports: make(map[string]*http.Transport),
+		stats:      make(map[string]*IPStats),
+	}, nil
+}
+
+// FanOut represents a round tripper that resolves all the A/AAAA records of the destination
+// hosts and distributes the requests across the addresses in round robin order, which matters
+// when the target is behind DNS based load balancing. Every address has its own connection pool
+// while the request url (and so the Host header and the TLS server name) is kept.
+// The records are resolved again once the TTL expires, and the failed resolutions are retried by
+// the next request (the last resolved addresses are kept meanwhile).
+type FanOut struct {
+	base       BaseOptions
+	pin        string
+	lookup     func(ctx context.Context, host string) ([]net.IPAddr, error)
+	ttl        time.Duration
+	mu         sync.Mutex
+	hosts      map[string]*fanOutHost
+	transports map[string]*http.Transport
+	stats      map[string]*IPStats
+}
+
+// fanOutHost represents the resolved addresses of a host
+type fanOutHost struct {
+	mu       sync.Mutex
+	addrs    []string
+	resolved time.Time
+	next     int
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (f *FanOut) RoundTrip(req *http.Request) (*http.Response, error) {
+	ip, err := f.pick(req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	res, err := f.transport(net.JoinHostPort(ip, port)).RoundTrip(req)
+
+	f.mu.Lock()
+	s, ok := f.stats[ip]
+	if !ok {
+		s = &IPStats{Statuses: make(map[int]int)}
+		f.stats[ip] = s
+	}
+	s.Requests++
+	if err != nil {
+		s.Errors++
+	} else {
+		s.Statuses[res.StatusCode]++
+	}
+	f.mu.Unlock()
+	return res, err
+}
+
+// pick returns the address of the next request to the given host
+func (f *FanOut) pick(host string) (string, error) {
+	if f.pin != "" {
+		return f.pin, nil
+	}
+	f.mu.Lock()
+	h, ok := f.hosts[host]
+	if !ok {
+		h = &fanOutHost{}
+		f.hosts[host] = h
+	}
+	f.mu.Unlock()
+
+	// The lookup isn't tied to the request so a canceled request doesn't fail the others
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.addrs == nil || time.Since(h.resolved) >= f.ttl {
+		addrs, err := f.resolve(host)
+		if err != nil && h.addrs == nil {
+			return "", err
+		} else if err == nil {
+			h.addrs, h.resolved = addrs, time.Now()
+		}
+	}
+	ip := h.addrs[h.next%len(h.addrs)]
+	h.next++
+	return ip, nil
+}
+
+// resolve resolves the addresses of the given host
+func (f *FanOut) resolve(host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fanOutResolveTimeout)
+	defer cancel()
+	ips, err := f.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, a := range ips {
+		addrs = append(addrs, a.IP.String())
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	return addrs, nil
+}
+
+// transport returns the transport of the given address
+func (f *FanOut) transport(addr string) *http.Transport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.transports[addr]
+	if !ok {
+		t, _ = NewBase(f.base)
+		t.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+		f.transports[addr] = t
+	}
+	return t
+}
+
+// Stats returns the statistics by address
+func (f *FanOut) Stats() map[string]IPStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := make(map[string]IPStats, len(f.stats))
+	for ip, s := range f.stats {
+		c := *s
+		c.Statuses = make(map[int]int, len(s.Statuses))
+		for code, n := range s.Statuses {
+			c.Statuses[code] = n
+		}
+		stats[ip] = c
+	}
+	return stats
+}
+
+// CloseIdleConnections closes the idle connections of all the addresses
+func (f *FanOut) CloseIdleConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.transports {
+		t.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLookup represents a lookup function whose results are set by the test
+type fakeLookup struct {
+	mu    sync.Mutex
+	ips   []string
+	err   error
+	calls int
+}
+
+func (fl *fakeLookup) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.calls++
+	if fl.err != nil {
+		return nil, fl.err
+	}
+	addrs := make([]net.IPAddr, len(fl.ips))
+	for i, ip := range fl.ips {
+		addrs[i] = net.IPAddr{IP: net.ParseIP(ip)}
+	}
+	return addrs, nil
+}
+
+func (fl *fakeLookup) set(err error, ips ...string) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.err, fl.ips = err, ips
+}
+
+func newTestFanOut(t *testing.T, ttl time.Duration) (*FanOut, *fakeLookup) {
+	t.Helper()
+	f, err := NewFanOut(FanOutOptions{TTL: ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl := &fakeLookup{}
+	f.lookup = fl.lookup
+	return f, fl
+}
+
+func TestFanOutPickRetriesFailedResolution(t *testing.T) {
+	f, fl := newTestFanOut(t, time.Hour)
+
+	fl.set(errors.New("temporary failure"))
+	if _, err := f.pick("example.com"); err == nil {
+		t.Fatal("got no error, want the resolution error")
+	}
+	fl.set(nil, "10.0.0.1", "10.0.0.2")
+	for _, want := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"} {
+		ip, err := f.pick("example.com")
+		if err != nil {
+			t.Fatalf("got %v, want the resolution to be retried", err)
+		} else if ip != want {
+			t.Errorf("got %s, want %s", ip, want)
+		}
+	}
+	if fl.calls != 2 {
+		t.Errorf("got %d lookups, want 2", fl.calls)
+	}
+}
+
+func TestFanOutPickReResolvesAfterTTL(t *testing.T) {
+	f, fl := newTestFanOut(t, 20*time.Millisecond)
+
+	fl.set(nil, "10.0.0.1")
+	if ip, _ := f.pick("example.com"); ip != "10.0.0.1" {
+		t.Fatalf("got %s, want 10.0.0.1", ip)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// A failed re-resolution keeps the last addresses
+	fl.set(errors.New("temporary failure"))
+	if ip, err := f.pick("example.com"); err != nil || ip != "10.0.0.1" {
+		t.Fatalf("got %s (%v), want the last address", ip, err)
+	}
+	fl.set(nil, "10.0.0.2")
+	if ip, _ := f.pick("example.com"); ip != "10.0.0.2" {
+		t.Errorf("got %s, want the re-resolved address", ip)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package transport
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// robotsMaxSize is the max number of bytes read from a robots.txt file
+	robotsMaxSize = 512 * 1024
+	// robotsTimeout is the timeout of a robots.txt fetch
+	robotsTimeout = 10 * time.Second
+	// robotsRetryInterval is the interval between the fetches of a robots.txt file that failed
+	robotsRetryInterval = time.Minute
+)
+
+// robotsEntry represents the robots.txt state of a host
+type robotsEntry struct {
+	mu      sync.Mutex
+	done    bool
+	retryAt time.Time
+}
+
+// applyRobots fetches the robots.txt file of the request's host and overrides the host limit
+// by its Crawl-delay (and Request-rate if enabled) directives. The file is fetched until a
+// definitive response (200 or 4xx), at most once per retry interval.
+func (t *Transport) applyRobots(req *http.Request, key string) {
+	v, _ := t.robots.LoadOrStore(key, &robotsEntry{})
+	e := v.(*robotsEntry)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done || time.Now().Before(e.retryAt) {
+		return
+	}
+	rules, ok := t.fetchRobots(req)
+	if !ok {
+		e.retryAt = time.Now().Add(robotsRetryInterval)
+		return
+	}
+	e.done = true
+	if rules == nil {
+		return
+	}
+	var interval time.Duration
+	if rules.crawlDelay > 0 {
+		interval = rules.crawlDelay
+	}
+	if t.robotsRequestRate && rules.requestRate > interval {
+		interval = rules.requestRate
+	}
+	if interval > 0 {
+		t.hosts.SetLimit(key, 1/interval.Seconds(), 1)
+	}
+}
+
+// fetchRobots fetches and parses the robots.txt file of the request's host.
+// It returns false unless the response is definitive; a 4xx response means no rules.
+// The fetch doesn't use the request's context so a canceled request can't fail it.
+func (t *Transport) fetchRobots(req *http.Request) (*robotsRules, bool) {
+	u := *req.URL
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = "/robots.txt", "", "", ""
+	ctx, cancel := context.WithTimeout(context.Background(), robotsTimeout)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, true
+	}
+	if t.userAgent != "" {
+		r.Header.Set("User-Agent", t.userAgent)
+	}
+	res, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, false
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return parseRobots(io.LimitReader(res.Body, robotsMaxSize), t.userAgent), true
+	case res.StatusCode >= 400 && res.StatusCode < 500:
+		return nil, true
+	}
+	return nil, false
+}
+
+// robotsRules represents the rate related robots.txt rules for a user agent
+type robotsRules struct {
+	crawlDelay  time.Duration
+	requestRate time.Duration // interval between requests
+}
+
+// parseRobots parses the rate related rules of the group that matches the given
+// user agent (or the "*" group if there is no match) from the given robots.txt content.
+func parseRobots(r io.Reader, userAgent string) *robotsRules {
+	ua := strings.ToLower(userAgent)
+	if i := strings.IndexAny(ua, "/ "); i >= 0 {
+		ua = ua[:i]
+	}
+
+	var matched, wildcard *robotsRules
+	var current []*robotsRules
+	inAgents := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+
+		switch k {
+		case "user-agent":
+			// Consecutive user-agent lines share the same group
+			if !inAgents {
+				current = nil
+			}
+			inAgents = true
+			rules := &robotsRules{}
+			agent := strings.ToLower(v)
+			if agent == "*" {
+				if wildcard == nil {
+					wildcard = rules
+				}
+			} else if ua != "" && strings.Contains(ua, agent) {
+				if matched == nil {
+					matched = rules
+				}
+			}
+			current = append(current, rules)
+		case "crawl-delay":
+			inAgents = false
+			if d, err := strconv.ParseFloat(v, 64); err == nil && d > 0 {
+				for _, rules := range current {
+					rules.crawlDelay = time.Duration(d * float64(time.Second))
+				}
+			}
+		case "request-rate":
+			inAgents = false
+			if d := parseRequestRate(v); d > 0 {
+				for _, rules := range current {
+					rules.requestRate = d
+				}
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	if matched != nil {
+		return matched
+	}
+	return wildcard
+}
+
+// parseRequestRate parses the given Request-rate value (e.g. "1/5", "1/10s", "30/1m")
+// and returns the interval between requests.
+func parseRequestRate(v string) time.Duration {
+	if f := strings.Fields(v); len(f) > 0 {
+		v = f[0] // ignore the optional time of day range
+	}
+	n, p, ok := strings.Cut(v, "/")
+	if !ok {
+		return 0
+	}
+	requests, err := strconv.ParseFloat(n, 64)
+	if err != nil || requests <= 0 {
+		return 0
+	}
+	unit := time.Second
+	switch {
+	case strings.HasSuffix(p, "s"):
+		p = strings.TrimSuffix(p, "s")
+	case strings.HasSuffix(p, "m"):
+		p, unit = strings.TrimSuffix(p, "m"), time.Minute
+	case strings.HasSuffix(p, "h"):
+		p, unit = strings.TrimSuffix(p, "h"), time.Hour
+	}
+	period, err := strconv.ParseFloat(p, 64)
+	if err != nil || period <= 0 {
+		return 0
+	}
+	return time.Duration(period * float64(unit) / requests)
+}
@@ -0,0 +1,111 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRobotsServer returns a server whose robots.txt responses have the given status codes
+// in order (the last one repeats) with a 10s Crawl-delay
+func newRobotsServer(t *testing.T, codes ...int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var fetches atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			return
+		}
+		n := int(fetches.Add(1))
+		code := codes[min(n, len(codes))-1]
+		w.WriteHeader(code)
+		if code == http.StatusOK {
+			w.Write([]byte("User-agent: *\nCrawl-delay: 10\n"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+// hasCrawlDelay returns whether the host limit of the given key is throttled by the Crawl-delay.
+// The second of two reservations waits for a whole token at the host rate.
+func hasCrawlDelay(tr *Transport, key string) bool {
+	tr.Hosts().Reserve(key)
+	return tr.Hosts().Reserve(key).Delay() > 5*time.Second
+}
+
+func TestApplyRobotsRetriesFailedFetch(t *testing.T) {
+	srv, fetches := newRobotsServer(t, http.StatusServiceUnavailable, http.StatusOK)
+	tr, err := New(Options{QPS: 1000, RobotsTxt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, srv.URL+"/page", nil)
+	key := HostKey(req)
+
+	tr.applyRobots(req, key)
+	if hasCrawlDelay(tr, key) {
+		t.Fatal("got a crawl delay after a failed fetch")
+	}
+
+	// The failed fetch is retried after the retry interval only
+	tr.applyRobots(req, key)
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("got %d fetches within the retry interval, want 1", got)
+	}
+	v, _ := tr.robots.Load(key)
+	v.(*robotsEntry).retryAt = time.Time{}
+	tr.applyRobots(req, key)
+	if !hasCrawlDelay(tr, key) {
+		t.Error("got no crawl delay after a successful fetch")
+	}
+
+	// A definitive response is fetched once
+	tr.applyRobots(req, key)
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("got %d fetches, want 2", got)
+	}
+}
+
+func TestApplyRobotsCanceledRequest(t *testing.T) {
+	srv, _ := newRobotsServer(t, http.StatusOK)
+	tr, err := New(Options{QPS: 1000, RobotsTxt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, srv.URL+"/page", nil).WithContext(ctx)
+	key := HostKey(req)
+
+	tr.applyRobots(req, key)
+	if !hasCrawlDelay(tr, key) {
+		t.Error("got no crawl delay after the fetch of a canceled request")
+	}
+}
+
+func TestApplyRobotsNotFound(t *testing.T) {
+	srv, fetches := newRobotsServer(t, http.StatusNotFound)
+	tr, err := New(Options{QPS: 1000, RobotsTxt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, srv.URL+"/page", nil)
+	key := HostKey(req)
+
+	tr.applyRobots(req, key)
+	tr.applyRobots(req, key)
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("got %d fetches, want 1", got)
+	}
+	if hasCrawlDelay(tr, key) {
+		t.Error("got a crawl delay without a robots.txt file")
+	}
+}
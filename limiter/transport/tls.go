@@ -0,0 +1,76 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions represents the TLS options of a base transport
+type TLSOptions struct {
+	// CertFile and KeyFile are the PEM encoded client certificate and key files (optional)
+	CertFile, KeyFile string
+	// CAFile is the PEM encoded CA certificates file which replaces the system roots (optional)
+	CAFile string
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool
+	// ServerName is the server name for SNI and the certificate verification (default the url host)
+	ServerName string
+	// MinVersion is the min TLS version (e.g. tls.VersionTLS12, default the crypto/tls default)
+	MinVersion uint16
+}
+
+// Config returns the TLS config by the options
+func (o TLSOptions) Config() (*tls.Config, error) {
+	c := &tls.Config{
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		ServerName:         o.ServerName,
+		MinVersion:         o.MinVersion,
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("both cert and key files are required")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", o.CAFile)
+		}
+	}
+	return c, nil
+}
+
+// ParseTLSVersion returns the TLS version by the given name ("1.0", "1.1", "1.2" or "1.3").
+// An empty name returns zero (the crypto/tls default).
+func ParseTLSVersion(name string) (uint16, error) {
+	switch name {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("invalid tls version %q", name)
+}
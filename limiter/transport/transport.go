@@ -0,0 +1,83 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+// Package transport provides a rate limited http.RoundTripper
+package transport
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/devfacet/gorate/limiter"
+)
+
+// Options represents the options that can be set when creating a new transport
+type Options struct {
+	// Base is the underlying round tripper (default http.DefaultTransport)
+	Base http.RoundTripper
+	// QPS is the limit for the number of requests per second per host
+	QPS float64
+	// Burst is the burst size per host (default 1)
+	Burst int
+	// RobotsTxt enables fetching the robots.txt file of every host and honoring its Crawl-delay
+	// directive, which overrides the QPS for that host
+	RobotsTxt bool
+	// RobotsRequestRate enables honoring the Request-rate directive as well (requires RobotsTxt)
+	RobotsRequestRate bool
+	// UserAgent is the user agent for matching the robots.txt groups and fetching the files
+	UserAgent string
+}
+
+// New creates a new transport by the given options
+func New(o Options) (*Transport, error) {
+	kl, err := limiter.NewKeyed(limiter.KeyedOptions{QPS: o.QPS, Burst: o.Burst})
+	if err != nil {
+		return nil, err
+	}
+	base := o.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{
+		base:              base,
+		hosts:             kl,
+		robotsTxt:         o.RobotsTxt,
+		robotsRequestRate: o.RobotsRequestRate,
+		userAgent:         o.UserAgent,
+	}, nil
+}
+
+// Transport represents a round tripper that limits the requests per destination host
+type Transport struct {
+	base              http.RoundTripper
+	hosts             *limiter.KeyedLimiter
+	robotsTxt         bool
+	robotsRequestRate bool
+	userAgent         string
+	robots            sync.Map
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := HostKey(req)
+	if t.robotsTxt {
+		t.applyRobots(req, key)
+	}
+	if err := t.hosts.Wait(req.Context(), key); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Hosts returns the keyed limiter that holds the per host limiters
+func (t *Transport) Hosts() *limiter.KeyedLimiter {
+	return t.hosts
+}
+
+// HostKey returns the limiter key of the given request's destination host
+func HostKey(req *http.Request) string {
+	return strings.ToLower(req.URL.Host)
+}
@@ -0,0 +1,212 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultSyncTokens is the default number of tokens leased from the store at once
+	defaultSyncTokens = 10
+	// defaultSyncInterval is the default max age of the leased tokens
+	defaultSyncInterval = 100 * time.Millisecond
+	// defaultLeaseIdleTTL is the default idle TTL of the leases
+	defaultLeaseIdleTTL = time.Minute
+)
+
+// TwoTierOptions represents the options that can be set when creating a new two-tier limiter
+type TwoTierOptions struct {
+	// Remote is the store limiter that holds the global budgets
+	Remote *StoreLimiter
+	// SyncTokens is the number of tokens leased from the remote tier at once (default 10).
+	// It must not exceed the burst size of the remote tier.
+	SyncTokens int
+	// SyncInterval is the max age of the leased tokens (default 100ms).
+	// Unused tokens are dropped after that so a node can't hold back the global budget.
+	SyncInterval time.Duration
+	// IdleTTL is the duration after which the leases of the keys that aren't accessed are evicted
+	// (default 1m or SyncInterval if longer). The sweeper runs in the background until Close is called.
+	IdleTTL time.Duration
+	// MaxKeys is the hard cap on the number of leases (optional).
+	// It's enforced by evicting the lease of the least recently used key.
+	MaxKeys int
+}
+
+// NewTwoTier creates a new two-tier limiter by the given options
+func NewTwoTier(o TwoTierOptions) (*TwoTierLimiter, error) {
+	if o.Remote == nil {
+		return nil, errors.New("remote limiter is required")
+	} else if o.SyncTokens < 0 || o.SyncInterval < 0 {
+		return nil, errors.New("sync tokens and sync interval values must be positive")
+	} else if o.IdleTTL < 0 || o.MaxKeys < 0 {
+		return nil, errors.New("idle ttl and max keys values must be positive")
+	}
+	if o.SyncTokens == 0 {
+		o.SyncTokens = defaultSyncTokens
+	}
+	if o.SyncTokens > o.Remote.burst {
+		return nil, errors.New("sync tokens value must not exceed the remote burst value")
+	}
+	if o.SyncInterval == 0 {
+		o.SyncInterval = defaultSyncInterval
+	}
+	if o.IdleTTL == 0 {
+		o.IdleTTL = max(defaultLeaseIdleTTL, o.SyncInterval)
+	}
+	tl := &TwoTierLimiter{
+		remote:       o.Remote,
+		syncTokens:   o.SyncTokens,
+		syncInterval: o.SyncInterval,
+		idleTTL:      o.IdleTTL,
+		maxKeys:      o.MaxKeys,
+		leases:       make(map[string]*lease),
+		closed:       make(chan struct{}),
+	}
+	go tl.runSweeper(sweepInterval(tl.idleTTL))
+	return tl, nil
+}
+
+// TwoTierLimiter represents a limiter with a fast local tier in front of a remote (store) tier.
+// The local tier leases a slice of the global budget from the remote tier and serves from it,
+// so the remote tier is called at most once per SyncTokens tokens or SyncInterval.
+// The leases are evicted by the same idle TTL and max keys policies as the keyed limiter.
+type TwoTierLimiter struct {
+	remote       *StoreLimiter
+	syncTokens   int
+	syncInterval time.Duration
+	idleTTL      time.Duration
+	maxKeys      int
+	mu           sync.Mutex
+	leases       map[string]*lease
+	closed       chan struct{}
+	closeOnce    sync.Once
+	allowed      atomic.Int64
+	rejected     atomic.Int64
+}
+
+// lease represents the tokens leased from the remote tier for a key
+type lease struct {
+	mu      sync.Mutex
+	tokens  int
+	expires time.Time
+	// lastSeen is the last access time (unix nano)
+	lastSeen atomic.Int64
+}
+
+// lastAccess returns the last access time (unix nano) of the lease
+func (l *lease) lastAccess() int64 {
+	return l.lastSeen.Load()
+}
+
+// Check reports whether a query for the given key may happen now.
+// If not, it returns the duration until the next token is available.
+func (tl *TwoTierLimiter) Check(ctx context.Context, key string) (bool, time.Duration) {
+	ok, retryAfter := tl.check(ctx, key)
+	if ok {
+		tl.allowed.Add(1)
+	} else {
+		tl.rejected.Add(1)
+	}
+	return ok, retryAfter
+}
+
+// check makes the decision for Check
+func (tl *TwoTierLimiter) check(ctx context.Context, key string) (bool, time.Duration) {
+	now := time.Now()
+	tl.mu.Lock()
+	l, ok := tl.leases[key]
+	if !ok {
+		evictLRU(tl.leases, tl.maxKeys, (*lease).lastAccess)
+		l = &lease{}
+		tl.leases[key] = l
+	}
+	l.lastSeen.Store(now.UnixNano())
+	tl.mu.Unlock()
+
+	// Local tier
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tokens > 0 && now.Before(l.expires) {
+		l.tokens--
+		return true, 0
+	}
+
+	// Remote tier
+	ok, retryAfter := tl.remote.CheckN(ctx, key, tl.syncTokens)
+	if ok {
+		l.tokens, l.expires = tl.syncTokens-1, now.Add(tl.syncInterval)
+		return true, 0
+	}
+	// The global budget may still have less than a full lease left
+	if tl.syncTokens > 1 {
+		if ok, retryAfter = tl.remote.CheckN(ctx, key, 1); ok {
+			l.tokens = 0
+			return true, 0
+		}
+	}
+	return false, retryAfter
+}
+
+// Allow reports whether a query for the given key may happen now
+func (tl *TwoTierLimiter) Allow(key string) bool {
+	ok, _ := tl.Check(context.Background(), key)
+	return ok
+}
+
+// Wait blocks until a query for the given key may happen
+func (tl *TwoTierLimiter) Wait(ctx context.Context, key string) error {
+	return tl.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until n queries for the given key may happen.
+// The tokens are taken one by one so a canceled wait may consume some of them.
+func (tl *TwoTierLimiter) WaitN(ctx context.Context, key string, n int) error {
+	return waitTokens(ctx, n, func() (bool, time.Duration) { return tl.check(ctx, key) }, &tl.allowed, &tl.rejected)
+}
+
+// Stats returns the statistics
+func (tl *TwoTierLimiter) Stats() Stats {
+	return Stats{Allowed: int(tl.allowed.Load()), Rejected: int(tl.rejected.Load())}
+}
+
+// Len returns the number of leases
+func (tl *TwoTierLimiter) Len() int {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return len(tl.leases)
+}
+
+// runSweeper evicts the idle leases every interval until the limiter is closed.
+// An idle lease has expired unless the idle TTL is shorter than the sync interval.
+func (tl *TwoTierLimiter) runSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tl.closed:
+			return
+		case now := <-ticker.C:
+			tl.sweep(now)
+		}
+	}
+}
+
+// sweep evicts the leases that weren't accessed within the idle TTL
+func (tl *TwoTierLimiter) sweep(now time.Time) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	evictIdle(tl.leases, now.Add(-tl.idleTTL).UnixNano(), (*lease).lastAccess)
+}
+
+// Close stops the background work (idle lease sweeper) of the limiter
+func (tl *TwoTierLimiter) Close() {
+	tl.closeOnce.Do(func() { close(tl.closed) })
+}
@@ -0,0 +1,101 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestTwoTier creates a new two-tier limiter over a memory store for the tests
+func newTestTwoTier(t *testing.T, qps float64, burst int, o TwoTierOptions) *TwoTierLimiter {
+	t.Helper()
+	remote, err := NewStoreLimiter(StoreOptions{Store: NewMemoryStore(), QPS: qps, Burst: burst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Remote = remote
+	tl, err := NewTwoTier(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tl.Close)
+	return tl
+}
+
+func TestTwoTierMaxKeys(t *testing.T) {
+	tl := newTestTwoTier(t, 1, 10, TwoTierOptions{MaxKeys: 100})
+	for i := 0; i < 1000; i++ {
+		tl.Allow(fmt.Sprintf("key-%d", i))
+	}
+	if got := tl.Len(); got != 100 {
+		t.Errorf("got %d leases, want 100", got)
+	}
+
+	// The least recently used lease is evicted
+	tl.Allow("key-900")
+	tl.Allow("key-new")
+	tl.mu.Lock()
+	_, recent := tl.leases["key-900"]
+	_, oldest := tl.leases["key-901"]
+	tl.mu.Unlock()
+	if !recent || oldest {
+		t.Errorf("got recent lease %v and oldest lease %v, want only the recent one", recent, oldest)
+	}
+}
+
+func TestTwoTierIdleTTL(t *testing.T) {
+	tl := newTestTwoTier(t, 1000, 10, TwoTierOptions{IdleTTL: time.Hour})
+	for i := 0; i < 100; i++ {
+		tl.Allow(fmt.Sprintf("key-%d", i))
+	}
+	tl.sweep(time.Now())
+	if got := tl.Len(); got != 100 {
+		t.Errorf("got %d leases after an early sweep, want 100", got)
+	}
+	tl.sweep(time.Now().Add(time.Hour + time.Second))
+	if got := tl.Len(); got != 0 {
+		t.Errorf("got %d leases after the idle ttl, want 0", got)
+	}
+
+	// An evicted lease is renewed from the remote tier
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tl.Wait(ctx, "key-1"); err != nil {
+		t.Error(err)
+	}
+	if got := tl.Len(); got != 1 {
+		t.Errorf("got %d leases, want 1", got)
+	}
+}
+
+func TestTwoTierWaitRejections(t *testing.T) {
+	tl := newTestTwoTier(t, 20, 1, TwoTierOptions{SyncTokens: 1})
+	if err := tl.WaitN(context.Background(), "key", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := tl.Stats(); got.Allowed != 3 || got.Rejected != 1 {
+		t.Errorf("got stats %+v, want 3 allowed and 1 rejected", got)
+	}
+	if err := tl.Wait(context.Background(), "key"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tl.Stats(); got.Allowed != 4 || got.Rejected != 2 {
+		t.Errorf("got stats %+v, want 4 allowed and 2 rejected", got)
+	}
+}
+
+func TestTwoTierReserveEvicted(t *testing.T) {
+	tl := newTestTwoTier(t, 1, 10, TwoTierOptions{})
+	r := tl.Reserve(context.Background(), "key")
+	if !r.OK() {
+		t.Fatal("reservation failed")
+	}
+	tl.sweep(time.Now().Add(time.Hour))
+	r.Cancel()
+}
@@ -0,0 +1,109 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultUsageRetention is the default number of usage windows kept per key
+const defaultUsageRetention = 24
+
+// UsageRecord represents the usage of a key within a window
+type UsageRecord struct {
+	// Key is the key
+	Key string `json:"key"`
+	// Start is the start of the window
+	Start time.Time `json:"start"`
+	// End is the end of the window
+	End time.Time `json:"end"`
+	// Count is the number of tokens consumed within the window
+	Count int64 `json:"count"`
+}
+
+// usageBucket represents the usage of a window
+type usageBucket struct {
+	start time.Time
+	count int64
+}
+
+// recordUsage records the given number of consumed tokens for the entry.
+// It must be called while holding the entry lock.
+func (kl *KeyedLimiter) recordUsage(e *keyedEntry, now time.Time, n int) {
+	if kl.usageWindow <= 0 {
+		return
+	}
+	start := now.Truncate(kl.usageWindow)
+	if l := len(e.usage); l > 0 && e.usage[l-1].start.Equal(start) {
+		e.usage[l-1].count += int64(n)
+		return
+	}
+	e.usage = append(e.usage, usageBucket{start: start, count: int64(n)})
+	if l := len(e.usage); l > kl.usageRetention {
+		e.usage = append(e.usage[:0], e.usage[l-kl.usageRetention:]...)
+	}
+}
+
+// RangeUsage calls the given function for every usage record of a snapshot, ordered
+// by key and window start, until the function returns false.
+func (kl *KeyedLimiter) RangeUsage(fn func(r UsageRecord) bool) {
+	for _, r := range kl.Usage() {
+		if !fn(r) {
+			return
+		}
+	}
+}
+
+// Usage returns a snapshot of the usage records ordered by key and window start
+func (kl *KeyedLimiter) Usage() []UsageRecord {
+	var records []UsageRecord
+	for _, e := range kl.snapshot() {
+		e.mu.Lock()
+		for _, b := range e.usage {
+			records = append(records, UsageRecord{Key: e.key, Start: b.start, End: b.start.Add(kl.usageWindow), Count: b.count})
+		}
+		e.mu.Unlock()
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Key != records[j].Key {
+			return records[i].Key < records[j].Key
+		}
+		return records[i].Start.Before(records[j].Start)
+	})
+	return records
+}
+
+// WriteUsageJSON writes a snapshot of the usage records as a JSON array to the given writer
+func (kl *KeyedLimiter) WriteUsageJSON(w io.Writer) error {
+	records := kl.Usage()
+	if records == nil {
+		records = []UsageRecord{}
+	}
+	return json.NewEncoder(w).Encode(records)
+}
+
+// WriteUsageCSV writes a snapshot of the usage records as CSV (with a header) to the given writer
+func (kl *KeyedLimiter) WriteUsageCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"key", "start", "end", "count"}); err != nil {
+		return err
+	}
+	var err error
+	kl.RangeUsage(func(r UsageRecord) bool {
+		err = cw.Write([]string{r.Key, r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), strconv.FormatInt(r.Count, 10)})
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,260 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// OptionError represents an invalid option
+type OptionError struct {
+	// Field is the name of the option field
+	Field string
+	// Message describes the problem
+	Message string
+}
+
+// Error implements the error interface
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("invalid %s option: %s", e.Field, e.Message)
+}
+
+// validate validates the given options and returns all the violations as a joined error
+// of OptionErrors, or nil if the options are valid
+func validate(o Options) error {
+	var errs []error
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, &OptionError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Durations
+	for _, d := range []struct {
+		field string
+		value time.Duration
+	}{
+		{"Duration", o.Duration},
+		{"CostWindow", o.CostWindow},
+		{"FeedbackInterval", o.FeedbackInterval},
+		{"InstrumentInterval", o.InstrumentInterval},
+		{"ProgressInterval", o.ProgressInterval},
+		{"HealthCheckInterval", o.HealthCheckInterval},
+		{"QueryTimeout", o.QueryTimeout},
+		{"BatchMaxWait", o.BatchMaxWait},
+		{"WatchdogInterval", o.WatchdogInterval},
+		{"MaxGCPause", o.MaxGCPause},
+		{"StaggerStart", o.StaggerStart},
+		{"BusyPoll", o.BusyPoll},
+		{"RateErrorRetryDelay", o.RateErrorRetryDelay},
+	} {
+		if d.value < 0 {
+			add(d.field, "must not be negative")
+		}
+	}
+
+	// Concurrency
+	if o.AutoTune {
+		concurrency, maxConcurrency := o.Concurrency, o.MaxConcurrency
+		if concurrency == 0 {
+			concurrency = 1
+		}
+		if maxConcurrency == 0 {
+			maxConcurrency = defaultMaxConcurrency
+		}
+		if o.QPS == 0 {
+			add("QPS", "auto-tuner requires qps value")
+		}
+		if maxConcurrency < concurrency {
+			add("MaxConcurrency", "must be greater than concurrency value")
+		}
+	} else if o.Concurrency == 0 {
+		add("Concurrency", "must be greater than zero")
+	}
+
+	// Rate
+	if o.QPS > 0 && o.BatchSize > o.QPS {
+		add("BatchSize", "must not exceed qps value")
+	}
+	if o.Overdraft > 0 && o.QPS == 0 {
+		add("Overdraft", "overdraft requires qps value")
+	}
+	if o.CostLimit < 0 {
+		add("CostLimit", "must not be negative")
+	} else if math.IsNaN(o.CostLimit) || math.IsInf(o.CostLimit, 0) {
+		add("CostLimit", "must be a finite number")
+	}
+	if o.Adaptive {
+		if o.QPS == 0 {
+			add("QPS", "adaptive mode requires qps value")
+		}
+		if o.FeedbackInterval > 0 {
+			add("Adaptive", "adaptive mode can't be used with the feedback controller")
+		}
+	}
+	for i, b := range o.Blackouts {
+		if err := b.validate(); err != nil {
+			add(fmt.Sprintf("Blackouts[%d]", i), "%s", err)
+		}
+	}
+	for i, wh := range o.Webhooks {
+		if err := wh.validate(); err != nil {
+			add(fmt.Sprintf("Webhooks[%d]", i), "%s", err)
+		}
+	}
+	if o.HealthCheck != nil {
+		if o.QPS == 0 {
+			add("QPS", "health check requires qps value")
+		}
+		if o.Adaptive || o.FeedbackInterval > 0 {
+			add("HealthCheck", "health check can't be used with the adaptive mode or the feedback controller")
+		}
+		if o.HealthyThreshold < 0 {
+			add("HealthyThreshold", "must not be negative")
+		}
+		if o.SafeQPS > o.QPS {
+			add("SafeQPS", "must not exceed qps value")
+		}
+	}
+
+	// Watchdog
+	if o.MaxGoroutines < 0 {
+		add("MaxGoroutines", "must not be negative")
+	}
+	if o.WatchdogInterval == 0 && (o.MaxGoroutines > 0 || o.MaxHeapBytes > 0 || o.MaxGCPause > 0) {
+		add("WatchdogInterval", "watchdog thresholds require watchdog interval value")
+	}
+
+	// Sink
+	if o.SinkBuffer < 0 {
+		add("SinkBuffer", "must not be negative")
+	}
+	if o.SinkSpillMaxSize < 0 {
+		add("SinkSpillMaxSize", "must not be negative")
+	}
+	if o.SinkBackpressure != BackpressureBlock && o.SinkBuffer == 0 {
+		add("SinkBackpressure", "requires sink buffer value")
+	}
+
+	// Group limits
+	// The auto-tuner can add workers up to the max concurrency
+	maxGroupID := o.Concurrency
+	if o.AutoTune && o.MaxConcurrency > 0 {
+		maxGroupID = o.MaxConcurrency
+	} else if o.AutoTune {
+		maxGroupID = defaultMaxConcurrency
+	}
+	for id, gl := range o.GroupLimits {
+		if id < 1 || uint32(id) > maxGroupID {
+			add("GroupLimits", "group id %d is out of the concurrency range", id)
+		}
+		if gl.Duration < 0 {
+			add("GroupLimits", "duration of group %d must not be negative", id)
+		}
+	}
+
+	// Rate errors
+	if o.RateErrorPolicy < RateErrorStop || o.RateErrorPolicy > RateErrorReduce {
+		add("RateErrorPolicy", "unknown policy")
+	}
+
+	// Callback
+	// A run without a callback only paces the tokens, so the options of the queries (job mode) require one
+	if o.Callback == nil && o.BatchCallback == nil {
+		for _, f := range []struct {
+			field string
+			set   bool
+		}{
+			{"QueryTimeout", o.QueryTimeout > 0},
+			{"Middlewares", len(o.Middlewares) > 0},
+			{"Adaptive", o.Adaptive},
+			{"IsThrottle", o.IsThrottle != nil},
+			{"ErrorClassifier", o.ErrorClassifier != nil},
+		} {
+			if f.set {
+				add("Callback", "%s requires callback or batch callback", f.field)
+			}
+		}
+	}
+
+	// Batch mode
+	if o.BatchCallback != nil {
+		if o.Callback != nil {
+			add("BatchCallback", "can't be used with the callback")
+		}
+		if o.BatchMaxSize < 2 {
+			add("BatchMaxSize", "must be greater than one")
+		} else if !o.AutoTune && o.Concurrency < o.BatchMaxSize {
+			add("BatchMaxSize", "must not exceed concurrency value")
+		}
+	}
+
+	// Schedule replay
+	if len(o.Schedule) > 0 {
+		if o.QPS > 0 || o.Adaptive || o.FeedbackInterval > 0 {
+			add("Schedule", "can't be used with qps value")
+		}
+		if o.Schedule[0] < 0 {
+			add("Schedule", "offsets must not be negative")
+		}
+		for i := 1; i < len(o.Schedule); i++ {
+			if o.Schedule[i] < o.Schedule[i-1] {
+				add("Schedule", "offsets must be ordered")
+				break
+			}
+		}
+	}
+
+	// Limits
+	if o.Limit > 0 && o.Limit < o.Concurrency {
+		add("Limit", "must be greater than concurrency value")
+	} else if o.Limit == 0 && o.Duration == 0 && len(o.Schedule) == 0 && !isGroupLimited(o) {
+		add("Limit", "set either limit or duration value")
+	}
+
+	return errors.Join(errs...)
+}
+
+// isGroupLimited returns whether every concurrency group of the given options has a limit
+func isGroupLimited(o Options) bool {
+	if o.AutoTune || len(o.GroupLimits) == 0 {
+		return false
+	}
+	for id := 1; id <= int(o.Concurrency); id++ {
+		if gl := o.GroupLimits[id]; gl.Limit == 0 && gl.Duration == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeOptions checks the given options against the base options of a shared limiter.
+// The unset limits of the given options are ignored and the set ones must match the base ones.
+func mergeOptions(base, o Options) error {
+	var errs []error
+	for _, f := range []struct {
+		field     string
+		base, val interface{}
+	}{
+		{"Concurrency", base.Concurrency, o.Concurrency},
+		{"QPS", base.QPS, o.QPS},
+		{"Limit", base.Limit, o.Limit},
+		{"Duration", base.Duration, o.Duration},
+		{"BatchSize", base.BatchSize, o.BatchSize},
+		{"MaxInFlight", base.MaxInFlight, o.MaxInFlight},
+		{"BytesPerSecond", base.BytesPerSecond, o.BytesPerSecond},
+		{"CostLimit", base.CostLimit, o.CostLimit},
+		{"CostWindow", base.CostWindow, o.CostWindow},
+		{"QueryTimeout", base.QueryTimeout, o.QueryTimeout},
+	} {
+		if !reflect.ValueOf(f.val).IsZero() && f.val != f.base {
+			errs = append(errs, &OptionError{Field: f.field, Message: fmt.Sprintf("%v conflicts with the shared limiter's %v", f.val, f.base)})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,131 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func FuzzValidate(f *testing.F) {
+	// Seeds of the odd durations and the overflow values
+	f.Add(uint32(1), uint32(10), uint32(100), uint32(1), int64(0), int64(0), 0.0, false, uint32(0), 0, int64(0))
+	f.Add(uint32(0), uint32(0), uint32(0), uint32(0), int64(0), int64(0), 0.0, false, uint32(0), 0, int64(0))
+	f.Add(uint32(10), uint32(5), uint32(0), uint32(0), int64(time.Second), int64(-1), 0.0, false, uint32(0), 0, int64(0))
+	f.Add(uint32(math.MaxUint32), uint32(math.MaxUint32), uint32(math.MaxUint32), uint32(math.MaxUint32), int64(math.MaxInt64), int64(math.MaxInt64), math.MaxFloat64, false, uint32(0), math.MaxInt, int64(math.MaxInt64))
+	f.Add(uint32(1), uint32(1), uint32(1), uint32(2), int64(math.MinInt64), int64(math.MinInt64), -1.0, true, uint32(math.MaxUint32), math.MinInt, int64(math.MinInt64))
+	f.Add(uint32(4), uint32(0), uint32(0), uint32(0), int64(1), int64(1), math.NaN(), true, uint32(2), 1, int64(-1))
+	f.Add(uint32(4), uint32(100), uint32(4), uint32(0), int64(0), int64(time.Nanosecond), math.Inf(1), false, uint32(0), 0, int64(time.Hour))
+	f.Fuzz(func(t *testing.T, concurrency, qps, limit, batchSize uint32, duration, queryTimeout int64, costLimit float64, autoTune bool, maxConcurrency uint32, sinkBuffer int, scheduleOffset int64) {
+		o := Options{
+			Concurrency:    concurrency,
+			QPS:            qps,
+			Limit:          limit,
+			BatchSize:      batchSize,
+			Duration:       time.Duration(duration),
+			QueryTimeout:   time.Duration(queryTimeout),
+			CostLimit:      costLimit,
+			AutoTune:       autoTune,
+			MaxConcurrency: maxConcurrency,
+			SinkBuffer:     sinkBuffer,
+			Callback:       func(cbp CallbackParams) error { return nil },
+		}
+		if scheduleOffset != 0 {
+			o.Schedule = Schedule{0, time.Duration(scheduleOffset)}
+		}
+		limiter, err := New(o)
+		if err != nil {
+			// Every violation is reported as an option error
+			for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+				var oe *OptionError
+				if !errors.As(e, &oe) {
+					t.Fatalf("got %T (%v), want an option error", e, e)
+				}
+			}
+			return
+		}
+
+		// A valid limiter can't be silently invalid
+		switch {
+		case limiter.concurrency == 0:
+			t.Fatal("got zero concurrency")
+		case limiter.duration < 0, limiter.queryTimeout < 0:
+			t.Fatalf("got negative durations %s and %s", limiter.duration, limiter.queryTimeout)
+		case math.IsNaN(costLimit) || math.IsInf(costLimit, 0) || costLimit < 0:
+			t.Fatalf("got cost limit %v", costLimit)
+		case limiter.limit == 0 && limiter.duration == 0 && len(limiter.schedule) == 0:
+			t.Fatal("got an unlimited run")
+		case qps > 0 && limiter.batchSize > qps:
+			t.Fatalf("got batch size %d over qps %d", limiter.batchSize, qps)
+		}
+	})
+}
+
+func TestValidateMessages(t *testing.T) {
+	_, err := New(Options{Concurrency: 1, Limit: 1, Duration: -time.Second, CostLimit: math.NaN(), SinkBuffer: -1, QueryTimeout: time.Second})
+	var oe *OptionError
+	if !errors.As(err, &oe) || oe.Field != "Duration" {
+		t.Fatalf("got %v, want a Duration option error", err)
+	}
+	for _, want := range []string{
+		"invalid Duration option: must not be negative",
+		"invalid CostLimit option: must be a finite number",
+		"invalid SinkBuffer option: must not be negative",
+		"invalid Callback option: QueryTimeout requires callback or batch callback",
+	} {
+		if !slices.Contains(strings.Split(err.Error(), "\n"), want) {
+			t.Errorf("got %q, want %q", err, want)
+		}
+	}
+}
+
+func TestValidateCallback(t *testing.T) {
+	callback := func(cbp CallbackParams) error { return nil }
+	for _, tc := range []struct {
+		name    string
+		options Options
+		valid   bool
+	}{
+		{"pacing", Options{Concurrency: 1, QPS: 10, Limit: 1}, true},
+		{"callback", Options{Concurrency: 1, QPS: 10, Limit: 1, QueryTimeout: time.Second, Callback: callback}, true},
+		{"query timeout", Options{Concurrency: 1, QPS: 10, Limit: 1, QueryTimeout: time.Second}, false},
+		{"middlewares", Options{Concurrency: 1, QPS: 10, Limit: 1, Middlewares: []Middleware{Recover()}}, false},
+		{"adaptive", Options{Concurrency: 1, QPS: 10, Limit: 1, Adaptive: true}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(tc.options)
+			if valid := err == nil; valid != tc.valid {
+				t.Errorf("got %v, want valid %v", err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		schedule Schedule
+		want     string
+	}{
+		{"negative single", Schedule{-time.Second}, "invalid Schedule option: offsets must not be negative"},
+		{"negative first", Schedule{-time.Second, 0}, "invalid Schedule option: offsets must not be negative"},
+		{"unordered", Schedule{time.Second, 0}, "invalid Schedule option: offsets must be ordered"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(Options{Concurrency: 1, Schedule: tc.schedule})
+			if err == nil || !slices.Contains(strings.Split(err.Error(), "\n"), tc.want) {
+				t.Errorf("got %v, want %q", err, tc.want)
+			}
+		})
+	}
+	if _, err := New(Options{Concurrency: 1, Schedule: Schedule{0}}); err != nil {
+		t.Errorf("got %v for a single zero offset", err)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrWatchdog is the cause of a run aborted by the watchdog (wrapped with the exceeded threshold)
+var ErrWatchdog = errors.New("watchdog threshold exceeded")
+
+// WatchdogStats represents the process statistics sampled by the watchdog
+type WatchdogStats struct {
+	// Samples is the number of samples
+	Samples int
+	// MaxGoroutines is the max number of goroutines
+	MaxGoroutines int
+	// MaxHeapBytes is the max number of allocated heap bytes
+	MaxHeapBytes uint64
+	// MaxGCPause is the longest GC pause
+	MaxGCPause time.Duration
+	// NumGC is the number of GC cycles during the run
+	NumGC uint32
+}
+
+// watchdog represents the state of the watchdog
+type watchdog struct {
+	mu    sync.Mutex
+	stats WatchdogStats
+}
+
+// runWatchdog samples the process statistics every interval until the given context is done and
+// aborts the run once a threshold is exceeded
+func (limiter *Limiter) runWatchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	startGC, lastGC := ms.NumGC, ms.NumGC
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			goroutines := runtime.NumGoroutine()
+			runtime.ReadMemStats(&ms)
+
+			// The pause buffer holds the last 256 pauses
+			var pause time.Duration
+			for n := ms.NumGC; n > lastGC && ms.NumGC-n < uint32(len(ms.PauseNs)); n-- {
+				if p := time.Duration(ms.PauseNs[(n+255)%256]); p > pause {
+					pause = p
+				}
+			}
+			lastGC = ms.NumGC
+
+			wd := limiter.watchdog
+			wd.mu.Lock()
+			wd.stats.Samples++
+			wd.stats.MaxGoroutines = max(wd.stats.MaxGoroutines, goroutines)
+			wd.stats.MaxHeapBytes = max(wd.stats.MaxHeapBytes, ms.HeapAlloc)
+			wd.stats.MaxGCPause = max(wd.stats.MaxGCPause, pause)
+			wd.stats.NumGC = ms.NumGC - startGC
+			wd.mu.Unlock()
+
+			var err error
+			switch {
+			case limiter.maxGoroutines > 0 && goroutines > limiter.maxGoroutines:
+				err = fmt.Errorf("%w: %d goroutines (max %d)", ErrWatchdog, goroutines, limiter.maxGoroutines)
+			case limiter.maxHeapBytes > 0 && ms.HeapAlloc > limiter.maxHeapBytes:
+				err = fmt.Errorf("%w: %d heap bytes (max %d)", ErrWatchdog, ms.HeapAlloc, limiter.maxHeapBytes)
+			case limiter.maxGCPause > 0 && pause > limiter.maxGCPause:
+				err = fmt.Errorf("%w: %s gc pause (max %s)", ErrWatchdog, pause, limiter.maxGCPause)
+			}
+			if err != nil {
+				limiter.setError(err)
+				limiter.StopWithCause(err)
+				return
+			}
+		}
+	}
+}
+
+// WatchdogStats returns the process statistics sampled by the watchdog during the current (or last) run
+func (limiter *Limiter) WatchdogStats() WatchdogStats {
+	w := runState(limiter, &limiter.watchdog)
+	if w == nil {
+		return WatchdogStats{}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
@@ -0,0 +1,256 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultWebhookCheckInterval is the default interval of the SLO and error rate checks
+	defaultWebhookCheckInterval = time.Second
+	// defaultWebhookMinQueries is the default number of queries before the error rate is checked
+	defaultWebhookMinQueries = 100
+	// defaultWebhookTimeout is the default timeout of the webhook requests
+	defaultWebhookTimeout = 10 * time.Second
+	// webhookQueueSize is the size of the queue of the pending notifications
+	webhookQueueSize = 64
+)
+
+// WebhookEvent represents a run lifecycle event
+type WebhookEvent string
+
+const (
+	// WebhookRunStart is the event posted once the workers of a run are started
+	WebhookRunStart WebhookEvent = "run_start"
+	// WebhookSLOBreach is the event posted once the p99 service latency exceeds the SLO latency
+	WebhookSLOBreach WebhookEvent = "slo_breach"
+	// WebhookErrorRate is the event posted once the error rate exceeds the threshold
+	WebhookErrorRate WebhookEvent = "error_rate"
+	// WebhookRunEnd is the event posted once a run is done
+	WebhookRunEnd WebhookEvent = "run_end"
+)
+
+// Webhook represents the webhook notifications of the run lifecycle events, so the long
+// unattended runs can alert via a relay (e.g. to Slack or PagerDuty). Every event is posted as a
+// JSON WebhookPayload. The breach events are posted once the threshold is exceeded and again only
+// after the run recovered in the meantime.
+type Webhook struct {
+	// URL is the URL the events are posted to
+	URL string
+	// Events are the events to post (default all)
+	Events []WebhookEvent
+	// Header is the header of the requests (optional, e.g. for the authorization)
+	Header http.Header
+	// Client is the HTTP client (default a client with a 10s timeout)
+	Client *http.Client
+	// SLOLatency is the p99 service latency objective (optional).
+	// Setting it enables the latency statistics.
+	SLOLatency time.Duration
+	// ErrorRate is the threshold of the ratio of the failed queries (optional, 0-1)
+	ErrorRate float64
+	// MinQueries is the number of queries before the error rate is checked (default 100)
+	MinQueries int
+	// CheckInterval is the interval of the SLO and error rate checks (default 1s)
+	CheckInterval time.Duration
+}
+
+// WebhookPayload represents the JSON payload of a webhook notification
+type WebhookPayload struct {
+	// Event is the event
+	Event WebhookEvent `json:"event"`
+	// Time is the time of the event
+	Time time.Time `json:"time"`
+	// Name is the name of the limiter
+	Name string `json:"name"`
+	// RunID is the unique id of the run
+	RunID string `json:"run_id"`
+	// Message is the human-readable description of the event
+	Message string `json:"message"`
+	// State is the state of the limiter (see Limiter.MarshalJSON)
+	State json.RawMessage `json:"state"`
+}
+
+// validate validates the webhook
+func (wh Webhook) validate() error {
+	if u, err := url.Parse(wh.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid url %q", wh.URL)
+	}
+	for _, e := range wh.Events {
+		switch e {
+		case WebhookRunStart, WebhookSLOBreach, WebhookErrorRate, WebhookRunEnd:
+		default:
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	if wh.SLOLatency < 0 || wh.CheckInterval < 0 {
+		return errors.New("durations must be positive")
+	} else if wh.ErrorRate < 0 || wh.ErrorRate > 1 {
+		return errors.New("error rate must be between 0 and 1")
+	} else if wh.MinQueries < 0 {
+		return errors.New("min queries must be positive")
+	}
+	return nil
+}
+
+// wants returns whether the webhook posts the given event
+func (wh Webhook) wants(e WebhookEvent) bool {
+	return len(wh.Events) == 0 || slices.Contains(wh.Events, e)
+}
+
+// webhookNotification represents a pending notification
+type webhookNotification struct {
+	webhook *Webhook
+	payload WebhookPayload
+}
+
+// webhookNotifier posts the notifications of a run in order by a single goroutine, so the
+// workers don't wait for the webhooks
+type webhookNotifier struct {
+	queue  chan webhookNotification
+	done   chan struct{}
+	once   sync.Once
+	errors *atomic.Int64
+}
+
+// newWebhookNotifier creates a new webhook notifier and starts it.
+// The failed notifications are counted by the given counter.
+func newWebhookNotifier(errors *atomic.Int64) *webhookNotifier {
+	n := &webhookNotifier{
+		queue:  make(chan webhookNotification, webhookQueueSize),
+		done:   make(chan struct{}),
+		errors: errors,
+	}
+	go func() {
+		defer close(n.done)
+		for wn := range n.queue {
+			if err := wn.webhook.post(wn.payload); err != nil {
+				n.errors.Add(1)
+			}
+		}
+	}()
+	return n
+}
+
+// close posts the pending notifications and stops the notifier
+func (n *webhookNotifier) close() {
+	n.once.Do(func() { close(n.queue) })
+	<-n.done
+}
+
+// post posts the given payload
+func (wh *Webhook) post(p WebhookPayload) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range wh.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := wh.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultWebhookTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook failed with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// notify queues the given event for the webhooks which post it
+func (limiter *Limiter) notify(e WebhookEvent, message string) {
+	for i := range limiter.webhooks {
+		limiter.notifyTo(&limiter.webhooks[i], e, message)
+	}
+}
+
+// notifyTo queues the given event for the given webhook unless it doesn't post the event.
+// The notification is dropped (and counted as an error) when the queue is full.
+func (limiter *Limiter) notifyTo(wh *Webhook, e WebhookEvent, message string) {
+	n := limiter.notifier
+	if n == nil || !wh.wants(e) {
+		return
+	}
+	state, err := limiter.MarshalJSON()
+	if err != nil {
+		n.errors.Add(1)
+		return
+	}
+	p := WebhookPayload{Event: e, Time: time.Now(), Name: limiter.name, RunID: limiter.RunID(), Message: message, State: state}
+	select {
+	case n.queue <- webhookNotification{webhook: wh, payload: p}:
+	default:
+		n.errors.Add(1)
+	}
+}
+
+// NumOfWebhookErrors returns the number of the webhook notifications which failed or were dropped
+func (limiter *Limiter) NumOfWebhookErrors() int {
+	return int(limiter.webhookErrors.Load())
+}
+
+// runWebhookChecks checks the SLO and the error rate thresholds of the given webhook every
+// interval until the given context is done
+func (limiter *Limiter) runWebhookChecks(ctx context.Context, i int) {
+	wh := &limiter.webhooks[i]
+	interval := wh.CheckInterval
+	if interval == 0 {
+		interval = defaultWebhookCheckInterval
+	}
+	minQueries := wh.MinQueries
+	if minQueries == 0 {
+		minQueries = defaultWebhookMinQueries
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// The breaches are posted once until the run recovers
+	var sloBreached, errorRateBreached bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if wh.SLOLatency > 0 {
+			p99 := limiter.LatencyStats().Service.P99
+			if over := p99 > wh.SLOLatency; over != sloBreached {
+				sloBreached = over
+				if over {
+					limiter.notifyTo(wh, WebhookSLOBreach, fmt.Sprintf("p99 latency %s exceeds the slo latency %s", p99, wh.SLOLatency))
+				}
+			}
+		}
+		if queries := limiter.NumOfQueries(); wh.ErrorRate > 0 && queries >= minQueries {
+			rate := float64(limiter.NumOfFailures()) / float64(queries)
+			if over := rate > wh.ErrorRate; over != errorRateBreached {
+				errorRateBreached = over
+				if over {
+					limiter.notifyTo(wh, WebhookErrorRate, fmt.Sprintf("error rate %.2f%% exceeds the threshold %.2f%%", rate*100, wh.ErrorRate*100))
+				}
+			}
+		}
+	}
+}
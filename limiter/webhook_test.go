@@ -0,0 +1,158 @@
+/*
+ * gorate
+ * For the full copyright and license information, please view the LICENSE.txt file.
+ */
+
+package limiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer represents a webhook relay which records the posted payloads
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []WebhookPayload
+	auth     []string
+}
+
+// newWebhookServer creates a new webhook server which responds by the given status
+func newWebhookServer(t *testing.T, status int) *webhookServer {
+	ws := &webhookServer{}
+	ws.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("got content type %q, want application/json", ct)
+		}
+		ws.mu.Lock()
+		ws.payloads = append(ws.payloads, p)
+		ws.auth = append(ws.auth, r.Header.Get("Authorization"))
+		ws.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ws.Close)
+	return ws
+}
+
+// events returns the events of the posted payloads
+func (ws *webhookServer) events() []WebhookEvent {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	events := make([]WebhookEvent, 0, len(ws.payloads))
+	for _, p := range ws.payloads {
+		events = append(events, p.Event)
+	}
+	return events
+}
+
+func TestWebhookSLOBreach(t *testing.T) {
+	ws := newWebhookServer(t, http.StatusOK)
+
+	// The p99 latency exceeds the slo, recovers while the fast queries pile up and exceeds it again
+	limiter, err := New(Options{
+		Name:        "test",
+		Concurrency: 10,
+		QPS:         10000,
+		Limit:       3060,
+		Webhooks:    []Webhook{{URL: ws.URL, SLOLatency: 10 * time.Millisecond, CheckInterval: 20 * time.Millisecond}},
+		Callback: func(cbp CallbackParams) error {
+			if cbp.Sequence <= 10 || cbp.Sequence > 3000 {
+				time.Sleep(30 * time.Millisecond)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []WebhookEvent{WebhookRunStart, WebhookSLOBreach, WebhookSLOBreach, WebhookRunEnd}
+	if got := ws.events(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for _, p := range ws.payloads {
+		if p.Name != "test" || p.RunID != limiter.RunID() || p.Message == "" || !json.Valid(p.State) {
+			t.Errorf("got payload %+v, want the limiter name, run id, message and state", p)
+		}
+	}
+	if got := limiter.NumOfWebhookErrors(); got != 0 {
+		t.Errorf("got %d webhook errors, want 0", got)
+	}
+}
+
+func TestWebhookEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		events []WebhookEvent
+		want   []WebhookEvent
+		errors int
+	}{
+		{name: "all", status: http.StatusOK, want: []WebhookEvent{WebhookRunStart, WebhookRunEnd}},
+		{name: "filtered", status: http.StatusOK, events: []WebhookEvent{WebhookRunEnd}, want: []WebhookEvent{WebhookRunEnd}},
+		{name: "failed", status: http.StatusInternalServerError, want: []WebhookEvent{WebhookRunStart, WebhookRunEnd}, errors: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := newWebhookServer(t, tt.status)
+			limiter, err := New(Options{
+				Concurrency: 1,
+				Limit:       5,
+				Webhooks:    []Webhook{{URL: ws.URL, Events: tt.events, Header: http.Header{"Authorization": {"Bearer token"}}}},
+				Callback:    func(cbp CallbackParams) error { return nil },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := limiter.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if got := ws.events(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got events %v, want %v", got, tt.want)
+			}
+			if got := limiter.NumOfWebhookErrors(); got != tt.errors {
+				t.Errorf("got %d webhook errors, want %d", got, tt.errors)
+			}
+			for _, auth := range ws.auth {
+				if auth != "Bearer token" {
+					t.Errorf("got authorization %q, want the webhook header", auth)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook Webhook
+		wantErr bool
+	}{
+		{name: "valid", webhook: Webhook{URL: "https://example.com/hook", Events: []WebhookEvent{WebhookRunEnd}, ErrorRate: 0.1}},
+		{name: "invalid url", webhook: Webhook{URL: "example.com/hook"}, wantErr: true},
+		{name: "unknown event", webhook: Webhook{URL: "https://example.com/hook", Events: []WebhookEvent{"run_paused"}}, wantErr: true},
+		{name: "negative slo", webhook: Webhook{URL: "https://example.com/hook", SLOLatency: -time.Second}, wantErr: true},
+		{name: "error rate above 1", webhook: Webhook{URL: "https://example.com/hook", ErrorRate: 1.5}, wantErr: true},
+		{name: "negative min queries", webhook: Webhook{URL: "https://example.com/hook", MinQueries: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.webhook.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}